@@ -0,0 +1,119 @@
+package courier
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/nyaruka/gocommon/httpx"
+)
+
+// returns middleware which sets the remote address of requests from trusted proxies to the client IP in their
+// X-Forwarded-For or X-Real-IP headers. Those headers are ignored for requests from anywhere else.
+func trustedRealIP(ips []net.IP, nets []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := remoteIP(r); ip != nil && ipInNetworks(ip, ips, nets) {
+				if clientIP := forwardedClientIP(r, ips, nets); clientIP != nil {
+					r.RemoteAddr = clientIP.String()
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// gets the client IP of a request from a trusted proxy. X-Forwarded-For hops are walked from the right, i.e. starting
+// with those added by the proxies nearest to us, and the first which isn't a trusted proxy is the client. Hops to the
+// left of that could have been sent by the client itself so can't be trusted. If there's no X-Forwarded-For header we
+// fall back to X-Real-IP.
+func forwardedClientIP(r *http.Request, ips []net.IP, nets []*net.IPNet) net.IP {
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+
+	if len(hops) > 0 {
+		var ip net.IP
+		for i := len(hops) - 1; i >= 0; i-- {
+			if ip = net.ParseIP(strings.TrimSpace(hops[i])); ip == nil {
+				return nil // can't trust anything past a hop we can't parse
+			}
+			if !ipInNetworks(ip, ips, nets) {
+				return ip
+			}
+		}
+		return ip // every hop was a trusted proxy so the leftmost is the client
+	}
+
+	return net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+}
+
+// checks whether the given request comes from an address allowed to make requests for the given channel
+func checkAllowedNetworks(ch Channel, r *http.Request) error {
+	allowed := parseNetworksConfig(ch.ConfigForKey(ConfigAllowedNetworks, nil))
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	ips, nets, err := httpx.ParseNetworks(allowed...)
+	if err != nil {
+		return fmt.Errorf("invalid %s config: %w", ConfigAllowedNetworks, err)
+	}
+
+	ip := remoteIP(r)
+	if ip == nil || !ipInNetworks(ip, ips, nets) {
+		return fmt.Errorf("address %s not allowed for channel", r.RemoteAddr)
+	}
+	return nil
+}
+
+// parses an allowed networks config value which can be a comma separated string or a list of strings
+func parseNetworksConfig(v any) []string {
+	var addrs []string
+
+	switch typed := v.(type) {
+	case string:
+		addrs = strings.Split(typed, ",")
+	case []string:
+		addrs = typed
+	case []any:
+		for _, a := range typed {
+			if s, ok := a.(string); ok {
+				addrs = append(addrs, s)
+			}
+		}
+	}
+
+	cleaned := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		if a = strings.TrimSpace(a); a != "" {
+			cleaned = append(cleaned, a)
+		}
+	}
+	return cleaned
+}
+
+// gets the IP of the remote address of the given request, which may or may not include a port
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func ipInNetworks(ip net.IP, ips []net.IP, nets []*net.IPNet) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
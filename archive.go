@@ -0,0 +1,207 @@
+package courier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/utils"
+)
+
+const (
+	incomingArchiveKey  = "incoming:archive:%s" // sorted set of raw requests for each channel, scored by received time
+	maxArchivedRequests = 10000                 // maximum number of raw requests archived for each channel
+	maxReplayRequests   = 1000
+
+	redactedHeaderValue = "**********"
+)
+
+// headers which are always redacted from archived requests as they can contain credentials
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// a raw incoming request which can be stored and later recreated to be processed again
+type rawRequest struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Host       string      `json:"host"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	RemoteAddr string      `json:"remote_addr"`
+	ReceivedOn time.Time   `json:"received_on"`
+}
+
+// creates a raw request from the given request, restoring its body so that it can still be read
+func newRawRequest(r *http.Request) (*rawRequest, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return &rawRequest{
+		Method:     r.Method,
+		URL:        r.URL.RequestURI(),
+		Host:       r.Host,
+		Header:     r.Header,
+		Body:       body,
+		RemoteAddr: r.RemoteAddr,
+		ReceivedOn: time.Now(),
+	}, nil
+}
+
+// returns a copy of this request with the values of secret headers, and of headers containing any of the given values,
+// redacted. Handlers which authenticate requests with those headers will reject the redacted request if it is replayed.
+func (rr *rawRequest) redacted(vals []string) *rawRequest {
+	c := *rr
+	c.Header = make(http.Header, len(rr.Header))
+
+	for k, vs := range rr.Header {
+		redact := slices.Contains(secretHeaders, k)
+		for _, v := range vs {
+			for _, rv := range vals {
+				if rv != "" && strings.Contains(v, rv) {
+					redact = true
+				}
+			}
+		}
+
+		if redact {
+			c.Header[k] = []string{redactedHeaderValue}
+		} else {
+			c.Header[k] = slices.Clone(vs)
+		}
+	}
+	return &c
+}
+
+// recreates the original request
+func (rr *rawRequest) request(ctx context.Context) (*http.Request, error) {
+	r, err := http.NewRequestWithContext(ctx, rr.Method, rr.URL, bytes.NewReader(rr.Body))
+	if err != nil {
+		return nil, err
+	}
+	r.Host = rr.Host
+	r.Header = rr.Header
+	r.RemoteAddr = rr.RemoteAddr
+	r.RequestURI = rr.URL
+	return r, nil
+}
+
+// routes the given raw request like any other request, returning the response status. The response itself goes nowhere
+// but is still recorded in the channel log.
+func (s *server) reprocess(rr *rawRequest) (int, error) {
+	// mark the request as being reprocessed so that our handler wrapper doesn't archive or queue it again
+	ctx := WithReprocessing(context.Background())
+
+	r, err := rr.request(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+
+	return w.Code, nil
+}
+
+// archives the given request for the given channel, trimming anything older than the archive window and the oldest
+// requests if the channel has more than our maximum
+func archiveIncoming(rp *redis.Pool, ch Channel, rr *rawRequest, window time.Duration) error {
+	payload, err := json.Marshal(rr)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf(incomingArchiveKey, ch.UUID())
+	now := rr.ReceivedOn.UnixMilli()
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	rc.Send("MULTI")
+	rc.Send("ZADD", key, now, payload)
+	rc.Send("ZREMRANGEBYSCORE", key, "-inf", now-window.Milliseconds())
+	rc.Send("ZREMRANGEBYRANK", key, 0, -(maxArchivedRequests + 1))
+	rc.Send("EXPIRE", key, int(window/time.Second))
+	_, err = rc.Do("EXEC")
+	return err
+}
+
+type replayRequest struct {
+	ChannelUUID ChannelUUID `json:"channel_uuid" validate:"required,uuid"`
+	After       time.Time   `json:"after"`
+	Before      time.Time   `json:"before"`
+}
+
+type replayedRequest struct {
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	ReceivedOn time.Time `json:"received_on"`
+	Status     int       `json:"status"`
+}
+
+type replayResponse struct {
+	Replayed []*replayedRequest `json:"replayed"`
+}
+
+// replays the archived requests of a channel received in the given time range. We rely on handlers deduping messages
+// and statuses to avoid creating them twice.
+func replayIncoming(ctx context.Context, s *server, r *http.Request) (*replayResponse, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+
+	rr := &replayRequest{}
+	if err := json.Unmarshal(body, rr); err != nil {
+		return nil, fmt.Errorf("error unmarshalling request: %w", err)
+	}
+	if err := utils.Validate(rr); err != nil {
+		return nil, err
+	}
+
+	min, max := "-inf", "+inf"
+	if !rr.After.IsZero() {
+		min = fmt.Sprint(rr.After.UnixMilli())
+	}
+	if !rr.Before.IsZero() {
+		max = fmt.Sprintf("(%d", rr.Before.UnixMilli())
+	}
+
+	rc := s.backend.RedisPool().Get()
+	payloads, err := redis.ByteSlices(rc.Do("ZRANGEBYSCORE", fmt.Sprintf(incomingArchiveKey, rr.ChannelUUID), min, max, "LIMIT", 0, maxReplayRequests))
+	rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error reading archived requests: %w", err)
+	}
+
+	replayed := make([]*replayedRequest, 0, len(payloads))
+
+	for _, payload := range payloads {
+		if ctx.Err() != nil {
+			break
+		}
+
+		raw := &rawRequest{}
+		if err := json.Unmarshal(payload, raw); err != nil {
+			return nil, fmt.Errorf("error unmarshalling archived request: %w", err)
+		}
+
+		status, err := s.reprocess(raw)
+		if err != nil {
+			return nil, fmt.Errorf("error recreating archived request: %w", err)
+		}
+
+		replayed = append(replayed, &replayedRequest{Method: raw.Method, URL: raw.URL, ReceivedOn: raw.ReceivedOn, Status: status})
+	}
+
+	return &replayResponse{Replayed: replayed}, nil
+}
@@ -61,6 +61,7 @@ func fetchAttachment(ctx context.Context, b Backend, r *http.Request) (*fetchAtt
 	}
 
 	clog := NewChannelLogForAttachmentFetch(ch, GetHandler(ch.ChannelType()).RedactValues(ch))
+	clog.RedactPII(fa.URL)
 
 	attachment, err := FetchAndStoreAttachment(ctx, b, ch, fa.URL, clog)
 
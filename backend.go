@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
 )
@@ -31,6 +33,10 @@ type Backend interface {
 	// GetChannelByAddress returns the channel with the passed in type and address
 	GetChannelByAddress(context.Context, ChannelType, ChannelAddress) (Channel, error)
 
+	// InvalidateChannel evicts the channel with the passed in UUID, and its previous address if that has changed, from
+	// any cached copies so that it is reloaded
+	InvalidateChannel(context.Context, ChannelUUID, ChannelAddress) error
+
 	// GetContact returns (or creates) the contact for the passed in channel and URN
 	GetContact(context.Context, Channel, urns.URN, map[string]string, string, *ChannelLog) (Contact, error)
 
@@ -49,6 +55,11 @@ type Backend interface {
 	// WriteMsg writes the passed in message to our backend
 	WriteMsg(context.Context, MsgIn, *ChannelLog) error
 
+	// AcceptMsg checks an incoming message which won't be written as a message, e.g. a keyword written as a channel
+	// event instead, returning ErrMsgDuplicate if it's a duplicate, and otherwise records it as received so that it's
+	// deduplicated if received again
+	AcceptMsg(context.Context, MsgIn, *ChannelLog) error
+
 	// NewStatusUpdate creates a new status update for the given message id
 	NewStatusUpdate(Channel, MsgID, MsgStatus, *ChannelLog) StatusUpdate
 
@@ -61,16 +72,29 @@ type Backend interface {
 	// NewChannelEvent creates a new channel event for the given channel and event type
 	NewChannelEvent(Channel, ChannelEventType, urns.URN, *ChannelLog) ChannelEvent
 
-	// WriteChannelEvent writes the passed in channel even returning any error
+	// WriteChannelEvent writes the passed in channel event, returning an error wrapping ErrMsgIgnored if it's from a
+	// blocked or rate limited URN
 	WriteChannelEvent(context.Context, ChannelEvent, *ChannelLog) error
 
 	// WriteChannelLog writes the passed in channel log to our backend
 	WriteChannelLog(context.Context, *ChannelLog) error
 
+	// GetChannelLogs returns the logs for the passed in channel which match the given query, newest first
+	GetChannelLogs(context.Context, Channel, *ChannelLogQuery) ([]*clogs.Log, error)
+
 	// PopNextOutgoingMsg returns the next message that needs to be sent, callers should call OnSendComplete with the
 	// returned message when they have dealt with the message (regardless of whether it was sent or not)
 	PopNextOutgoingMsg(context.Context) (MsgOut, error)
 
+	// RequeueOutgoingMsg returns a message that was popped with PopNextOutgoingMsg but won't be sent to the front of
+	// its queue, e.g. because we're shutting down
+	RequeueOutgoingMsg(context.Context, MsgOut) error
+
+	// DeferOutgoingMsg returns a message that was popped with PopNextOutgoingMsg but can't be sent yet to its queue so
+	// that it will be popped again after the given time, e.g. because the channel is in quiet hours or asked us to retry
+	// later. It errors if the message can't be deferred, e.g. because it has already been deferred too many times
+	DeferOutgoingMsg(context.Context, MsgOut, time.Time) error
+
 	// WasMsgSent returns whether the backend thinks the passed in message was already sent. This can be used in cases where
 	// a backend wants to implement a failsafe against double sending messages (say if they were double queued)
 	WasMsgSent(context.Context, MsgID) (bool, error)
@@ -21,14 +21,17 @@ import (
 
 	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/buger/jsonparser"
 	"github.com/gomodule/redigo/redis"
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/courier/utils/tracing"
+	"github.com/nyaruka/courier/webhooks"
 	"github.com/nyaruka/gocommon/aws/cwatch"
 	"github.com/nyaruka/gocommon/aws/dynamo"
 	"github.com/nyaruka/gocommon/aws/s3x"
-	"github.com/nyaruka/gocommon/cache"
 	"github.com/nyaruka/gocommon/dbutil"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/jsonx"
@@ -36,6 +39,8 @@ import (
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/gocommon/uuids"
 	"github.com/nyaruka/redisx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // the name for our message queue
@@ -44,6 +49,9 @@ const msgQueueName = "msgs"
 // our timeout for backend operations
 const backendTimeout = time.Second * 20
 
+// how many times an outgoing message can be deferred, after which it has to be errored instead
+const maxMsgDeferrals = 10
+
 var uuidRegex = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
 
 func init() {
@@ -54,18 +62,20 @@ type backend struct {
 	config *courier.Config
 
 	statusWriter *StatusWriter
-	dbLogWriter  *DBLogWriter     // unattached logs being written to the database
-	dyLogWriter  *DynamoLogWriter // all logs being written to dynamo
+	dbLogWriter  *DBLogWriter // unattached logs being written to the database
+	logWriter    *LogWriter   // all logs being written to our log sink
+	logSink      clogs.Sink
 	writerWG     *sync.WaitGroup
 
-	db     *sqlx.DB
-	rp     *redis.Pool
-	dynamo *dynamo.Service
-	s3     *s3x.Service
-	cw     *cwatch.Service
+	db         *sqlx.DB
+	readonlyDB *sqlx.DB // optional replica used for lookups
+	rp         *redis.Pool
+	dynamo     *dynamo.Service
+	s3         *s3x.Service
+	cw         *cwatch.Service
 
-	channelsByUUID *cache.Local[courier.ChannelUUID, *Channel]
-	channelsByAddr *cache.Local[courier.ChannelAddress, *Channel]
+	channelsByUUID *channelCache[courier.ChannelUUID]
+	channelsByAddr *channelCache[courier.ChannelAddress]
 
 	stopChan  chan bool
 	waitGroup *sync.WaitGroup
@@ -87,6 +97,9 @@ type backend struct {
 	// tracking of external ids of messages we've sent in case we need one before its status update has been written
 	sentExternalIDs *redisx.IntervalHash
 
+	// tracking of the last status written for each message so that out of order updates can't regress it
+	msgStatuses *redisx.IntervalHash
+
 	stats *StatsCollector
 
 	// both sqlx and redis provide wait stats which are cummulative that we need to convert into increments by
@@ -125,10 +138,11 @@ func newBackend(cfg *courier.Config) courier.Backend {
 		mediaCache:   redisx.NewIntervalHash("media-lookups", time.Hour*24, 2),
 		mediaMutexes: *syncx.NewHashMutex(8),
 
-		receivedMsgs:        redisx.NewIntervalHash("seen-msgs", time.Second*2, 2),        // 2 - 4 seconds
-		receivedExternalIDs: redisx.NewIntervalHash("seen-external-ids", time.Hour*24, 2), // 24 - 48 hours
-		sentIDs:             redisx.NewIntervalSet("sent-ids", time.Hour, 2),              // 1 - 2 hours
-		sentExternalIDs:     redisx.NewIntervalHash("sent-external-ids", time.Hour, 2),    // 1 - 2 hours
+		receivedMsgs:        redisx.NewIntervalHash("seen-msgs", maxDedupContentWindow, 2),            // 15 - 30 minutes
+		receivedExternalIDs: redisx.NewIntervalHash("seen-external-ids", maxDedupExternalIDWindow, 2), // 24 - 48 hours
+		sentIDs:             redisx.NewIntervalSet("sent-ids", time.Hour, 2),                          // 1 - 2 hours
+		sentExternalIDs:     redisx.NewIntervalHash("sent-external-ids", time.Hour, 2),                // 1 - 2 hours
+		msgStatuses:         redisx.NewIntervalHash("msg-statuses", time.Hour*24, 2),                  // 24 - 48 hours
 
 		stats: NewStatsCollector(),
 	}
@@ -161,27 +175,52 @@ func (b *backend) Start() error {
 		log.Info("db ok")
 	}
 
-	b.rp, err = redisx.NewPool(b.config.Redis, redisx.WithMaxActive(b.config.MaxWorkers*2))
+	// build our readonly db if we have one
+	if b.config.ReadonlyDB != "" {
+		b.readonlyDB, err = sqlx.Open("postgres", b.config.ReadonlyDB)
+		if err != nil {
+			return fmt.Errorf("unable to open readonly DB with config: '%s': %s", b.config.ReadonlyDB, err)
+		}
+
+		b.readonlyDB.SetMaxIdleConns(4)
+		b.readonlyDB.SetMaxOpenConns(16)
+
+		if err := b.readonlyDB.PingContext(ctx); err != nil {
+			log.Error("readonly db not reachable", "error", err)
+		} else {
+			log.Info("readonly db ok")
+		}
+	}
+
+	redisURL, err := parseRedisURL(b.config.Redis)
+	if err != nil {
+		return fmt.Errorf("invalid redis URL: %w", err)
+	}
+
+	b.rp, err = newRedisPool(redisURL, b.config.MaxWorkers*2)
 	if err != nil {
 		log.Error("redis not reachable", "error", err)
 	} else {
 		log.Info("redis ok")
 	}
 
-	// start our dethrottler if we are going to be doing some sending
+	// start our dethrottler and promoter of delayed messages if we are going to be doing some sending
 	if b.config.MaxWorkers > 0 {
 		queue.StartDethrottler(b.rp, b.stopChan, b.waitGroup, msgQueueName)
+		queue.StartPromoter(b.rp, b.stopChan, b.waitGroup, msgQueueName)
 	}
 
-	// setup DynamoDB
-	b.dynamo, err = dynamo.NewService(b.config.AWSAccessKeyID, b.config.AWSSecretAccessKey, b.config.DynamoAWSRegion, b.config.DynamoEndpoint, b.config.DynamoTablePrefix)
-	if err != nil {
-		return err
-	}
-	if err := b.dynamo.Test(ctx); err != nil {
-		log.Error("dynamodb not reachable", "error", err)
-	} else {
-		log.Info("dynamodb ok")
+	// setup DynamoDB if that's where channel logs are written
+	if b.config.ChannelLogSink == "dynamo" {
+		b.dynamo, err = dynamo.NewService(b.config.AWSAccessKeyID, b.config.AWSSecretAccessKey, b.config.DynamoAWSRegion, b.config.DynamoEndpoint, b.config.DynamoTablePrefix)
+		if err != nil {
+			return err
+		}
+		if err := b.dynamo.Test(ctx); err != nil {
+			log.Error("dynamodb not reachable", "error", err)
+		} else {
+			log.Info("dynamodb ok")
+		}
 	}
 
 	// setup S3 storage
@@ -203,11 +242,17 @@ func (b *backend) Start() error {
 	}
 
 	// create and start channel caches...
-	b.channelsByUUID = cache.NewLocal(b.loadChannelByUUID, time.Minute)
+	b.channelsByUUID = newChannelCache(b.loadChannelByUUID)
 	b.channelsByUUID.Start()
-	b.channelsByAddr = cache.NewLocal(b.loadChannelByAddress, time.Minute)
+	b.channelsByAddr = newChannelCache(b.loadChannelByAddress)
 	b.channelsByAddr.Start()
 
+	// and listen for changes to channels so we can evict them
+	b.startChannelInvalidator()
+
+	// and flush buffered parts of incoming messages which can't wait any longer for their other parts
+	b.startReassemblyFlusher()
+
 	// make sure our spool dirs are writable
 	err = courier.EnsureSpoolDirPresent(b.config.SpoolDir, "msgs")
 	if err == nil {
@@ -229,8 +274,15 @@ func (b *backend) Start() error {
 	b.dbLogWriter = NewDBLogWriter(b.db, b.writerWG)
 	b.dbLogWriter.Start()
 
-	b.dyLogWriter = NewDynamoLogWriter(b.dynamo, b.writerWG)
-	b.dyLogWriter.Start()
+	b.logSink, err = newLogSink(b.config, b.dynamo)
+	if err != nil {
+		return fmt.Errorf("unable to create channel log sink: %w", err)
+	}
+	b.logWriter = NewLogWriter(b.logSink, b.writerWG)
+	b.logWriter.Start()
+
+	// start our workers which deliver customer webhooks, which need our channel caches and log writer
+	webhooks.StartWorkers(b.rp, b.stopChan, b.waitGroup, webhookWorkers, b.sendWebhook)
 
 	// register and start our spool flushers
 	courier.RegisterFlusher(path.Join(b.config.SpoolDir, "msgs"), b.flushMsgFile)
@@ -297,17 +349,24 @@ func (b *backend) Cleanup() error {
 	if b.dbLogWriter != nil {
 		b.dbLogWriter.Stop()
 	}
-	if b.dyLogWriter != nil {
-		b.dyLogWriter.Stop()
+	if b.logWriter != nil {
+		b.logWriter.Stop()
 	}
 
 	// wait for them to flush fully
 	b.writerWG.Wait()
 
+	if b.logSink != nil {
+		b.logSink.Close()
+	}
+
 	// close our db and redis pool
 	if b.db != nil {
 		b.db.Close()
 	}
+	if b.readonlyDB != nil {
+		b.readonlyDB.Close()
+	}
 	return b.rp.Close()
 }
 
@@ -345,6 +404,18 @@ func (b *backend) GetChannelByAddress(ctx context.Context, typ courier.ChannelTy
 	return ch, nil
 }
 
+// InvalidateChannel publishes an invalidation of the given channel which all instances, including this one, will
+// receive and use to evict it from their caches
+func (b *backend) InvalidateChannel(ctx context.Context, uuid courier.ChannelUUID, address courier.ChannelAddress) error {
+	rc, err := b.rp.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return publishChannelInvalidation(rc, uuid, address)
+}
+
 // GetContact returns the contact for the passed in channel and URN
 func (b *backend) GetContact(ctx context.Context, c courier.Channel, urn urns.URN, authTokens map[string]string, name string, clog *courier.ChannelLog) (courier.Contact, error) {
 	dbChannel := c.(*Channel)
@@ -415,7 +486,7 @@ func (b *backend) NewIncomingMsg(channel courier.Channel, urn urns.URN, text str
 	msg.WithReceivedOn(time.Now().UTC())
 
 	// check if this message could be a duplicate and if so use the original's UUID
-	if prevUUID := b.checkMsgAlreadyReceived(msg); prevUUID != courier.NilMsgUUID {
+	if prevUUID := b.checkMsgAlreadyReceived(msg, clog); prevUUID != courier.NilMsgUUID {
 		msg.UUID_ = prevUUID
 		msg.alreadyWritten = true
 	}
@@ -473,13 +544,74 @@ func (b *backend) PopNextOutgoingMsg(ctx context.Context) (courier.MsgOut, error
 	dbMsg.Direction_ = MsgOutgoing
 	dbMsg.channel = channel.(*Channel)
 	dbMsg.workerToken = token
+	dbMsg.queuedJSON = msgJSON
 
 	// clear out our seen incoming messages
 	b.clearMsgSeen(dbMsg)
 
+	// this is a new send attempt, e.g. of a failed message being resent, so it can go back through earlier statuses
+	b.clearMsgStatus(dbMsg)
+
 	return dbMsg, nil
 }
 
+// RequeueOutgoingMsg pushes a popped message which won't be sent back onto the front of its queue
+func (b *backend) RequeueOutgoingMsg(ctx context.Context, msg courier.MsgOut) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "msg_requeue", trace.WithAttributes(msgAttrs(msg)...))
+	defer func() { tracing.EndSpan(span, err) }()
+
+	rc, err := b.rp.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dbMsg := msg.(*Msg)
+
+	return queue.Requeue(rc, msgQueueName, dbMsg.workerToken, dbMsg.queuedJSON, msgPriority(dbMsg))
+}
+
+// DeferOutgoingMsg pushes a popped message which can't be sent yet back onto its queue so that it isn't popped again
+// until the given time. Messages can only be deferred so many times so that they can't be deferred indefinitely.
+func (b *backend) DeferOutgoingMsg(ctx context.Context, msg courier.MsgOut, until time.Time) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "msg_defer", trace.WithAttributes(msgAttrs(msg)...))
+	defer func() { tracing.EndSpan(span, err) }()
+
+	dbMsg := msg.(*Msg)
+
+	if dbMsg.Deferrals_ >= maxMsgDeferrals {
+		return fmt.Errorf("message has already been deferred %d times", dbMsg.Deferrals_)
+	}
+
+	// record the deferral in the payload we push back onto the queue
+	payload, err := jsonparser.Set([]byte(dbMsg.queuedJSON), []byte(strconv.Itoa(dbMsg.Deferrals_+1)), "deferrals")
+	if err != nil {
+		return fmt.Errorf("error updating msg payload: %w", err)
+	}
+
+	rc, err := b.rp.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return queue.RequeueAt(rc, msgQueueName, dbMsg.workerToken, string(payload), msgPriority(dbMsg), until)
+}
+
+// returns the tracing attributes for the given outgoing message
+func msgAttrs(m courier.MsgOut) []attribute.KeyValue {
+	attrs := tracing.ChannelAttrs(string(m.Channel().UUID()), string(m.Channel().ChannelType()))
+	return append(attrs, tracing.AttrMsgID.Int64(int64(m.ID())))
+}
+
+// returns the priority of the queue that the given outgoing message was popped from
+func msgPriority(m *Msg) queue.Priority {
+	if m.HighPriority() {
+		return queue.HighPriority
+	}
+	return queue.LowPriority
+}
+
 // WasMsgSent returns whether the passed in message has already been sent
 func (b *backend) WasMsgSent(ctx context.Context, id courier.MsgID) (bool, error) {
 	rc := b.rp.Get()
@@ -492,11 +624,19 @@ func (b *backend) ClearMsgSent(ctx context.Context, id courier.MsgID) error {
 	rc := b.rp.Get()
 	defer rc.Close()
 
+	// a message being resent or retried is allowed to go back through earlier statuses
+	if err := b.msgStatuses.Del(rc, id.String()); err != nil {
+		return err
+	}
+
 	return b.sentIDs.Rem(rc, id.String())
 }
 
 // OnSendComplete is called when the sender has finished trying to send a message
 func (b *backend) OnSendComplete(ctx context.Context, msg courier.MsgOut, status courier.StatusUpdate, clog *courier.ChannelLog) {
+	ctx, span := tracing.Tracer().Start(ctx, "send_complete", trace.WithAttributes(msgAttrs(msg)...))
+	defer span.End()
+
 	rc := b.rp.Get()
 	defer rc.Close()
 
@@ -537,6 +677,11 @@ func (b *backend) WriteMsg(ctx context.Context, m courier.MsgIn, clog *courier.C
 	return writeMsg(timeout, b, m, clog)
 }
 
+// AcceptMsg checks an incoming message which won't be written as a message
+func (b *backend) AcceptMsg(ctx context.Context, m courier.MsgIn, clog *courier.ChannelLog) error {
+	return acceptMsg(b, m.(*Msg))
+}
+
 // NewStatusUpdateForID creates a new Status object for the given message id
 func (b *backend) NewStatusUpdate(channel courier.Channel, id courier.MsgID, status courier.MsgStatus, clog *courier.ChannelLog) courier.StatusUpdate {
 	return newStatusUpdate(channel, id, "", status, clog)
@@ -548,7 +693,14 @@ func (b *backend) NewStatusUpdateByExternalID(channel courier.Channel, externalI
 }
 
 // WriteStatusUpdate writes the passed in MsgStatus to our store
-func (b *backend) WriteStatusUpdate(ctx context.Context, status courier.StatusUpdate) error {
+func (b *backend) WriteStatusUpdate(ctx context.Context, status courier.StatusUpdate) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "status_write", trace.WithAttributes(
+		tracing.AttrChannelUUID.String(string(status.ChannelUUID())),
+		tracing.AttrMsgID.Int64(int64(status.MsgID())),
+		tracing.AttrMsgStatus.String(string(status.Status())),
+	))
+	defer func() { tracing.EndSpan(span, err) }()
+
 	log := slog.With("msg_id", status.MsgID(), "msg_external_id", status.ExternalID(), "status", status.Status())
 	su := status.(*StatusUpdate)
 
@@ -565,24 +717,34 @@ func (b *backend) WriteStatusUpdate(ctx context.Context, status courier.StatusUp
 		}
 	}
 
-	if status.MsgID() != courier.NilMsgID {
-		// this is a message we've just sent and were given an external id for
-		if status.ExternalID() != "" {
-			rc := b.rp.Get()
-			defer rc.Close()
+	rc := b.rp.Get()
+	defer rc.Close()
 
-			err := b.sentExternalIDs.Set(rc, fmt.Sprintf("%d|%s", su.ChannelID_, su.ExternalID_), fmt.Sprintf("%d", status.MsgID()))
-			if err != nil {
-				log.Error("error recording external id", "error", err)
-			}
+	// this is a message we've just sent and were given an external id for
+	if status.MsgID() != courier.NilMsgID && status.ExternalID() != "" {
+		err := b.sentExternalIDs.Set(rc, fmt.Sprintf("%d|%s", su.ChannelID_, su.ExternalID_), fmt.Sprintf("%d", status.MsgID()))
+		if err != nil {
+			log.Error("error recording external id", "error", err)
 		}
+	}
 
-		// we sent a message that errored so clear our sent flag to allow it to be retried
-		if status.Status() == courier.MsgStatusErrored {
-			err := b.ClearMsgSent(ctx, status.MsgID())
-			if err != nil {
-				log.Error("error clearing sent flags", "error", err)
-			}
+	// providers can deliver callbacks out of order so ignore any update which would move the message backwards
+	prevStatus, err := b.recordMsgStatus(rc, su)
+	if err != nil {
+		log.Error("error recording msg status", "error", err)
+	} else if prevStatus != courier.NilMsgStatus {
+		if su.clog != nil {
+			su.clog.Error(clogs.NewLogError("status_regression", "", "Ignoring status update from %s to %s.", prevStatus, su.Status_))
+		}
+		log.Info("ignoring status regression", "prev_status", prevStatus)
+		return nil
+	}
+
+	// we sent a message that errored so clear our sent flag to allow it to be retried
+	if status.MsgID() != courier.NilMsgID && status.Status() == courier.MsgStatusErrored {
+		err := b.ClearMsgSent(ctx, status.MsgID())
+		if err != nil {
+			log.Error("error clearing sent flags", "error", err)
 		}
 	}
 
@@ -590,9 +752,50 @@ func (b *backend) WriteStatusUpdate(ctx context.Context, status courier.StatusUp
 	b.statusWriter.Queue(status.(*StatusUpdate))
 	log.Debug("status update queued")
 
+	b.queueStatusWebhook(su)
+
 	return nil
 }
 
+// clearMsgStatus clears the last recorded status of the passed in outgoing message
+func (b *backend) clearMsgStatus(msg *Msg) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	if err := b.msgStatuses.Del(rc, msg.ID().String()); err != nil {
+		slog.Error("error clearing msg status", "msg_id", msg.ID(), "error", err)
+	}
+}
+
+// recordMsgStatus records the given status update as the last status of its message, unless that would be a regression
+// in which case the existing status is returned
+func (b *backend) recordMsgStatus(rc redis.Conn, s *StatusUpdate) (courier.MsgStatus, error) {
+	key := s.MsgID_.String()
+
+	// try to resolve status updates by external id to the message we sent
+	if s.MsgID_ == courier.NilMsgID {
+		key = fmt.Sprintf("%d|%s", s.ChannelID_, s.ExternalID_)
+
+		msgID, err := b.sentExternalIDs.Get(rc, key)
+		if err != nil {
+			return courier.NilMsgStatus, fmt.Errorf("error looking up sent external id: %w", err)
+		}
+		if msgID != "" {
+			key = msgID
+		}
+	}
+
+	prev, err := b.msgStatuses.Get(rc, key)
+	if err != nil {
+		return courier.NilMsgStatus, fmt.Errorf("error looking up previous status: %w", err)
+	}
+	if prev != "" && isStatusRegression(courier.MsgStatus(prev), s.Status_) {
+		return courier.MsgStatus(prev), nil
+	}
+
+	return courier.NilMsgStatus, b.msgStatuses.Set(rc, key, string(s.Status_))
+}
+
 // updateContactURN updates contact URN according to the old/new URNs from status
 func (b *backend) updateContactURN(ctx context.Context, status courier.StatusUpdate) error {
 	old, new := status.URNUpdate()
@@ -670,6 +873,11 @@ func (b *backend) WriteChannelLog(ctx context.Context, clog *courier.ChannelLog)
 	return nil
 }
 
+// GetChannelLogs returns the logs for the given channel which match the passed in query
+func (b *backend) GetChannelLogs(ctx context.Context, ch courier.Channel, q *courier.ChannelLogQuery) ([]*clogs.Log, error) {
+	return getChannelLogs(ctx, b, ch.(*Channel), q)
+}
+
 // SaveAttachment saves an attachment to backend storage
 func (b *backend) SaveAttachment(ctx context.Context, ch courier.Channel, contentType string, data []byte, extension string) (string, error) {
 	// create our filename
@@ -719,8 +927,14 @@ func (b *backend) ResolveMedia(ctx context.Context, mediaUrl string) (courier.Me
 		jsonx.MustUnmarshal([]byte(mediaJSON), &media)
 	} else {
 		// lookup media in our database
-		media, err = lookupMediaFromUUID(ctx, b.db, uuids.UUID(mediaUUID))
-		if err != nil {
+		err = b.withReadonlyDB(ctx, func(db *sqlx.DB) error {
+			media, err = lookupMediaFromUUID(ctx, db, uuids.UUID(mediaUUID))
+			if err == nil && media == nil {
+				return sql.ErrNoRows
+			}
+			return err
+		})
+		if err != nil && err != sql.ErrNoRows {
 			return nil, fmt.Errorf("error looking up media: %w", err)
 		}
 
@@ -747,6 +961,21 @@ func (b *backend) HttpAccess() *httpx.AccessConfig {
 	return b.httpAccess
 }
 
+// runs the given lookup against our readonly DB if we have one, falling back to our primary DB if that errors or
+// returns sql.ErrNoRows as the row may not have been replicated yet
+func (b *backend) withReadonlyDB(ctx context.Context, fn func(*sqlx.DB) error) error {
+	if b.readonlyDB != nil {
+		err := fn(b.readonlyDB)
+		if err == nil {
+			return nil
+		}
+		if err != sql.ErrNoRows {
+			slog.Warn("error querying readonly db, falling back to primary", "error", err)
+		}
+	}
+	return fn(b.db)
+}
+
 // Health returns the health of this backend as a string, returning "" if all is well
 func (b *backend) Health() string {
 	// test redis
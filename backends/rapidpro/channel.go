@@ -3,16 +3,23 @@ package rapidpro
 import (
 	"context"
 	"database/sql"
+	"log/slog"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils/secrets"
 	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/null/v3"
 )
 
+// how long we wait for a secret referenced in channel config to be resolved
+const secretTimeout = 5 * time.Second
+
 type LogPolicy string
 
 const (
@@ -90,6 +97,21 @@ func (c *Channel) ConfigForKey(key string, defaultValue any) any {
 	if !found {
 		return defaultValue
 	}
+
+	// string values of keys declared as secret by the channel's handler can be references to secrets stored outside of
+	// the database
+	if str, isStr := value.(string); isStr && secrets.IsRef(str) && courier.IsSecretConfigKey(c.ChannelType(), key) {
+		ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+		defer cancel()
+
+		secret, err := secrets.Resolve(ctx, str)
+		if err != nil {
+			slog.Error("error resolving channel config secret", "channel_uuid", c.UUID(), "config_key", key, "error", err)
+			return defaultValue
+		}
+		return secret
+	}
+
 	return value
 }
 
@@ -166,12 +188,17 @@ SELECT
   JOIN orgs_org o ON c.org_id = o.id
  WHERE c.uuid = $1 AND c.is_active = TRUE AND c.org_id IS NOT NULL`
 
-func (b *backend) loadChannelByUUID(ctx context.Context, uuid courier.ChannelUUID) (*Channel, error) {
+// loads the channel with the given UUID, from our primary DB if it's being reloaded after being invalidated
+func (b *backend) loadChannelByUUID(ctx context.Context, uuid courier.ChannelUUID, fromPrimary bool) (*Channel, error) {
 	channel := &Channel{}
-	err := b.db.GetContext(ctx, channel, sqlLookupChannelFromUUID, uuid)
+	err := b.withChannelDB(ctx, fromPrimary, func(db *sqlx.DB) error {
+		return db.GetContext(ctx, channel, sqlLookupChannelFromUUID, uuid)
+	})
 
 	if err == sql.ErrNoRows {
 		return nil, courier.ErrChannelNotFound
+	} else if err == nil {
+		logInvalidConfig(channel)
 	}
 	return channel, err
 }
@@ -195,12 +222,32 @@ SELECT
   JOIN orgs_org o ON c.org_id = o.id
  WHERE c.address = $1 AND c.is_active = TRUE AND c.org_id IS NOT NULL`
 
-func (b *backend) loadChannelByAddress(ctx context.Context, address courier.ChannelAddress) (*Channel, error) {
+// loads the channel with the given address, from our primary DB if it's being reloaded after being invalidated
+func (b *backend) loadChannelByAddress(ctx context.Context, address courier.ChannelAddress, fromPrimary bool) (*Channel, error) {
 	channel := &Channel{}
-	err := b.db.GetContext(ctx, channel, sqlLookupChannelFromAddress, address)
+	err := b.withChannelDB(ctx, fromPrimary, func(db *sqlx.DB) error {
+		return db.GetContext(ctx, channel, sqlLookupChannelFromAddress, address)
+	})
 
 	if err == sql.ErrNoRows {
 		return nil, courier.ErrChannelNotFound
+	} else if err == nil {
+		logInvalidConfig(channel)
 	}
 	return channel, err
 }
+
+// runs the given channel lookup against our primary DB if asked, otherwise against our readonly DB with fallback
+func (b *backend) withChannelDB(ctx context.Context, fromPrimary bool, fn func(*sqlx.DB) error) error {
+	if fromPrimary {
+		return fn(b.db)
+	}
+	return b.withReadonlyDB(ctx, fn)
+}
+
+// logs any problems with the config of a newly loaded channel according to the schema declared by its handler
+func logInvalidConfig(ch *Channel) {
+	for _, e := range courier.ValidateChannelConfig(ch) {
+		slog.Warn("channel config invalid", "channel_uuid", ch.UUID(), "channel_type", ch.ChannelType(), "config_key", e.Key, "problem", e.Message)
+	}
+}
@@ -0,0 +1,248 @@
+package rapidpro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/nyaruka/courier"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// how long channels are cached for
+	channelCacheTTL = time.Minute
+
+	// how long lookups of channels which don't exist are cached for
+	channelNotFoundTTL = 10 * time.Second
+
+	// the Redis pub/sub channel on which channel changes are published so that cached copies can be evicted
+	channelInvalidationKey = "channels:invalidate"
+)
+
+// channelCache is an in-memory cache of channels by UUID or address. Unlike a plain cache it also caches lookups of
+// channels which don't exist for a short time so that requests for unknown channels don't all hit the database, and
+// allows channels to be evicted when they're changed.
+//
+// Channels are normally fetched from the readonly DB, but a channel which was evicted because it changed is fetched
+// from the primary DB the next time so that we don't reload it from a replica which hasn't seen the change yet.
+type channelCache[K comparable] struct {
+	cache     *ttlcache.Cache[K, *Channel]
+	fetch     func(context.Context, K, bool) (*Channel, error)
+	fetchSync singleflight.Group
+	evicted   *ttlcache.Cache[K, bool]
+}
+
+func newChannelCache[K comparable](fetch func(ctx context.Context, key K, fromPrimary bool) (*Channel, error)) *channelCache[K] {
+	return &channelCache[K]{
+		cache: ttlcache.New(
+			ttlcache.WithTTL[K, *Channel](channelCacheTTL),
+			ttlcache.WithDisableTouchOnHit[K, *Channel](),
+		),
+		fetch:   fetch,
+		evicted: ttlcache.New(ttlcache.WithTTL[K, bool](channelCacheTTL)),
+	}
+}
+
+// Start starts the routines to eliminate expired channels and evictions from the cache
+func (c *channelCache[K]) Start() {
+	go c.cache.Start()
+	go c.evicted.Start()
+}
+
+// Stop stops those routines
+func (c *channelCache[K]) Stop() {
+	c.cache.Stop()
+	c.evicted.Stop()
+}
+
+// GetOrFetch looks for the channel in the cache and if not found tries to fetch it
+func (c *channelCache[K]) GetOrFetch(ctx context.Context, key K) (*Channel, error) {
+	item := c.cache.Get(key)
+
+	if item == nil {
+		ii, err, _ := c.fetchSync.Do(fmt.Sprint(key), func() (any, error) {
+			// check again in case another routine fetched it while we were waiting
+			if item := c.cache.Get(key); item != nil {
+				return item, nil
+			}
+
+			ch, err := c.fetch(ctx, key, c.evicted.Has(key))
+			if err == courier.ErrChannelNotFound {
+				c.evicted.Delete(key)
+				return c.cache.Set(key, nil, channelNotFoundTTL), nil
+			} else if err != nil {
+				return nil, err
+			}
+			c.evicted.Delete(key)
+			return c.cache.Set(key, ch, ttlcache.DefaultTTL), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		item = ii.(*ttlcache.Item[K, *Channel])
+	}
+
+	if item.Value() == nil {
+		return nil, courier.ErrChannelNotFound
+	}
+	return item.Value(), nil
+}
+
+// Delete evicts the channel with the given key
+func (c *channelCache[K]) Delete(key K) {
+	c.evict(key)
+}
+
+// DeleteChannel evicts any entries for the channel with the given UUID
+func (c *channelCache[K]) DeleteChannel(uuid courier.ChannelUUID) {
+	keys := make([]K, 0, 1)
+
+	// can't delete while ranging over the cache
+	c.cache.Range(func(item *ttlcache.Item[K, *Channel]) bool {
+		if item.Value() != nil && item.Value().UUID() == uuid {
+			keys = append(keys, item.Key())
+		}
+		return true
+	})
+
+	for _, k := range keys {
+		c.evict(k)
+	}
+}
+
+// Clear evicts all entries
+func (c *channelCache[K]) Clear() {
+	for _, k := range c.cache.Keys() {
+		c.evict(k)
+	}
+}
+
+func (c *channelCache[K]) evict(key K) {
+	c.evicted.Set(key, true, ttlcache.DefaultTTL)
+	c.cache.Delete(key)
+}
+
+// Len returns the number of entries in the cache, including those for channels which don't exist
+func (c *channelCache[K]) Len() int {
+	return c.cache.Len()
+}
+
+// a message published when a channel has been changed or deleted, the address is only needed if it has changed
+type channelInvalidation struct {
+	UUID    courier.ChannelUUID    `json:"uuid"`
+	Address courier.ChannelAddress `json:"address,omitempty"`
+}
+
+// evicts the given channel from our caches
+func (b *backend) invalidateChannel(inv *channelInvalidation) {
+	b.channelsByUUID.Delete(inv.UUID)
+	b.channelsByAddr.DeleteChannel(inv.UUID)
+
+	if inv.Address != courier.NilChannelAddress {
+		b.channelsByAddr.Delete(inv.Address)
+	}
+}
+
+// starts a routine which listens for channel invalidations published by other processes
+func (b *backend) startChannelInvalidator() {
+	b.waitGroup.Add(1)
+
+	go func() {
+		defer b.waitGroup.Done()
+
+		log := slog.With("comp", "channel invalidator")
+		log.Info("channel invalidator started", "state", "started")
+
+		for {
+			err := b.listenForInvalidations()
+
+			select {
+			case <-b.stopChan:
+				log.Info("channel invalidator stopped", "state", "stopped")
+				return
+			default:
+			}
+
+			// if we lost our connection, we may have missed invalidations so clear everything
+			log.Error("error listening for channel invalidations", "error", err)
+			b.channelsByUUID.Clear()
+			b.channelsByAddr.Clear()
+
+			select {
+			case <-b.stopChan:
+				log.Info("channel invalidator stopped", "state", "stopped")
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}()
+}
+
+// subscribes to invalidations and evicts channels until our connection fails or we're stopped
+func (b *backend) listenForInvalidations() error {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	psc := redis.PubSubConn{Conn: rc}
+	if err := psc.Subscribe(channelInvalidationKey); err != nil {
+		return err
+	}
+
+	// ping periodically so that we notice a dead connection, and unsubscribe when we're stopped which will end the
+	// receive loop below
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			select {
+			case <-b.stopChan:
+				psc.Unsubscribe()
+				return
+			case <-done:
+				return
+			case <-time.After(30 * time.Second):
+				if err := psc.Ping(""); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		// override any read timeout of the connection as we can wait longer than that between invalidations
+		switch v := psc.ReceiveWithTimeout(time.Minute).(type) {
+		case redis.Message:
+			inv := &channelInvalidation{}
+			if err := json.Unmarshal(v.Data, inv); err != nil || inv.UUID == courier.NilChannelUUID {
+				slog.Error("invalid channel invalidation", "payload", string(v.Data))
+				continue
+			}
+			b.invalidateChannel(inv)
+
+		case redis.Subscription:
+			if v.Count == 0 {
+				return nil
+			}
+
+		case error:
+			return v
+		}
+	}
+}
+
+// publishes an invalidation of the given channel to all courier instances
+func publishChannelInvalidation(rc redis.Conn, uuid courier.ChannelUUID, address courier.ChannelAddress) error {
+	payload, err := json.Marshal(&channelInvalidation{UUID: uuid, Address: address})
+	if err != nil {
+		return err
+	}
+
+	_, err = rc.Do("PUBLISH", channelInvalidationKey, payload)
+	return err
+}
@@ -0,0 +1,166 @@
+package rapidpro
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelCache(t *testing.T) {
+	ctx := context.Background()
+
+	ch1 := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c95d"}
+	ch2 := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c96a"}
+
+	fetches := 0
+	primaryFetches := 0
+	fetch := func(ctx context.Context, addr courier.ChannelAddress, fromPrimary bool) (*Channel, error) {
+		fetches++
+		if fromPrimary {
+			primaryFetches++
+		}
+		switch addr {
+		case "2500":
+			return ch1, nil
+		case "2501":
+			return ch2, nil
+		case "9999":
+			return nil, errors.New("boom")
+		}
+		return nil, courier.ErrChannelNotFound
+	}
+
+	c := newChannelCache(fetch)
+	c.Start()
+	defer c.Stop()
+
+	ch, err := c.GetOrFetch(ctx, "2500")
+	assert.NoError(t, err)
+	assert.Equal(t, ch1, ch)
+	assert.Equal(t, 1, fetches)
+
+	// second lookup comes from the cache
+	ch, err = c.GetOrFetch(ctx, "2500")
+	assert.NoError(t, err)
+	assert.Equal(t, ch1, ch)
+	assert.Equal(t, 1, fetches)
+
+	// lookups of channels that don't exist are also cached
+	_, err = c.GetOrFetch(ctx, "1234")
+	assert.Equal(t, courier.ErrChannelNotFound, err)
+	_, err = c.GetOrFetch(ctx, "1234")
+	assert.Equal(t, courier.ErrChannelNotFound, err)
+	assert.Equal(t, 2, fetches)
+
+	// but other errors aren't
+	_, err = c.GetOrFetch(ctx, "9999")
+	assert.EqualError(t, err, "boom")
+	_, err = c.GetOrFetch(ctx, "9999")
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, 4, fetches)
+
+	c.GetOrFetch(ctx, "2501")
+	assert.Equal(t, 3, c.Len())
+
+	// evict by UUID which doesn't touch negative entries
+	c.DeleteChannel(ch1.UUID())
+	assert.Equal(t, 2, c.Len())
+
+	// evict by key
+	c.Delete("1234")
+	assert.Equal(t, 1, c.Len())
+
+	assert.Equal(t, 0, primaryFetches)
+
+	// evicted channels are reloaded from the primary DB, but only the first time
+	ch, err = c.GetOrFetch(ctx, "2500")
+	assert.NoError(t, err)
+	assert.Equal(t, ch1, ch)
+	assert.Equal(t, 6, fetches)
+	assert.Equal(t, 1, primaryFetches)
+
+	_, err = c.GetOrFetch(ctx, "1234")
+	assert.Equal(t, courier.ErrChannelNotFound, err)
+	assert.Equal(t, 7, fetches)
+	assert.Equal(t, 2, primaryFetches)
+
+	c.Delete("2500")
+	c.Delete("2500")
+	c.GetOrFetch(ctx, "2500")
+	assert.Equal(t, 8, fetches)
+	assert.Equal(t, 3, primaryFetches)
+
+	// clearing the cache evicts everything in it
+	c.Clear()
+	assert.Equal(t, 0, c.Len())
+
+	c.GetOrFetch(ctx, "2501")
+	assert.Equal(t, 9, fetches)
+	assert.Equal(t, 4, primaryFetches)
+}
+
+func TestInvalidateChannel(t *testing.T) {
+	ctx := context.Background()
+
+	ch := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c95d"}
+
+	b := &backend{
+		channelsByUUID: newChannelCache(func(context.Context, courier.ChannelUUID, bool) (*Channel, error) { return ch, nil }),
+		channelsByAddr: newChannelCache(func(context.Context, courier.ChannelAddress, bool) (*Channel, error) { return ch, nil }),
+	}
+
+	b.channelsByUUID.GetOrFetch(ctx, ch.UUID())
+	b.channelsByAddr.GetOrFetch(ctx, "2500")
+	b.channelsByAddr.GetOrFetch(ctx, "+2500")
+	assert.Equal(t, 1, b.channelsByUUID.Len())
+	assert.Equal(t, 2, b.channelsByAddr.Len())
+
+	b.invalidateChannel(&channelInvalidation{UUID: ch.UUID()})
+	assert.Equal(t, 0, b.channelsByUUID.Len())
+	assert.Equal(t, 0, b.channelsByAddr.Len())
+
+	// if address changed, old address is evicted even if that was a negative entry
+	b.channelsByAddr = newChannelCache(func(context.Context, courier.ChannelAddress, bool) (*Channel, error) {
+		return nil, courier.ErrChannelNotFound
+	})
+	b.channelsByAddr.GetOrFetch(ctx, "3000")
+	assert.Equal(t, 1, b.channelsByAddr.Len())
+
+	b.invalidateChannel(&channelInvalidation{UUID: ch.UUID(), Address: "3000"})
+	assert.Equal(t, 0, b.channelsByAddr.Len())
+}
+
+func TestChannelInvalidator(t *testing.T) {
+	ctx := context.Background()
+	rp := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", "localhost:6379") }}
+
+	ch := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c95d"}
+
+	b := newBackend(courier.NewDefaultConfig()).(*backend)
+	b.rp = rp
+	b.channelsByUUID = newChannelCache(func(context.Context, courier.ChannelUUID, bool) (*Channel, error) { return ch, nil })
+	b.channelsByAddr = newChannelCache(func(context.Context, courier.ChannelAddress, bool) (*Channel, error) { return ch, nil })
+
+	b.startChannelInvalidator()
+	defer func() {
+		close(b.stopChan)
+		b.waitGroup.Wait()
+	}()
+
+	time.Sleep(100 * time.Millisecond) // give invalidator time to subscribe
+
+	b.channelsByUUID.GetOrFetch(ctx, ch.UUID())
+	b.channelsByAddr.GetOrFetch(ctx, "2500")
+	assert.Equal(t, 1, b.channelsByUUID.Len())
+	assert.Equal(t, 1, b.channelsByAddr.Len())
+
+	// invalidations are published to all instances including this one
+	assert.NoError(t, b.InvalidateChannel(ctx, ch.UUID(), courier.NilChannelAddress))
+
+	assert.Eventually(t, func() bool { return b.channelsByUUID.Len() == 0 && b.channelsByAddr.Len() == 0 }, time.Second, 10*time.Millisecond)
+}
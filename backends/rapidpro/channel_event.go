@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
@@ -118,7 +119,19 @@ func (e *ChannelEvent) WithOccurredOn(time time.Time) courier.ChannelEvent {
 func writeChannelEvent(ctx context.Context, b *backend, event courier.ChannelEvent, clog *courier.ChannelLog) error {
 	dbEvent := event.(*ChannelEvent)
 
-	err := writeChannelEventToDB(ctx, b, dbEvent, clog)
+	// like messages, drop events from URNs which are blocked or sending too fast before we create any contacts for them
+	rc := b.rp.Get()
+	err := checkInboundLimits(rc, dbEvent.channel, dbEvent.URN_, b.config.InboundRateLimit)
+	rc.Close()
+
+	if errors.Is(err, courier.ErrMsgIgnored) {
+		b.stats.RecordIncomingLimited(dbEvent.channel.ChannelType())
+		return err
+	} else if err != nil {
+		slog.Error("error checking inbound limits", "error", err, "channel_uuid", dbEvent.channel.UUID())
+	}
+
+	err = writeChannelEventToDB(ctx, b, dbEvent, clog)
 
 	// failed writing, write to our spool instead
 	if err != nil {
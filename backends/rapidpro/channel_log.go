@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"os"
+	"slices"
 	"sync"
 	"time"
 
@@ -17,6 +19,7 @@ import (
 	"github.com/nyaruka/gocommon/dbutil"
 	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/syncx"
+	"github.com/nyaruka/gocommon/uuids"
 )
 
 const sqlInsertChannelLog = `
@@ -46,8 +49,8 @@ func queueChannelLog(b *backend, clog *courier.ChannelLog) {
 		return
 	}
 
-	if b.dyLogWriter.Queue(clog.Log) <= 0 {
-		log.With("storage", "dynamo").Error("channel log writer buffer full")
+	if b.logWriter.Queue(clog.Log) <= 0 {
+		log.With("storage", "sink").Error("channel log writer buffer full")
 	}
 
 	// if log is not attached to a call or message, need to write it to the database so that it is retrievable
@@ -106,23 +109,47 @@ func writeDBChannelLogs(ctx context.Context, db *sqlx.DB, batch []*dbChannelLog)
 	}
 }
 
-type DynamoLogWriter struct {
+// LogWriter batches channel logs and writes them to a log sink
+type LogWriter struct {
 	*syncx.Batcher[*clogs.Log]
 }
 
-func NewDynamoLogWriter(dy *dynamo.Service, wg *sync.WaitGroup) *DynamoLogWriter {
-	return &DynamoLogWriter{
+func NewLogWriter(sink clogs.Sink, wg *sync.WaitGroup) *LogWriter {
+	return &LogWriter{
 		Batcher: syncx.NewBatcher(func(batch []*clogs.Log) {
 			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 			defer cancel()
 
-			if err := writeDynamoChannelLogs(ctx, dy, batch); err != nil {
-				slog.Error("error writing logs to dynamo", "error", err)
+			if err := sink.Write(ctx, batch); err != nil {
+				slog.Error("error writing channel logs", "error", err)
 			}
 		}, 25, time.Millisecond*500, 1000, wg),
 	}
 }
 
+// creates the log sink configured for this backend
+func newLogSink(cfg *courier.Config, ds *dynamo.Service) (clogs.Sink, error) {
+	switch cfg.ChannelLogSink {
+	case "file":
+		return clogs.NewFileSink(cfg.ChannelLogFile, int64(cfg.ChannelLogFileSize)*1024*1024, cfg.ChannelLogFileBackup)
+	case "stdout":
+		return clogs.NewWriterSink(os.Stdout), nil
+	default:
+		return &dynamoLogSink{ds: ds}, nil
+	}
+}
+
+// log sink which writes to DynamoDB which is where we read logs back from
+type dynamoLogSink struct {
+	ds *dynamo.Service
+}
+
+func (s *dynamoLogSink) Write(ctx context.Context, logs []*clogs.Log) error {
+	return writeDynamoChannelLogs(ctx, s.ds, logs)
+}
+
+func (s *dynamoLogSink) Close() error { return nil }
+
 func writeDynamoChannelLogs(ctx context.Context, ds *dynamo.Service, batch []*clogs.Log) error {
 	writeReqs := make([]types.WriteRequest, len(batch))
 
@@ -146,3 +173,167 @@ func writeDynamoChannelLogs(ctx context.Context, ds *dynamo.Service, batch []*cl
 	}
 	return nil
 }
+
+// max number of times we retry reading unprocessed keys from DynamoDB
+const maxDynamoReadRetries = 3
+
+const sqlSelectChannelLogs = `
+  SELECT uuid, log_type, channel_id, http_logs, errors, is_error, created_on, elapsed_ms
+    FROM channels_channellog
+   WHERE channel_id = $1 AND created_on >= $2 AND created_on < $3
+ORDER BY created_on DESC
+   LIMIT $4`
+
+const sqlSelectMsgLogUUIDs = `
+SELECT unnest(log_uuids) FROM msgs_msg WHERE id = $1 AND channel_id = $2`
+
+const sqlSelectMsgsLogUUIDs = `
+SELECT unnest(log_uuids) FROM (
+    SELECT log_uuids
+      FROM msgs_msg
+     WHERE channel_id = $1 AND modified_on >= $2 AND created_on < $3 AND log_uuids IS NOT NULL
+  ORDER BY modified_on DESC
+     LIMIT $4
+) m`
+
+// fetches channel logs matching the given query. Logs not attached to a message are read from the database and
+// attached logs are resolved via their message and read from DynamoDB.
+func getChannelLogs(ctx context.Context, b *backend, ch *Channel, q *courier.ChannelLogQuery) ([]*clogs.Log, error) {
+	var logs []*clogs.Log
+	var dyUUIDs []clogs.LogUUID
+	viaMsgs := make(map[clogs.LogUUID]bool) // UUIDs we know belong to this channel because they're on its messages
+
+	if len(q.UUIDs) > 0 {
+		dyUUIDs = append(dyUUIDs, q.UUIDs...)
+	}
+
+	if q.MsgID != courier.NilMsgID {
+		var msgLogUUIDs []clogs.LogUUID
+		if err := b.db.SelectContext(ctx, &msgLogUUIDs, sqlSelectMsgLogUUIDs, q.MsgID, ch.ID()); err != nil {
+			return nil, fmt.Errorf("error looking up msg log uuids: %w", err)
+		}
+		dyUUIDs = append(dyUUIDs, msgLogUUIDs...)
+		for _, u := range msgLogUUIDs {
+			viaMsgs[u] = true
+		}
+	}
+
+	if !q.After.IsZero() {
+		var dbLogs []*dbChannelLog
+		if err := b.db.SelectContext(ctx, &dbLogs, sqlSelectChannelLogs, ch.ID(), q.After, q.Before, q.Limit); err != nil {
+			return nil, fmt.Errorf("error selecting channel logs: %w", err)
+		}
+		for _, dl := range dbLogs {
+			l, err := dl.toLog()
+			if err != nil {
+				return nil, err
+			}
+			logs = append(logs, l)
+		}
+
+		var msgLogUUIDs []clogs.LogUUID
+		if err := b.db.SelectContext(ctx, &msgLogUUIDs, sqlSelectMsgsLogUUIDs, ch.ID(), q.After, q.Before, q.Limit); err != nil {
+			return nil, fmt.Errorf("error looking up msg log uuids: %w", err)
+		}
+		dyUUIDs = append(dyUUIDs, msgLogUUIDs...)
+		for _, u := range msgLogUUIDs {
+			viaMsgs[u] = true
+		}
+	}
+
+	// logs are only readable from DynamoDB if that's our sink
+	var dyLogs []*clogs.Log
+	if _, isDynamo := b.logSink.(*dynamoLogSink); isDynamo {
+		var err error
+		if dyLogs, err = readDynamoChannelLogs(ctx, b.dynamo, dyUUIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, l := range dyLogs {
+		// logs must belong to this channel, and those written before we recorded that must be on one of its messages
+		if l.ChannelUUID != "" && l.ChannelUUID != uuids.UUID(ch.UUID()) || l.ChannelUUID == "" && !viaMsgs[l.UUID] {
+			continue
+		}
+
+		// logs found by time range via their message might have been created outside of that range
+		if len(q.UUIDs) > 0 || q.MsgID != courier.NilMsgID || (!l.CreatedOn.Before(q.After) && l.CreatedOn.Before(q.Before)) {
+			logs = append(logs, l)
+		}
+	}
+
+	// dedupe, sort newest first and apply our limit
+	seen := make(map[clogs.LogUUID]bool, len(logs))
+	logs = slices.DeleteFunc(logs, func(l *clogs.Log) bool {
+		dupe := seen[l.UUID]
+		seen[l.UUID] = true
+		return dupe
+	})
+	slices.SortFunc(logs, func(a, b *clogs.Log) int { return b.CreatedOn.Compare(a.CreatedOn) })
+
+	if len(logs) > q.Limit {
+		logs = logs[:q.Limit]
+	}
+	return logs, nil
+}
+
+func (l *dbChannelLog) toLog() (*clogs.Log, error) {
+	log := &clogs.Log{
+		UUID:      l.UUID,
+		Type:      l.Type,
+		CreatedOn: l.CreatedOn,
+		Elapsed:   time.Duration(l.ElapsedMS) * time.Millisecond,
+	}
+	if err := json.Unmarshal(l.HTTPLogs, &log.HttpLogs); err != nil {
+		return nil, fmt.Errorf("error unmarshaling channel log http logs: %w", err)
+	}
+	if err := json.Unmarshal(l.Errors, &log.Errors); err != nil {
+		return nil, fmt.Errorf("error unmarshaling channel log errors: %w", err)
+	}
+	return log, nil
+}
+
+func readDynamoChannelLogs(ctx context.Context, ds *dynamo.Service, uuids []clogs.LogUUID) ([]*clogs.Log, error) {
+	logs := make([]*clogs.Log, 0, len(uuids))
+	table := ds.TableName("ChannelLogs")
+
+	// batch gets are limited to 100 keys
+	for batch := range slices.Chunk(uuids, 100) {
+		keys := make([]map[string]types.AttributeValue, 0, len(batch))
+		seen := make(map[clogs.LogUUID]bool, len(batch))
+		for _, u := range batch {
+			if !seen[u] {
+				keys = append(keys, map[string]types.AttributeValue{"UUID": &types.AttributeValueMemberS{Value: string(u)}})
+				seen[u] = true
+			}
+		}
+
+		// dynamo can return some keys as unprocessed, e.g. if we exceed throughput, which we retry with backoff
+		requestItems := map[string]types.KeysAndAttributes{table: {Keys: keys}}
+		for attempt := 0; len(requestItems) > 0; attempt++ {
+			if attempt > maxDynamoReadRetries {
+				return nil, fmt.Errorf("error reading logs from dynamo: %d keys still unprocessed after retries", len(requestItems[table].Keys))
+			}
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+			}
+
+			resp, err := ds.Client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: requestItems})
+			if err != nil {
+				return nil, fmt.Errorf("error reading logs from dynamo: %w", err)
+			}
+
+			for _, item := range resp.Responses[table] {
+				l := &clogs.Log{}
+				if err := l.UnmarshalDynamo(item); err != nil {
+					return nil, err
+				}
+				logs = append(logs, l)
+			}
+
+			requestItems = resp.UnprocessedKeys
+		}
+	}
+
+	return logs, nil
+}
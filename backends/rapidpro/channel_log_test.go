@@ -0,0 +1,47 @@
+package rapidpro
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogSinks(t *testing.T) {
+	cfg := courier.NewDefaultConfig()
+
+	sink, err := newLogSink(cfg, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &dynamoLogSink{}, sink)
+
+	cfg.ChannelLogSink = "stdout"
+	sink, err = newLogSink(cfg, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &clogs.WriterSink{}, sink)
+
+	cfg.ChannelLogSink = "file"
+	cfg.ChannelLogFile = filepath.Join(t.TempDir(), "channel-logs.jsonl")
+	sink, err = newLogSink(cfg, nil)
+	require.NoError(t, err)
+	assert.IsType(t, &clogs.FileSink{}, sink)
+
+	// check logs queued to a writer end up in the file
+	wg := &sync.WaitGroup{}
+	writer := NewLogWriter(sink, wg)
+	writer.Start()
+
+	clog := clogs.NewLog(courier.ChannelLogTypeMsgSend, nil, nil)
+	writer.Queue(clog)
+	writer.Stop()
+	wg.Wait()
+	assert.NoError(t, sink.Close())
+
+	data, err := os.ReadFile(cfg.ChannelLogFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), string(clog.UUID))
+}
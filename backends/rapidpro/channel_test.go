@@ -0,0 +1,38 @@
+package rapidpro
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils/secrets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelConfigSecrets(t *testing.T) {
+	t.Setenv("COURIER_SECRET_AUTH_TOKEN", "sesame")
+	t.Setenv("OTHER_AUTH_TOKEN", "sesame")
+	secrets.Configure("COURIER_SECRET_", "")
+	defer secrets.Configure("", "")
+	defer secrets.ClearCache()
+
+	// mock handler declares auth token as its only secret config key
+	ch := &Channel{ChannelType_: "MCK", Config_: map[string]any{
+		courier.ConfigAuthToken: "env:COURIER_SECRET_AUTH_TOKEN",
+		courier.ConfigSendURL:   "env:COURIER_SECRET_AUTH_TOKEN",
+		courier.ConfigMaxLength: float64(160),
+	}}
+
+	assert.Equal(t, "sesame", ch.StringConfigForKey(courier.ConfigAuthToken, ""))
+	assert.Equal(t, "sesame", ch.ConfigForKey(courier.ConfigAuthToken, nil))
+	assert.Equal(t, 160, ch.IntConfigForKey(courier.ConfigMaxLength, 0))
+
+	// values of other keys aren't resolved
+	assert.Equal(t, "env:COURIER_SECRET_AUTH_TOKEN", ch.StringConfigForKey(courier.ConfigSendURL, ""))
+
+	// references which can't be resolved, or aren't allowed, are treated as missing
+	ch.Config_[courier.ConfigAuthToken] = "env:COURIER_SECRET_MISSING"
+	assert.Equal(t, "", ch.StringConfigForKey(courier.ConfigAuthToken, ""))
+
+	ch.Config_[courier.ConfigAuthToken] = "env:OTHER_AUTH_TOKEN"
+	assert.Equal(t, "", ch.StringConfigForKey(courier.ConfigAuthToken, ""))
+}
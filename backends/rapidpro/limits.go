@@ -0,0 +1,70 @@
+package rapidpro
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+const (
+	blockedURNsKey = "blocked_urns:%s"       // set of URN identities blocked on each channel
+	inboundRateKey = "inbound_rate:%s:%s:%d" // count of messages from a URN on a channel during each minute
+)
+
+// checks whether an incoming message from the given URN should be dropped because the URN is blocked on the channel or
+// has sent more messages in the current minute than the channel's rate limit
+func checkInboundLimits(rc redis.Conn, ch *Channel, urn urns.URN, defaultLimit int) error {
+	identity := string(urn.Identity())
+
+	if isBlockedByConfig(ch, urn) {
+		return courier.ErrURNBlocked
+	}
+
+	blocked, err := redis.Bool(rc.Do("SISMEMBER", fmt.Sprintf(blockedURNsKey, ch.UUID()), identity))
+	if err != nil {
+		return fmt.Errorf("error checking URN blocklist: %w", err)
+	}
+	if blocked {
+		return courier.ErrURNBlocked
+	}
+
+	limit := ch.IntConfigForKey(courier.ConfigInboundRateLimit, defaultLimit)
+	if limit <= 0 {
+		return nil
+	}
+
+	key := fmt.Sprintf(inboundRateKey, ch.UUID(), identity, time.Now().Unix()/60)
+
+	// increment and expire in a transaction so that a count can't be left without an expiry
+	rc.Send("MULTI")
+	rc.Send("INCR", key)
+	rc.Send("EXPIRE", key, 120)
+	vals, err := redis.Values(rc.Do("EXEC"))
+	if err != nil {
+		return fmt.Errorf("error incrementing URN rate count: %w", err)
+	}
+	count, err := redis.Int(vals[0], nil)
+	if err != nil {
+		return fmt.Errorf("error incrementing URN rate count: %w", err)
+	}
+	if count > limit {
+		return courier.ErrURNRateLimited
+	}
+
+	return nil
+}
+
+// checks whether the given URN is in the blocked URNs list of the channel's config
+func isBlockedByConfig(ch *Channel, urn urns.URN) bool {
+	blocked, _ := ch.ConfigForKey(courier.ConfigBlockedURNs, nil).([]any)
+
+	for _, b := range blocked {
+		if s, isStr := b.(string); isStr && urns.URN(s).Identity() == urn.Identity() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package rapidpro
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckInboundLimits(t *testing.T) {
+	rc, err := redis.Dial("tcp", "localhost:6379", redis.DialDatabase(0))
+	require.NoError(t, err)
+	defer rc.Close()
+
+	_, err = rc.Do("FLUSHDB")
+	require.NoError(t, err)
+
+	ch1 := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c95d", Config_: map[string]any{}}
+	ch2 := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c96a", Config_: map[string]any{courier.ConfigInboundRateLimit: float64(2)}}
+
+	// no limits by default
+	for range 5 {
+		assert.NoError(t, checkInboundLimits(rc, ch1, "tel:+12065551212", 0))
+	}
+
+	// default limit can be set by config
+	for range 3 {
+		assert.NoError(t, checkInboundLimits(rc, ch1, "tel:+12065551313", 3))
+	}
+	assert.Equal(t, courier.ErrURNRateLimited, checkInboundLimits(rc, ch1, "tel:+12065551313", 3))
+
+	// counts are always given an expiry
+	ttl, err := redis.Int(rc.Do("TTL", fmt.Sprintf("inbound_rate:dbc126ed-66bc-4e28-b67b-81dc3327c95d:tel:+12065551313:%d", time.Now().Unix()/60)))
+	require.NoError(t, err)
+	assert.Greater(t, ttl, 0)
+
+	// and overridden by channels
+	assert.NoError(t, checkInboundLimits(rc, ch2, "tel:+12065551212", 3))
+	assert.NoError(t, checkInboundLimits(rc, ch2, "tel:+12065551212", 3))
+	assert.Equal(t, courier.ErrURNRateLimited, checkInboundLimits(rc, ch2, "tel:+12065551212", 3))
+
+	// other URNs aren't affected
+	assert.NoError(t, checkInboundLimits(rc, ch2, "tel:+12065551414", 3))
+
+	// block a URN on the first channel
+	_, err = rc.Do("SADD", "blocked_urns:dbc126ed-66bc-4e28-b67b-81dc3327c95d", "tel:+12065551212")
+	require.NoError(t, err)
+
+	err = checkInboundLimits(rc, ch1, urns.URN("tel:+12065551212?foo=bar"), 0)
+	assert.Equal(t, courier.ErrURNBlocked, err)
+	assert.ErrorIs(t, err, courier.ErrMsgIgnored)
+	assert.EqualError(t, err, "message ignored, URN is blocked on this channel")
+
+	// but not the second
+	assert.NoError(t, checkInboundLimits(rc, ch2, "tel:+12065551515", 0))
+
+	// URNs can also be blocked by channel config
+	ch3 := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c97b", Config_: map[string]any{courier.ConfigBlockedURNs: []any{"tel:+12065551616", "telegram:12345"}}}
+
+	assert.Equal(t, courier.ErrURNBlocked, checkInboundLimits(rc, ch3, "tel:+12065551616", 0))
+	assert.Equal(t, courier.ErrURNBlocked, checkInboundLimits(rc, ch3, "telegram:12345#bob", 0))
+	assert.NoError(t, checkInboundLimits(rc, ch3, "tel:+12065551717", 0))
+}
+
+func TestWriteChannelEventLimits(t *testing.T) {
+	rp := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", "localhost:6379", redis.DialDatabase(0)) }}
+	defer rp.Close()
+
+	rc := rp.Get()
+	_, err := rc.Do("FLUSHDB")
+	require.NoError(t, err)
+	_, err = rc.Do("SET", fmt.Sprintf("inbound_rate:dbc126ed-66bc-4e28-b67b-81dc3327c95d:tel:+12065551313:%d", time.Now().Unix()/60), 2)
+	rc.Close()
+	require.NoError(t, err)
+
+	b := newBackend(courier.NewDefaultConfig()).(*backend)
+	b.rp = rp
+
+	ch := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c95d", ChannelType_: "KN", Config_: map[string]any{
+		courier.ConfigBlockedURNs:      []any{"tel:+12065551212"},
+		courier.ConfigInboundRateLimit: float64(2),
+	}}
+	clog := courier.NewChannelLog(courier.ChannelLogTypeEventReceive, ch, nil)
+
+	// events are dropped before any contact is created for them, just like messages
+	err = writeChannelEvent(context.Background(), b, newChannelEvent(ch, courier.EventTypeNewConversation, "tel:+12065551212", clog), clog)
+	assert.Equal(t, courier.ErrURNBlocked, err)
+
+	err = writeChannelEvent(context.Background(), b, newChannelEvent(ch, courier.EventTypeReferral, "tel:+12065551313", clog), clog)
+	assert.Equal(t, courier.ErrURNRateLimited, err)
+
+	assert.Equal(t, 2, b.stats.Extract().IncomingLimited["KN"])
+}
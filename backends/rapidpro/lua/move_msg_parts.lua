@@ -0,0 +1,10 @@
+-- KEYS: [FromKey, ToKey]
+-- ARGV: [BufferKey, Score]
+
+-- only one caller can take the buffer from the first set, and if they do, move it to the other set
+if redis.call("zrem", KEYS[1], ARGV[1]) == 0 then
+    return 0
+end
+
+redis.call("zadd", KEYS[2], ARGV[2], ARGV[1])
+return 1
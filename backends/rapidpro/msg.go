@@ -6,10 +6,12 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,10 +20,13 @@ import (
 	"github.com/lib/pq"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/courier/utils/tracing"
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/gocommon/uuids"
 	"github.com/nyaruka/null/v3"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // MsgDirection is the direction of a message
@@ -85,6 +90,10 @@ type Msg struct {
 	UserID_               courier.UserID          `json:"user_id"`
 	Origin_               courier.MsgOrigin       `json:"origin"`
 	ContactLastSeenOn_    *time.Time              `json:"contact_last_seen_on"`
+	ExpiresOn_            *time.Time              `json:"expires_on"`
+
+	// extra fields that courier itself adds to the payload when requeuing
+	Deferrals_ int `json:"deferrals,omitempty"`
 
 	// extra fields used to allow courier to update a session's timeout to *after* the message has been sent
 	SessionID_            SessionID  `json:"session_id"`
@@ -96,7 +105,11 @@ type Msg struct {
 	URNAuthTokens_ map[string]string `json:"auth_tokens"`
 	channel        *Channel
 	workerToken    queue.WorkerToken
+	queuedJSON     string // as popped from the queue
 	alreadyWritten bool
+	segment        *courier.MsgSegment // if this is one segment of a concatenated message
+	reassembled    bool                // if this was reassembled from buffered parts
+	partsKey       string              // key of the buffer this was reassembled from, deleted once this is written
 }
 
 // newMsg creates a new DBMsg object with the passed in parameters
@@ -165,6 +178,22 @@ func (m *Msg) UserID() courier.UserID         { return m.UserID_ }
 func (m *Msg) SessionStatus() string          { return m.SessionStatus_ }
 func (m *Msg) HighPriority() bool             { return m.HighPriority_ }
 
+// ExpiresOn returns when this message expires, which is either explicitly set in the queued payload, or determined by
+// the channel's message TTL if it has one. The TTL is counted from when the message was created so doesn't apply to
+// resends, which are queued again long after that.
+func (m *Msg) ExpiresOn() *time.Time {
+	if m.ExpiresOn_ != nil {
+		return m.ExpiresOn_
+	}
+	if m.channel != nil && !m.IsResend_ {
+		if ttl := m.channel.IntConfigForKey(courier.ConfigMsgTTL, 0); ttl > 0 {
+			expiresOn := m.CreatedOn_.Add(time.Duration(ttl) * time.Second)
+			return &expiresOn
+		}
+	}
+	return nil
+}
+
 // incoming specific
 func (m *Msg) ReceivedOn() *time.Time { return m.SentOn_ }
 func (m *Msg) WithAttachment(url string) courier.MsgIn {
@@ -177,9 +206,13 @@ func (m *Msg) WithURNAuthTokens(tokens map[string]string) courier.MsgIn {
 	return m
 }
 func (m *Msg) WithReceivedOn(date time.Time) courier.MsgIn { m.SentOn_ = &date; return m }
+func (m *Msg) WithSegment(segment *courier.MsgSegment) courier.MsgIn {
+	m.segment = segment
+	return m
+}
 
 func (m *Msg) hash() string {
-	hash := sha1.Sum([]byte(m.Text_ + "|" + strings.Join(m.Attachments_, "|")))
+	hash := sha1.Sum([]byte(string(m.URN_.Identity()) + "|" + m.Text_ + "|" + strings.Join(m.Attachments_, "|")))
 	return hex.EncodeToString(hash[:])
 }
 
@@ -194,6 +227,37 @@ func writeMsg(ctx context.Context, b *backend, msg courier.MsgIn, clog *courier.
 
 	channel := m.Channel()
 
+	// messages which are parts of a longer message are buffered until they can be reassembled, but if we can't buffer
+	// for some reason, write the part by itself
+	if needsReassembly(m) {
+		rc := b.rp.Get()
+		reassembled, err := bufferMsgPart(rc, m, time.Now())
+		rc.Close()
+
+		if err != nil {
+			slog.Error("error buffering msg part", "error", err, "channel_uuid", channel.UUID())
+		} else if reassembled == nil {
+			b.recordMsgReceived(m)
+			return courier.ErrMsgBuffered
+		} else {
+			m = reassembled
+		}
+	}
+
+	// drop messages from URNs which are blocked or sending too fast before we create any contacts for them, but if we
+	// can't check for some reason, let the message through
+	rc := b.rp.Get()
+	err := checkInboundLimits(rc, m.channel, m.URN_, b.config.InboundRateLimit)
+	rc.Close()
+
+	if errors.Is(err, courier.ErrMsgIgnored) {
+		b.stats.RecordIncomingLimited(channel.ChannelType())
+		b.deleteReassembledParts(m)
+		return err
+	} else if err != nil {
+		slog.Error("error checking inbound limits", "error", err, "channel_uuid", channel.UUID())
+	}
+
 	// check for data: attachment URLs which need to be fetched now - fetching of other URLs can be deferred until
 	// message handling and performed by calling the /c/_fetch-attachment endpoint
 	for i, attURL := range m.Attachments_ {
@@ -223,7 +287,7 @@ func writeMsg(ctx context.Context, b *backend, msg courier.MsgIn, clog *courier.
 	}
 
 	// try to write it our db
-	err := writeMsgToDB(ctx, b, m, clog)
+	err = writeMsgToDB(ctx, b, m, clog)
 
 	// fail? log
 	if err != nil {
@@ -238,9 +302,39 @@ func writeMsg(ctx context.Context, b *backend, msg courier.MsgIn, clog *courier.
 	// mark this msg as having been seen
 	b.recordMsgReceived(m)
 
+	if err == nil {
+		b.queueMsgReceivedWebhook(m)
+		b.deleteReassembledParts(m)
+	}
+
 	return err
 }
 
+// checks that the given incoming message, which won't be written as a message, isn't a duplicate and records it as
+// received. Inbound limits aren't checked here as they're checked when the event written in its place is written.
+func acceptMsg(b *backend, m *Msg) error {
+	if m.alreadyWritten {
+		return courier.ErrMsgDuplicate
+	}
+
+	b.recordMsgReceived(m)
+	return nil
+}
+
+// deletes the buffered parts that the given message was reassembled from, if any, now that it's been handled
+func (b *backend) deleteReassembledParts(m *Msg) {
+	if m.partsKey == "" {
+		return
+	}
+
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	if err := deleteMsgParts(rc, m.partsKey); err != nil {
+		slog.Error("error deleting reassembled msg parts", "error", err, "msg", m.UUID_)
+	}
+}
+
 const sqlInsertMsg = `
 INSERT INTO
 	msgs_msg(org_id, uuid, direction, text, attachments, msg_type, msg_count, error_count, high_priority, status, is_android,
@@ -249,7 +343,10 @@ INSERT INTO
            :visibility, :external_id, :channel_id, :contact_id, :contact_urn_id, :created_on, :modified_on, :next_attempt, :sent_on, :log_uuids)
 RETURNING id`
 
-func writeMsgToDB(ctx context.Context, b *backend, m *Msg, clog *courier.ChannelLog) error {
+func writeMsgToDB(ctx context.Context, b *backend, m *Msg, clog *courier.ChannelLog) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "msg_write", trace.WithAttributes(tracing.ChannelAttrs(string(m.ChannelUUID_), string(m.channel.ChannelType()))...))
+	defer func() { tracing.EndSpan(span, err) }()
+
 	contact, err := contactForURN(ctx, b, m.OrgID_, m.channel, m.URN_, m.URNAuthTokens_, m.ContactName_, clog)
 
 	// our db is down, write to the spool, we will write/queue this later
@@ -273,15 +370,19 @@ func writeMsgToDB(ctx context.Context, b *backend, m *Msg, clog *courier.Channel
 		return fmt.Errorf("error scanning for inserted message id: %w", err)
 	}
 
+	span.SetAttributes(tracing.AttrMsgID.Int64(int64(m.ID_)))
+
 	// queue this up to be handled by RapidPro
+	_, qspan := tracing.Tracer().Start(ctx, "msg_queue_handling")
 	rc := b.rp.Get()
 	defer rc.Close()
-	err = queueMsgHandling(rc, contact, m)
+	qerr := queueMsgHandling(rc, contact, m)
+	tracing.EndSpan(qspan, qerr)
 
 	// if we had a problem queueing the handling, log it, but our message is written, it'll
 	// get picked up by our rapidpro catch-all after a period
-	if err != nil {
-		slog.Error("error queueing msg handling", "error", err, "msg_id", m.ID_)
+	if qerr != nil {
+		slog.Error("error queueing msg handling", "error", qerr, "msg_id", m.ID_)
 	}
 
 	return nil
@@ -324,29 +425,49 @@ func (b *backend) flushMsgFile(filename string, contents []byte) error {
 // Deduping utility methods
 //-----------------------------------------------------------------------------
 
-// checks to see if this message has already been received and if so returns its UUID
-func (b *backend) checkMsgAlreadyReceived(msg *Msg) courier.MsgUUID {
+// how long we remember received messages, which limits the dedup windows that channels can use
+const (
+	maxDedupContentWindow    = 15 * time.Minute
+	maxDedupExternalIDWindow = 24 * time.Hour
+)
+
+// the dedup policy used unless overridden by the channel's handler or config
+var defaultDedupPolicy = &courier.DedupPolicy{
+	Strategy:         courier.DedupAuto,
+	ContentWindow:    2 * time.Second,
+	ExternalIDWindow: 24 * time.Hour,
+}
+
+// checks to see if this message has already been received according to its channel's dedup policy and if so returns
+// the UUID of the original, noting the hit as a warning in the given channel log
+func (b *backend) checkMsgAlreadyReceived(msg *Msg, clog *courier.ChannelLog) courier.MsgUUID {
 	rc := b.rp.Get()
 	defer rc.Close()
 
-	// if we have an external id use that
-	if msg.ExternalID_ != "" {
+	policy := b.dedupPolicy(msg.channel)
+	now := dates.Now()
+
+	if dedupByExternalID(policy, msg) {
 		fingerprint := fmt.Sprintf("%s|%s|%s", msg.Channel().UUID(), msg.URN().Identity(), msg.ExternalID())
 
-		if uuid, _ := b.receivedExternalIDs.Get(rc, fingerprint); uuid != "" {
-			return courier.MsgUUID(uuid)
+		if value, _ := b.receivedExternalIDs.Get(rc, fingerprint); value != "" {
+			prevUUID, _, seenOn := parseSeenValue(value)
+
+			if seenOn.IsZero() || now.Sub(seenOn) <= policy.ExternalIDWindow {
+				clog.Warning(courier.WarningMsgDuplicate(prevUUID, "external ID"))
+				return prevUUID
+			}
 		}
-	} else {
-		// otherwise de-dup based on text received from that channel+urn since last send
+	} else if dedupByContent(policy, msg) {
+		// de-dup based on content received from that channel+urn since last send
 		fingerprint := fmt.Sprintf("%s|%s", msg.Channel().UUID(), msg.URN().Identity())
 
-		if uuidAndHash, _ := b.receivedMsgs.Get(rc, fingerprint); uuidAndHash != "" {
-			prevUUID := uuidAndHash[:36]
-			prevHash := uuidAndHash[37:]
+		if value, _ := b.receivedMsgs.Get(rc, fingerprint); value != "" {
+			prevUUID, prevHash, seenOn := parseSeenValue(value)
 
-			// if it is the same hash, return the UUID
-			if prevHash == msg.hash() {
-				return courier.MsgUUID(prevUUID)
+			if prevHash == msg.hash() && (seenOn.IsZero() || now.Sub(seenOn) <= policy.ContentWindow) {
+				clog.Warning(courier.WarningMsgDuplicate(prevUUID, "content"))
+				return prevUUID
 			}
 		}
 	}
@@ -359,21 +480,59 @@ func (b *backend) recordMsgReceived(msg *Msg) {
 	rc := b.rp.Get()
 	defer rc.Close()
 
-	if msg.ExternalID_ != "" {
+	policy := b.dedupPolicy(msg.channel)
+	seenOn := strconv.FormatInt(dates.Now().UnixMilli(), 10)
+
+	if dedupByExternalID(policy, msg) {
 		fingerprint := fmt.Sprintf("%s|%s|%s", msg.Channel().UUID(), msg.URN().Identity(), msg.ExternalID())
 
-		if err := b.receivedExternalIDs.Set(rc, fingerprint, string(msg.UUID())); err != nil {
+		if err := b.receivedExternalIDs.Set(rc, fingerprint, fmt.Sprintf("%s|%s", msg.UUID(), seenOn)); err != nil {
 			slog.Error("error recording received external id", "msg", msg.UUID(), "error", err)
 		}
-	} else {
+	} else if dedupByContent(policy, msg) {
 		fingerprint := fmt.Sprintf("%s|%s", msg.Channel().UUID(), msg.URN().Identity())
 
-		if err := b.receivedMsgs.Set(rc, fingerprint, fmt.Sprintf("%s|%s", msg.UUID(), msg.hash())); err != nil {
+		if err := b.receivedMsgs.Set(rc, fingerprint, fmt.Sprintf("%s|%s|%s", msg.UUID(), msg.hash(), seenOn)); err != nil {
 			slog.Error("error recording received msg", "msg", msg.UUID(), "error", err)
 		}
 	}
 }
 
+// gets the dedup policy for the given channel, with windows limited to how long we remember received messages
+func (b *backend) dedupPolicy(ch *Channel) *courier.DedupPolicy {
+	policy := courier.GetDedupPolicy(ch, defaultDedupPolicy)
+	policy.ContentWindow = min(policy.ContentWindow, maxDedupContentWindow)
+	policy.ExternalIDWindow = min(policy.ExternalIDWindow, maxDedupExternalIDWindow)
+	return policy
+}
+
+func dedupByExternalID(policy *courier.DedupPolicy, msg *Msg) bool {
+	return msg.ExternalID_ != "" && (policy.Strategy == courier.DedupAuto || policy.Strategy == courier.DedupExternalID)
+}
+
+func dedupByContent(policy *courier.DedupPolicy, msg *Msg) bool {
+	return policy.Strategy == courier.DedupContent || (policy.Strategy == courier.DedupAuto && msg.ExternalID_ == "")
+}
+
+// parses a value recorded for a received message, which is its UUID, an optional content hash and when it was seen.
+// Values recorded before we tracked when messages were seen return a zero time.
+func parseSeenValue(v string) (courier.MsgUUID, string, time.Time) {
+	parts := strings.Split(v, "|")
+	uuid := courier.MsgUUID(parts[0])
+	var hash string
+	var seenOn time.Time
+
+	if len(parts) == 3 || (len(parts) == 2 && len(parts[1]) == 40) {
+		hash = parts[1]
+	}
+	if len(parts) > 1 {
+		if millis, err := strconv.ParseInt(parts[len(parts)-1], 10, 64); err == nil {
+			seenOn = time.UnixMilli(millis)
+		}
+	}
+	return uuid, hash, seenOn
+}
+
 // clearMsgSeen clears our seen incoming messages for the passed in channel and URN
 func (b *backend) clearMsgSeen(msg *Msg) {
 	rc := b.rp.Get()
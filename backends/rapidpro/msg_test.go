@@ -0,0 +1,148 @@
+package rapidpro
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupPolicy(t *testing.T) {
+	rp := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", "localhost:6379", redis.DialDatabase(0)) }}
+	defer rp.Close()
+
+	rc := rp.Get()
+	_, err := rc.Do("FLUSHDB")
+	rc.Close()
+	require.NoError(t, err)
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	dates.SetNowFunc(func() time.Time { return now })
+	defer dates.SetNowFunc(time.Now)
+
+	b := newBackend(courier.NewDefaultConfig()).(*backend)
+	b.rp = rp
+
+	defaultCh := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c95d", ChannelType_: "KN", Config_: map[string]any{}}
+	contentCh := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c96a", ChannelType_: "KN", Config_: map[string]any{courier.ConfigDedupStrategy: "content", courier.ConfigDedupWindow: float64(300)}}
+	noneCh := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c97b", ChannelType_: "KN", Config_: map[string]any{courier.ConfigDedupStrategy: "none"}}
+
+	assert.Equal(t, &courier.DedupPolicy{Strategy: courier.DedupAuto, ContentWindow: 2 * time.Second, ExternalIDWindow: 24 * time.Hour}, b.dedupPolicy(defaultCh))
+	assert.Equal(t, &courier.DedupPolicy{Strategy: courier.DedupContent, ContentWindow: 5 * time.Minute, ExternalIDWindow: 24 * time.Hour}, b.dedupPolicy(contentCh))
+
+	// windows can't be longer than we remember messages for
+	longCh := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c98c", ChannelType_: "KN", Config_: map[string]any{courier.ConfigDedupWindow: float64(3600), courier.ConfigDedupExternalIDWindow: float64(172800)}}
+	assert.Equal(t, &courier.DedupPolicy{Strategy: courier.DedupAuto, ContentWindow: 15 * time.Minute, ExternalIDWindow: 24 * time.Hour}, b.dedupPolicy(longCh))
+
+	receive := func(ch *Channel, text, extID string) (*Msg, *courier.ChannelLog) {
+		clog := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, ch, nil)
+		m := newMsg(MsgIncoming, ch, "tel:+12065551212", text, extID, clog)
+		if prevUUID := b.checkMsgAlreadyReceived(m, clog); prevUUID != courier.NilMsgUUID {
+			m.UUID_ = prevUUID
+			m.alreadyWritten = true
+		} else {
+			b.recordMsgReceived(m)
+		}
+		return m, clog
+	}
+
+	// by default identical messages are only duplicates within a couple of seconds
+	msg1, _ := receive(defaultCh, "yes", "")
+	msg2, clog2 := receive(defaultCh, "yes", "")
+	assert.True(t, msg2.alreadyWritten)
+	assert.Equal(t, msg1.UUID_, msg2.UUID_)
+	assert.Equal(t, []*clogs.LogError{courier.WarningMsgDuplicate(msg1.UUID_, "content")}, clog2.Warnings)
+	assert.Len(t, clog2.Errors, 0)
+	assert.False(t, clog2.IsError())
+
+	now = now.Add(3 * time.Second)
+	msg3, clog3 := receive(defaultCh, "yes", "")
+	assert.False(t, msg3.alreadyWritten)
+	assert.Len(t, clog3.Warnings, 0)
+
+	// and content is ignored when messages have external IDs
+	msg4, _ := receive(defaultCh, "hi", "EX123")
+	msg5, clog5 := receive(defaultCh, "hello", "EX123")
+	assert.True(t, msg5.alreadyWritten)
+	assert.Equal(t, msg4.UUID_, msg5.UUID_)
+	assert.Equal(t, "Message is a duplicate by external ID of message "+string(msg4.UUID_)+".", clog5.Warnings[0].Message)
+
+	// channels can dedup by content over longer windows, even if messages have external IDs
+	msg6, _ := receive(contentCh, "yes", "EX234")
+	now = now.Add(4 * time.Minute)
+	msg7, _ := receive(contentCh, "yes", "EX345")
+	assert.True(t, msg7.alreadyWritten)
+	assert.Equal(t, msg6.UUID_, msg7.UUID_)
+
+	now = now.Add(2 * time.Minute)
+	msg8, _ := receive(contentCh, "yes", "EX345")
+	assert.False(t, msg8.alreadyWritten)
+
+	// or not at all
+	receive(noneCh, "yes", "EX456")
+	msg9, _ := receive(noneCh, "yes", "EX456")
+	assert.False(t, msg9.alreadyWritten)
+}
+
+func TestAcceptMsg(t *testing.T) {
+	rp := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", "localhost:6379", redis.DialDatabase(0)) }}
+	defer rp.Close()
+
+	rc := rp.Get()
+	_, err := rc.Do("FLUSHDB")
+	require.NoError(t, err)
+	_, err = rc.Do("SADD", "blocked_urns:dbc126ed-66bc-4e28-b67b-81dc3327c95d", "tel:+12065551313")
+	rc.Close()
+	require.NoError(t, err)
+
+	b := newBackend(courier.NewDefaultConfig()).(*backend)
+	b.rp = rp
+
+	ch := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c95d", ChannelType_: "KN", Config_: map[string]any{}}
+
+	newIncoming := func(urn urns.URN, text, extID string) *Msg {
+		clog := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, ch, nil)
+		m := newMsg(MsgIncoming, ch, urn, text, extID, clog)
+		if b.checkMsgAlreadyReceived(m, clog) != courier.NilMsgUUID {
+			m.alreadyWritten = true
+		}
+		return m
+	}
+
+	// accepted messages are recorded as received so they're duplicates if received again
+	assert.NoError(t, acceptMsg(b, newIncoming("tel:+12065551212", "STOP", "EX123")))
+	assert.Equal(t, courier.ErrMsgDuplicate, acceptMsg(b, newIncoming("tel:+12065551212", "STOP", "EX123")))
+
+	// inbound limits are left to when the event written in place of the message is written
+	assert.NoError(t, acceptMsg(b, newIncoming("tel:+12065551313", "STOP", "")))
+}
+
+func TestMsgExpiresOn(t *testing.T) {
+	createdOn := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	explicit := time.Date(2024, 1, 2, 3, 5, 0, 0, time.UTC)
+
+	noTTLCh := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c95d", ChannelType_: "KN", Config_: map[string]any{}}
+	ttlCh := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c96a", ChannelType_: "KN", Config_: map[string]any{courier.ConfigMsgTTL: float64(600)}}
+
+	// no expiry by default
+	m := &Msg{CreatedOn_: createdOn, channel: noTTLCh}
+	assert.Nil(t, m.ExpiresOn())
+
+	// channel TTL is counted from when the message was created
+	m = &Msg{CreatedOn_: createdOn, channel: ttlCh}
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 14, 5, 0, time.UTC), *m.ExpiresOn())
+
+	// but not for resends which are queued long after they were created
+	m = &Msg{CreatedOn_: createdOn, IsResend_: true, channel: ttlCh}
+	assert.Nil(t, m.ExpiresOn())
+
+	// an expiry in the queued payload takes precedence
+	m = &Msg{CreatedOn_: createdOn, ExpiresOn_: &explicit, channel: ttlCh}
+	assert.Equal(t, explicit, *m.ExpiresOn())
+}
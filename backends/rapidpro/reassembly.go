@@ -0,0 +1,268 @@
+package rapidpro
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+)
+
+const (
+	reassemblyPendingKey = "reassembly:pending"  // sorted set of part buffers scored by when they should be flushed
+	reassemblyClaimedKey = "reassembly:claimed"  // sorted set of part buffers being written scored by when their lease expires
+	reassemblyPartsKey   = "reassembly:%s:%s:%s" // hash of buffered parts by channel, URN identity and segment reference
+
+	defaultReassemblyTimeout = 30   // seconds to wait for missing segments if channel doesn't set a timeout
+	reassemblyExpiry         = 3600 // seconds past its flush time that a buffer is kept in case we aren't flushing
+
+	maxReassemblyFlush = 100 // max number of buffers flushed in one go
+
+	reassemblyLeaseDuration = 2 * time.Minute // how long a claimed buffer can take to be written before it's flushed again
+)
+
+//go:embed lua/move_msg_parts.lua
+var luaMoveMsgParts string
+var scriptMoveMsgParts = redis.NewScript(2, luaMoveMsgParts)
+
+// a buffered part of an incoming message
+type msgPart struct {
+	Seq   int  `json:"seq"`
+	Total int  `json:"total"`
+	Msg   *Msg `json:"msg"`
+}
+
+// checks whether the given incoming message needs to be buffered for reassembly with other parts, either because it's a
+// segment of a concatenated message and the channel reassembles those, or because the channel merges messages from the
+// same URN which arrive within a window of each other
+func needsReassembly(m *Msg) bool {
+	if m.reassembled {
+		return false
+	}
+	if m.segment != nil {
+		return m.channel.BoolConfigForKey(courier.ConfigReassembleParts, false)
+	}
+	return m.channel.IntConfigForKey(courier.ConfigReassemblyWindow, 0) > 0
+}
+
+// buffers the given message part, returning the reassembled message if we now have all the parts, or nil if we're still
+// waiting for more
+func bufferMsgPart(rc redis.Conn, m *Msg, now time.Time) (*Msg, error) {
+	ch := m.channel
+	part := &msgPart{Msg: m}
+	var ref, field string
+	var flushOn time.Time
+
+	if m.segment != nil {
+		ref, part.Seq, part.Total = m.segment.Ref, m.segment.Seq, m.segment.Total
+		field = strconv.Itoa(m.segment.Seq)
+		flushOn = now.Add(time.Duration(ch.IntConfigForKey(courier.ConfigReassemblyTimeout, defaultReassemblyTimeout)) * time.Second)
+	} else {
+		field = string(m.UUID_)
+		flushOn = now.Add(time.Duration(ch.IntConfigForKey(courier.ConfigReassemblyWindow, 0)) * time.Second)
+	}
+
+	partJSON, err := json.Marshal(part)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling msg part: %w", err)
+	}
+
+	key := fmt.Sprintf(reassemblyPartsKey, ch.UUID(), m.URN_.Identity(), ref)
+	expiry := int(flushOn.Sub(now)/time.Second) + reassemblyExpiry
+
+	// segments are flushed a timeout after the first arrives, but windows are extended by every new part
+	rc.Send("MULTI")
+	rc.Send("HSET", key, field, partJSON)
+	rc.Send("EXPIRE", key, expiry)
+	if m.segment != nil {
+		rc.Send("ZADD", reassemblyPendingKey, "NX", flushOn.UnixMilli(), key)
+	} else {
+		rc.Send("ZADD", reassemblyPendingKey, flushOn.UnixMilli(), key)
+	}
+	if _, err := rc.Do("EXEC"); err != nil {
+		return nil, fmt.Errorf("error buffering msg part: %w", err)
+	}
+
+	if m.segment == nil {
+		return nil, nil
+	}
+
+	parts, err := readMsgParts(rc, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) < part.Total {
+		return nil, nil
+	}
+
+	// we have all the segments, but only one of us gets to reassemble them
+	msg, err := claimMsgParts(rc, key, now)
+	if msg != nil {
+		msg.channel = ch
+	}
+	return msg, err
+}
+
+// pops buffers which are due to be flushed, returning the messages reassembled from their parts
+func popDueMsgParts(rc redis.Conn, now time.Time) ([]*Msg, error) {
+	// buffers whose lease expired before their message was written go back to being pending
+	expired, err := redis.Strings(rc.Do("ZRANGEBYSCORE", reassemblyClaimedKey, "-inf", now.UnixMilli(), "LIMIT", 0, maxReassemblyFlush))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching expired msg parts: %w", err)
+	}
+	for _, key := range expired {
+		if _, err := scriptMoveMsgParts.Do(rc, reassemblyClaimedKey, reassemblyPendingKey, key, now.UnixMilli()); err != nil {
+			return nil, fmt.Errorf("error releasing msg parts: %w", err)
+		}
+	}
+
+	keys, err := redis.Strings(rc.Do("ZRANGEBYSCORE", reassemblyPendingKey, "-inf", now.UnixMilli(), "LIMIT", 0, maxReassemblyFlush))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching due msg parts: %w", err)
+	}
+
+	msgs := make([]*Msg, 0, len(keys))
+	for _, key := range keys {
+		msg, err := claimMsgParts(rc, key, now)
+		if err != nil {
+			return msgs, err
+		}
+		if msg != nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+// claims the buffer with the given key and reassembles its parts into a single message, returning nil if another
+// process claimed it first or it has no parts. The buffer is leased to the caller and only deleted once the message
+// has been written, so if that doesn't happen before the lease expires, it will be flushed again.
+func claimMsgParts(rc redis.Conn, key string, now time.Time) (*Msg, error) {
+	claimed, err := redis.Bool(scriptMoveMsgParts.Do(rc, reassemblyPendingKey, reassemblyClaimedKey, key, now.Add(reassemblyLeaseDuration).UnixMilli()))
+	if err != nil {
+		return nil, fmt.Errorf("error claiming msg parts: %w", err)
+	}
+	if !claimed {
+		return nil, nil
+	}
+
+	parts, err := readMsgParts(rc, key)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := reassembleMsg(parts)
+	if msg == nil {
+		return nil, deleteMsgParts(rc, key)
+	}
+
+	msg.partsKey = key
+	return msg, nil
+}
+
+// deletes the claimed buffer with the given key, once its message has been written
+func deleteMsgParts(rc redis.Conn, key string) error {
+	rc.Send("MULTI")
+	rc.Send("ZREM", reassemblyClaimedKey, key)
+	rc.Send("DEL", key)
+	if _, err := rc.Do("EXEC"); err != nil {
+		return fmt.Errorf("error deleting msg parts: %w", err)
+	}
+	return nil
+}
+
+func readMsgParts(rc redis.Conn, key string) ([]*msgPart, error) {
+	values, err := redis.StringMap(rc.Do("HGETALL", key))
+	if err != nil {
+		return nil, fmt.Errorf("error reading msg parts: %w", err)
+	}
+
+	parts := make([]*msgPart, 0, len(values))
+	for _, v := range values {
+		part := &msgPart{}
+		if err := json.Unmarshal([]byte(v), part); err != nil {
+			return nil, fmt.Errorf("error unmarshalling msg part: %w", err)
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// reassembles a single message from the given parts, ordered by segment number or otherwise by when they were created
+func reassembleMsg(parts []*msgPart) *Msg {
+	if len(parts) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(parts, func(i, j int) bool {
+		if parts[i].Seq != parts[j].Seq {
+			return parts[i].Seq < parts[j].Seq
+		}
+		return parts[i].Msg.CreatedOn_.Before(parts[j].Msg.CreatedOn_)
+	})
+
+	msg := parts[0].Msg
+	for _, p := range parts[1:] {
+		msg.Text_ += p.Msg.Text_
+		msg.Attachments_ = append(msg.Attachments_, p.Msg.Attachments_...)
+		msg.LogUUIDs = append(msg.LogUUIDs, p.Msg.LogUUIDs...)
+	}
+	msg.reassembled = true
+
+	return msg
+}
+
+// starts a goroutine which flushes buffered message parts that have waited long enough for their other parts
+func (b *backend) startReassemblyFlusher() {
+	b.waitGroup.Add(1)
+
+	go func() {
+		defer b.waitGroup.Done()
+
+		for {
+			select {
+			case <-b.stopChan:
+				return
+			case <-time.After(time.Second):
+				b.flushMsgParts()
+			}
+		}
+	}()
+}
+
+func (b *backend) flushMsgParts() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	rc := b.rp.Get()
+	msgs, err := popDueMsgParts(rc, time.Now())
+	rc.Close()
+
+	if err != nil {
+		slog.Error("error popping due msg parts", "error", err)
+	}
+
+	for _, msg := range msgs {
+		channel, err := b.GetChannel(ctx, courier.AnyChannelType, msg.ChannelUUID_)
+		if err != nil {
+			slog.Error("error looking up channel for reassembled msg", "error", err, "channel_uuid", msg.ChannelUUID_)
+			continue
+		}
+		msg.channel = channel.(*Channel)
+
+		// create log tho it won't be written
+		clog := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, channel, nil)
+
+		// if this fails, the buffer is left to be flushed again when its lease expires
+		if err := writeMsg(ctx, b, msg, clog); err != nil && !errors.Is(err, courier.ErrMsgIgnored) {
+			slog.Error("error writing reassembled msg", "error", err, "msg", msg.UUID_)
+		}
+	}
+}
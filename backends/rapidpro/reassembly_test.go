@@ -0,0 +1,129 @@
+package rapidpro
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReassembly(t *testing.T) {
+	rc, err := redis.Dial("tcp", "localhost:6379", redis.DialDatabase(0))
+	require.NoError(t, err)
+	defer rc.Close()
+
+	_, err = rc.Do("FLUSHDB")
+	require.NoError(t, err)
+
+	ch1 := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c95d", Config_: map[string]any{}}
+	ch2 := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c96a", Config_: map[string]any{courier.ConfigReassembleParts: true, courier.ConfigReassemblyTimeout: float64(10)}}
+	ch3 := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c97b", Config_: map[string]any{courier.ConfigReassemblyWindow: float64(5)}}
+
+	newPart := func(ch *Channel, text string, segment *courier.MsgSegment) *Msg {
+		clog := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, ch, nil)
+		m := newMsg(MsgIncoming, ch, "tel:+12065551212", text, "", clog)
+		if segment != nil {
+			m.WithSegment(segment)
+		}
+		return m
+	}
+
+	// segments aren't reassembled unless channel enables it
+	assert.False(t, needsReassembly(newPart(ch1, "Hello", &courier.MsgSegment{Ref: "42", Seq: 1, Total: 2})))
+	assert.False(t, needsReassembly(newPart(ch1, "Hello", nil)))
+	assert.True(t, needsReassembly(newPart(ch2, "Hello", &courier.MsgSegment{Ref: "42", Seq: 1, Total: 2})))
+	assert.False(t, needsReassembly(newPart(ch2, "Hello", nil)))
+	assert.True(t, needsReassembly(newPart(ch3, "Hello", nil)))
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// segments arriving out of order are reassembled as soon as we have them all
+	part3 := newPart(ch2, "you?", &courier.MsgSegment{Ref: "42", Seq: 3, Total: 3})
+	part1 := newPart(ch2, "Hello ", &courier.MsgSegment{Ref: "42", Seq: 1, Total: 3})
+	part2 := newPart(ch2, "how are ", &courier.MsgSegment{Ref: "42", Seq: 2, Total: 3})
+
+	msg, err := bufferMsgPart(rc, part3, now)
+	assert.NoError(t, err)
+	assert.Nil(t, msg)
+
+	msg, err = bufferMsgPart(rc, part1, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Nil(t, msg)
+
+	msg, err = bufferMsgPart(rc, part2, now.Add(2*time.Second))
+	assert.NoError(t, err)
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, part1.UUID_, msg.UUID_)
+		assert.Equal(t, "Hello how are you?", msg.Text_)
+		assert.Equal(t, ch2, msg.channel)
+		assert.Len(t, msg.LogUUIDs, 3)
+		assert.False(t, needsReassembly(msg))
+		assert.Equal(t, "reassembly:dbc126ed-66bc-4e28-b67b-81dc3327c96a:tel:+12065551212:42", msg.partsKey)
+	}
+
+	// buffer isn't deleted until the reassembled message has been written
+	exists, err := redis.Bool(rc.Do("EXISTS", msg.partsKey))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// and if that doesn't happen before the lease expires, it's flushed again
+	msgs, err := popDueMsgParts(rc, now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 0)
+
+	msgs, err = popDueMsgParts(rc, now.Add(3*time.Minute))
+	assert.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "Hello how are you?", msgs[0].Text_)
+		assert.NoError(t, deleteMsgParts(rc, msgs[0].partsKey))
+	}
+
+	exists, err = redis.Bool(rc.Do("EXISTS", msg.partsKey))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// nothing left to flush
+	msgs, err = popDueMsgParts(rc, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 0)
+
+	// incomplete segments are flushed after the channel's timeout
+	msg, err = bufferMsgPart(rc, newPart(ch2, "Hello ", &courier.MsgSegment{Ref: "43", Seq: 1, Total: 3}), now)
+	assert.NoError(t, err)
+	assert.Nil(t, msg)
+	msg, err = bufferMsgPart(rc, newPart(ch2, "you?", &courier.MsgSegment{Ref: "43", Seq: 3, Total: 3}), now.Add(5*time.Second))
+	assert.NoError(t, err)
+	assert.Nil(t, msg)
+
+	msgs, err = popDueMsgParts(rc, now.Add(9*time.Second))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 0)
+
+	msgs, err = popDueMsgParts(rc, now.Add(10*time.Second))
+	assert.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "Hello you?", msgs[0].Text_)
+		assert.True(t, msgs[0].reassembled)
+	}
+
+	// messages without segments are merged if they arrive within the channel's window of each other
+	msg, err = bufferMsgPart(rc, newPart(ch3, "Hello ", nil), now)
+	assert.NoError(t, err)
+	assert.Nil(t, msg)
+	msg, err = bufferMsgPart(rc, newPart(ch3, "there", nil), now.Add(4*time.Second))
+	assert.NoError(t, err)
+	assert.Nil(t, msg)
+
+	msgs, err = popDueMsgParts(rc, now.Add(8*time.Second))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 0)
+
+	msgs, err = popDueMsgParts(rc, now.Add(9*time.Second))
+	assert.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "Hello there", msgs[0].Text_)
+	}
+}
@@ -0,0 +1,196 @@
+package rapidpro
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/redisx"
+)
+
+const (
+	redisSchemeSentinel = "redis+sentinel"
+	redisSchemeCluster  = "redis+cluster"
+
+	// how long a connection can be idle before we check it's still connected to the primary
+	sentinelRoleCheckAfter = time.Second
+
+	// timeouts for connections to the primary so that callers don't hang on one that has gone away during a failover
+	primaryConnectTimeout = 5 * time.Second
+	primaryReadTimeout    = 15 * time.Second
+	primaryWriteTimeout   = 15 * time.Second
+)
+
+// parses the given Redis URL which can be a regular redis:// URL or a Sentinel URL of the form
+// redis+sentinel://[:password@]host1:26379,host2:26379/<primary name>/<db>
+func parseRedisURL(redisURL string) (*url.URL, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "redis":
+		return u, nil
+	case redisSchemeSentinel:
+		if _, _, err := parseSentinelURL(u); err != nil {
+			return nil, err
+		}
+		return u, nil
+	case redisSchemeCluster:
+		// our queue scripts operate on keys which would be spread across slots so can't be used with a cluster
+		return nil, errors.New("redis cluster is not supported, use sentinel for high availability")
+	}
+	return nil, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+}
+
+// creates a new Redis pool from the given parsed URL
+func newRedisPool(u *url.URL, maxActive int) (*redis.Pool, error) {
+	if u.Scheme == redisSchemeSentinel {
+		return newSentinelPool(u, maxActive)
+	}
+	return redisx.NewPool(u.String(), redisx.WithMaxActive(maxActive))
+}
+
+// sentinel discovers the current primary from a set of Redis Sentinels
+type sentinel struct {
+	addrs       []string
+	primaryName string
+	mutex       sync.Mutex
+}
+
+// parses a sentinel URL into a sentinel and the DB to select
+func parseSentinelURL(u *url.URL) (*sentinel, string, error) {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Host == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, "", fmt.Errorf("sentinel URL must be of the form %s://host:port,.../<primary name>/<db>", redisSchemeSentinel)
+	}
+
+	return &sentinel{addrs: strings.Split(u.Host, ","), primaryName: parts[0]}, parts[1], nil
+}
+
+// returns the address of the current primary, asking each sentinel in turn until one answers
+func (s *sentinel) primaryAddr() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var lastErr error
+
+	for i, addr := range s.addrs {
+		primaryAddr, err := s.queryPrimary(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		// move the sentinel that answered to the front so it's asked first next time
+		s.addrs[0], s.addrs[i] = s.addrs[i], s.addrs[0]
+		return primaryAddr, nil
+	}
+
+	return "", fmt.Errorf("no sentinel could provide primary '%s': %w", s.primaryName, lastErr)
+}
+
+func (s *sentinel) queryPrimary(sentinelAddr string) (string, error) {
+	conn, err := redis.Dial("tcp", sentinelAddr, redis.DialConnectTimeout(time.Second), redis.DialReadTimeout(time.Second), redis.DialWriteTimeout(time.Second))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	res, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", s.primaryName))
+	if err != nil && err != redis.ErrNil {
+		return "", err
+	}
+	if len(res) != 2 {
+		return "", fmt.Errorf("sentinel %s doesn't know primary '%s'", sentinelAddr, s.primaryName)
+	}
+	return res[0] + ":" + res[1], nil
+}
+
+// checks that the given connection is to a primary, which it won't be if a failover has happened since it was made
+func checkPrimaryRole(conn redis.Conn) error {
+	res, err := redis.Values(conn.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(res) == 0 {
+		return errors.New("empty response to ROLE")
+	}
+	if role, _ := redis.String(res[0], nil); role != "master" {
+		return fmt.Errorf("connection is to a %s, not the primary", role)
+	}
+	return nil
+}
+
+func newSentinelPool(u *url.URL, maxActive int) (*redis.Pool, error) {
+	s, db, err := parseSentinelURL(u)
+	if err != nil {
+		return nil, err
+	}
+
+	dial := func() (redis.Conn, error) {
+		addr, err := s.primaryAddr()
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := redis.Dial("tcp", addr, redis.DialConnectTimeout(primaryConnectTimeout), redis.DialReadTimeout(primaryReadTimeout), redis.DialWriteTimeout(primaryWriteTimeout))
+		if err != nil {
+			return nil, err
+		}
+
+		// send auth if required
+		if u.User != nil {
+			if pass, authRequired := u.User.Password(); authRequired {
+				if _, err := conn.Do("AUTH", pass); err != nil {
+					conn.Close()
+					return nil, err
+				}
+			}
+		}
+
+		// sentinel may not have noticed a failover yet
+		if err := checkPrimaryRole(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		// switch to the right DB
+		if _, err := conn.Do("SELECT", db); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	rp := &redis.Pool{
+		MaxActive:   maxActive,
+		MaxIdle:     4,
+		IdleTimeout: 180 * time.Second,
+		Wait:        true, // makes callers wait for a connection
+		Dial:        dial,
+
+		// idle connections may be to a primary that has since been demoted, in which case they'll be closed and
+		// replaced by a connection to the new primary
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < sentinelRoleCheckAfter {
+				return nil
+			}
+			return checkPrimaryRole(c)
+		},
+	}
+
+	// test the connection
+	conn := rp.Get()
+	defer conn.Close()
+	if _, err = conn.Do("PING"); err != nil {
+		return nil, err
+	}
+
+	return rp, nil
+}
@@ -0,0 +1,129 @@
+package rapidpro
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRedisURL(t *testing.T) {
+	tcs := []struct {
+		url string
+		err string
+	}{
+		{"redis://localhost:6379/15", ""},
+		{"redis://:pass@localhost:6379/15", ""},
+		{"redis+sentinel://sentinel1:26379,sentinel2:26379/mymaster/15", ""},
+		{"redis+sentinel://:pass@sentinel1:26379/mymaster/15", ""},
+		{"redis+sentinel://sentinel1:26379/mymaster", "sentinel URL must be of the form redis+sentinel://host:port,.../<primary name>/<db>"},
+		{"redis+sentinel:///mymaster/15", "sentinel URL must be of the form redis+sentinel://host:port,.../<primary name>/<db>"},
+		{"redis+cluster://localhost:7000", "redis cluster is not supported, use sentinel for high availability"},
+		{"rediss://localhost:6379/15", "unsupported scheme: rediss"},
+	}
+
+	for _, tc := range tcs {
+		u, err := parseRedisURL(tc.url)
+		if tc.err == "" {
+			assert.NoError(t, err, "unexpected error for %s", tc.url)
+			assert.Equal(t, tc.url, u.String())
+		} else {
+			assert.EqualError(t, err, tc.err, "error mismatch for %s", tc.url)
+		}
+	}
+}
+
+func TestSentinelPool(t *testing.T) {
+	// a fake sentinel which knows about one primary which is our regular test Redis
+	sentinelAddr := startFakeSentinel(t, "mymaster", "localhost", "6379")
+
+	u, err := parseRedisURL(fmt.Sprintf("redis+sentinel://localhost:1,%s/mymaster/1", sentinelAddr))
+	require.NoError(t, err)
+
+	rp, err := newRedisPool(u, 2)
+	require.NoError(t, err)
+	defer rp.Close()
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	_, err = rc.Do("SET", "sentinel-test", "yes")
+	assert.NoError(t, err)
+	v, err := redis.String(rc.Do("GET", "sentinel-test"))
+	assert.NoError(t, err)
+	assert.Equal(t, "yes", v)
+
+	rc.Do("DEL", "sentinel-test")
+
+	// unknown primary name
+	u, _ = parseRedisURL(fmt.Sprintf("redis+sentinel://%s/other/1", sentinelAddr))
+	_, err = newRedisPool(u, 2)
+	assert.EqualError(t, err, fmt.Sprintf("no sentinel could provide primary 'other': sentinel %s doesn't know primary 'other'", sentinelAddr))
+}
+
+// starts a fake sentinel that answers SENTINEL get-master-addr-by-name for a single primary
+func startFakeSentinel(t *testing.T, name, host, port string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+
+				for {
+					args, err := readRESPArray(r)
+					if err != nil {
+						return
+					}
+
+					if len(args) == 3 && strings.ToUpper(args[0]) == "SENTINEL" {
+						if args[2] == name {
+							fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(host), host, len(port), port)
+						} else {
+							conn.Write([]byte("*-1\r\n"))
+						}
+					} else {
+						conn.Write([]byte("-ERR unknown command\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func readRESPArray(r *bufio.Reader) ([]string, error) {
+	var n int
+	if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		var size int
+		if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
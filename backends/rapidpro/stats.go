@@ -37,6 +37,7 @@ type Stats struct {
 	IncomingStatuses CountByType    // number of status updates received
 	IncomingEvents   CountByType    // number of other events received
 	IncomingIgnored  CountByType    // number of requests ignored
+	IncomingLimited  CountByType    // number of messages and events dropped because their URN was blocked or rate limited
 	IncomingDuration DurationByType // total time spent handling requests
 
 	OutgoingSends    CountByType    // number of sends that succeeded
@@ -53,6 +54,7 @@ func newStats() *Stats {
 		IncomingStatuses: make(CountByType),
 		IncomingEvents:   make(CountByType),
 		IncomingIgnored:  make(CountByType),
+		IncomingLimited:  make(CountByType),
 		IncomingDuration: make(DurationByType),
 
 		OutgoingSends:    make(CountByType),
@@ -70,6 +72,7 @@ func (s *Stats) ToMetrics() []types.MetricDatum {
 	metrics = append(metrics, s.IncomingStatuses.metrics("IncomingStatuses")...)
 	metrics = append(metrics, s.IncomingEvents.metrics("IncomingEvents")...)
 	metrics = append(metrics, s.IncomingIgnored.metrics("IncomingIgnored")...)
+	metrics = append(metrics, s.IncomingLimited.metrics("IncomingLimited")...)
 	metrics = append(metrics, s.IncomingDuration.metrics("IncomingDuration", func(typ courier.ChannelType) int { return s.IncomingRequests[typ] })...)
 
 	metrics = append(metrics, s.OutgoingSends.metrics("OutgoingSends")...)
@@ -113,6 +116,12 @@ func (c *StatsCollector) RecordIncoming(typ courier.ChannelType, evts []courier.
 	c.mutex.Unlock()
 }
 
+func (c *StatsCollector) RecordIncomingLimited(typ courier.ChannelType) {
+	c.mutex.Lock()
+	c.stats.IncomingLimited[typ]++
+	c.mutex.Unlock()
+}
+
 func (c *StatsCollector) RecordOutgoing(typ courier.ChannelType, success bool, d time.Duration) {
 	c.mutex.Lock()
 	if success {
@@ -16,6 +16,7 @@ func TestStats(t *testing.T) {
 	sc.RecordContactCreated()
 	sc.RecordContactCreated()
 	sc.RecordIncoming("T", []courier.Event{}, time.Second)
+	sc.RecordIncomingLimited("T")
 	sc.RecordOutgoing("T", true, time.Second)
 	sc.RecordOutgoing("T", true, time.Second)
 	sc.RecordOutgoing("FBA", true, time.Second)
@@ -29,13 +30,14 @@ func TestStats(t *testing.T) {
 	assert.Equal(t, rapidpro.CountByType{}, stats.IncomingMessages)
 	assert.Equal(t, rapidpro.CountByType{}, stats.IncomingStatuses)
 	assert.Equal(t, rapidpro.CountByType{}, stats.IncomingEvents)
+	assert.Equal(t, rapidpro.CountByType{"T": 1}, stats.IncomingLimited)
 	assert.Equal(t, rapidpro.DurationByType{"T": time.Second}, stats.IncomingDuration)
 	assert.Equal(t, rapidpro.CountByType{"T": 2, "FBA": 3}, stats.OutgoingSends)
 	assert.Equal(t, rapidpro.CountByType{}, stats.OutgoingErrors)
 	assert.Equal(t, rapidpro.DurationByType{"T": time.Second * 2, "FBA": time.Second * 3}, stats.OutgoingDuration)
 
 	metrics := stats.ToMetrics()
-	assert.Len(t, metrics, 8)
+	assert.Len(t, metrics, 9)
 
 	sc.RecordOutgoing("FBA", true, time.Second)
 	sc.RecordOutgoing("FBA", true, time.Second)
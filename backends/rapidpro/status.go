@@ -11,11 +11,16 @@ import (
 	"sync"
 	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/courier/utils/tracing"
 	"github.com/nyaruka/gocommon/dbutil"
 	"github.com/nyaruka/gocommon/syncx"
 	"github.com/nyaruka/gocommon/urns"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // StatusUpdate represents a status update on a message
@@ -29,6 +34,9 @@ type StatusUpdate struct {
 	Status_      courier.MsgStatus   `json:"status"                   db:"status"`
 	ModifiedOn_  time.Time           `json:"modified_on"              db:"modified_on"`
 	LogUUID      clogs.LogUUID       `json:"log_uuid"                 db:"log_uuid"`
+
+	channel *Channel
+	clog    *courier.ChannelLog
 }
 
 // creates a new message status update
@@ -45,7 +53,37 @@ func newStatusUpdate(channel courier.Channel, id courier.MsgID, externalID strin
 		Status_:      status,
 		ModifiedOn_:  time.Now().In(time.UTC),
 		LogUUID:      clog.UUID,
+
+		channel: dbChannel,
+		clog:    clog,
+	}
+}
+
+// the order in which statuses are expected to progress, errored sits alongside queued as errored messages are retried
+var statusPrecedence = map[courier.MsgStatus]int{
+	courier.MsgStatusPending:   0,
+	courier.MsgStatusQueued:    1,
+	courier.MsgStatusErrored:   1,
+	courier.MsgStatusWired:     2,
+	courier.MsgStatusSent:      3,
+	courier.MsgStatusDelivered: 4,
+	courier.MsgStatusRead:      5,
+}
+
+// isStatusRegression returns whether changing a message's status from old to new would move it backwards. Failed can
+// replace any status besides delivered and read, and can itself only be replaced by delivered or read. Errored can
+// also replace wired and sent as providers can report errors after accepting a message, and it needs to be retried.
+func isStatusRegression(old, new courier.MsgStatus) bool {
+	if new == courier.MsgStatusFailed {
+		return old == courier.MsgStatusDelivered || old == courier.MsgStatusRead
+	}
+	if old == courier.MsgStatusFailed {
+		return new != courier.MsgStatusDelivered && new != courier.MsgStatusRead
+	}
+	if new == courier.MsgStatusErrored {
+		return old == courier.MsgStatusDelivered || old == courier.MsgStatusRead
 	}
+	return statusPrecedence[new] < statusPrecedence[old]
 }
 
 // the craziness below lets us update our status to 'F' and schedule retries without knowing anything about the message
@@ -114,7 +152,16 @@ AS
 WHERE 
 	msgs_msg.id = s.msg_id::bigint AND
 	msgs_msg.channel_id = s.channel_id::int AND 
-	msgs_msg.direction = 'O'
+	msgs_msg.direction = 'O' AND
+	NOT (
+		(s.status = 'F' AND msgs_msg.status IN ('D', 'R')) OR
+		(s.status NOT IN ('F', 'D', 'R') AND msgs_msg.status = 'F') OR
+		(s.status = 'E' AND msgs_msg.status IN ('D', 'R')) OR
+		(s.status NOT IN ('F', 'E') AND msgs_msg.status != 'F' AND 
+			(CASE s.status WHEN 'P' THEN 0 WHEN 'Q' THEN 1 WHEN 'E' THEN 1 WHEN 'W' THEN 2 WHEN 'S' THEN 3 WHEN 'D' THEN 4 WHEN 'R' THEN 5 END) <
+			(CASE msgs_msg.status WHEN 'P' THEN 0 WHEN 'Q' THEN 1 WHEN 'E' THEN 1 WHEN 'W' THEN 2 WHEN 'S' THEN 3 WHEN 'D' THEN 4 WHEN 'R' THEN 5 END)
+		)
+	)
 `
 
 func (b *backend) flushStatusFile(filename string, contents []byte) error {
@@ -185,10 +232,16 @@ func NewStatusWriter(b *backend, spoolDir string, wg *sync.WaitGroup) *StatusWri
 func (b *backend) writeStatuseUpdates(ctx context.Context, spoolDir string, batch []*StatusUpdate) {
 	log := slog.With("comp", "status writer")
 
+	ctx, span := tracing.Tracer().Start(ctx, "status_batch_write", trace.WithAttributes(attribute.Int("courier.batch.size", len(batch))))
+	defer span.End()
+
 	unresolved, err := b.writeStatusUpdatesToDB(ctx, batch)
 
 	// if we received an error, try again one at a time (in case it is one value hanging us up)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "error writing status batch")
+
 		for _, s := range batch {
 			_, err = b.writeStatusUpdatesToDB(ctx, []*StatusUpdate{s})
 			if err != nil {
@@ -208,6 +261,8 @@ func (b *backend) writeStatuseUpdates(ctx context.Context, spoolDir string, batc
 			}
 		}
 	} else {
+		span.SetAttributes(attribute.Int("courier.batch.unresolved", len(unresolved)))
+
 		for _, s := range unresolved {
 			log.Warn(fmt.Sprintf("unable to find message with channel_id=%d and external_id=%s", s.ChannelID_, s.ExternalID_))
 		}
@@ -234,9 +289,19 @@ func (b *backend) writeStatusUpdatesToDB(ctx context.Context, statuses []*Status
 
 	resolved := make([]*StatusUpdate, 0, len(statuses))
 	unresolved := make([]*StatusUpdate, 0, len(statuses))
+	resolvedByID := make(map[courier.MsgID]int, len(statuses))
 
 	for _, s := range statuses {
 		if s.MsgID_ != courier.NilMsgID {
+			// a batch can only contain one update per message, so keep the furthest along
+			if i, seen := resolvedByID[s.MsgID_]; seen {
+				if !isStatusRegression(resolved[i].Status_, s.Status_) {
+					resolved[i] = s
+				}
+				continue
+			}
+
+			resolvedByID[s.MsgID_] = len(resolved)
 			resolved = append(resolved, s)
 		} else {
 			unresolved = append(unresolved, s)
@@ -287,22 +352,46 @@ func (b *backend) resolveStatusUpdateMsgIDs(ctx context.Context, statuses []*Sta
 		return nil
 	}
 
+	// try our readonly DB first if we have one, and then our primary DB for anything it couldn't resolve since those
+	// messages may not have been replicated yet
+	if b.readonlyDB != nil {
+		if err := resolveStatusUpdateMsgIDsFromDB(ctx, b.readonlyDB, notInCache); err != nil {
+			slog.Warn("error querying readonly db, falling back to primary", "error", err)
+		}
+
+		stillMissing := make([]*StatusUpdate, 0, len(notInCache))
+		for _, s := range notInCache {
+			if s.MsgID_ == courier.NilMsgID {
+				stillMissing = append(stillMissing, s)
+			}
+		}
+		if len(stillMissing) == 0 {
+			return nil
+		}
+		notInCache = stillMissing
+	}
+
+	return resolveStatusUpdateMsgIDsFromDB(ctx, b.db, notInCache)
+}
+
+// looks up msg IDs in the given database for statuses with channel id + external id pairs
+func resolveStatusUpdateMsgIDsFromDB(ctx context.Context, db *sqlx.DB, statuses []*StatusUpdate) error {
 	// create a mapping of channel id + external id -> status
 	type ext struct {
 		channelID  courier.ChannelID
 		externalID string
 	}
-	statusesByExt := make(map[ext]*StatusUpdate, len(notInCache))
+	statusesByExt := make(map[ext]*StatusUpdate, len(statuses))
 	for _, s := range statuses {
 		statusesByExt[ext{s.ChannelID_, s.ExternalID_}] = s
 	}
 
-	sql, params, err := dbutil.BulkSQL(b.db, sqlResolveStatusMsgIDs, notInCache)
+	sql, params, err := dbutil.BulkSQL(db, sqlResolveStatusMsgIDs, statuses)
 	if err != nil {
 		return err
 	}
 
-	rows, err := b.db.QueryContext(ctx, sql, params...)
+	rows, err := db.QueryContext(ctx, sql, params...)
 	if err != nil {
 		return err
 	}
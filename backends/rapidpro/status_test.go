@@ -0,0 +1,79 @@
+package rapidpro
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsStatusRegression(t *testing.T) {
+	tcs := []struct {
+		old        courier.MsgStatus
+		new        courier.MsgStatus
+		regression bool
+	}{
+		{courier.MsgStatusQueued, courier.MsgStatusWired, false},
+		{courier.MsgStatusWired, courier.MsgStatusSent, false},
+		{courier.MsgStatusWired, courier.MsgStatusDelivered, false},
+		{courier.MsgStatusSent, courier.MsgStatusSent, false},
+		{courier.MsgStatusDelivered, courier.MsgStatusRead, false},
+		{courier.MsgStatusErrored, courier.MsgStatusWired, false},
+		{courier.MsgStatusQueued, courier.MsgStatusErrored, false},
+		{courier.MsgStatusSent, courier.MsgStatusWired, true},
+		{courier.MsgStatusDelivered, courier.MsgStatusSent, true},
+		{courier.MsgStatusRead, courier.MsgStatusDelivered, true},
+		{courier.MsgStatusWired, courier.MsgStatusErrored, false},
+		{courier.MsgStatusSent, courier.MsgStatusErrored, false},
+		{courier.MsgStatusDelivered, courier.MsgStatusErrored, true},
+		{courier.MsgStatusRead, courier.MsgStatusErrored, true},
+
+		{courier.MsgStatusWired, courier.MsgStatusFailed, false},
+		{courier.MsgStatusSent, courier.MsgStatusFailed, false},
+		{courier.MsgStatusFailed, courier.MsgStatusFailed, false},
+		{courier.MsgStatusDelivered, courier.MsgStatusFailed, true},
+		{courier.MsgStatusRead, courier.MsgStatusFailed, true},
+		{courier.MsgStatusFailed, courier.MsgStatusDelivered, false},
+		{courier.MsgStatusFailed, courier.MsgStatusRead, false},
+		{courier.MsgStatusFailed, courier.MsgStatusWired, true},
+		{courier.MsgStatusFailed, courier.MsgStatusSent, true},
+		{courier.MsgStatusFailed, courier.MsgStatusErrored, true},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.regression, isStatusRegression(tc.old, tc.new), "regression mismatch for %s -> %s", tc.old, tc.new)
+	}
+}
+
+func TestRecordMsgStatus(t *testing.T) {
+	rp := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", "localhost:6379", redis.DialDatabase(0)) }}
+	defer rp.Close()
+
+	rc := rp.Get()
+	defer rc.Close()
+	_, err := rc.Do("FLUSHDB")
+	require.NoError(t, err)
+
+	b := newBackend(courier.NewDefaultConfig()).(*backend)
+	b.rp = rp
+
+	ch := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c95d", ID_: 10, ChannelType_: "KN", Config_: map[string]any{}}
+	record := func(status courier.MsgStatus) courier.MsgStatus {
+		clog := courier.NewChannelLog(courier.ChannelLogTypeMsgStatus, ch, nil)
+		prev, err := b.recordMsgStatus(rc, newStatusUpdate(ch, 1234, "", status, clog))
+		require.NoError(t, err)
+		return prev
+	}
+
+	assert.Equal(t, courier.NilMsgStatus, record(courier.MsgStatusWired))
+	assert.Equal(t, courier.NilMsgStatus, record(courier.MsgStatusErrored)) // providers can report errors after accepting
+	assert.Equal(t, courier.NilMsgStatus, record(courier.MsgStatusFailed))
+	assert.Equal(t, courier.MsgStatusFailed, record(courier.MsgStatusWired))
+
+	// popping the message to resend it means it can go back through earlier statuses
+	b.clearMsgStatus(&Msg{ID_: 1234, channel: ch})
+
+	assert.Equal(t, courier.NilMsgStatus, record(courier.MsgStatusWired))
+}
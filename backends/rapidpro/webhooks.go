@@ -0,0 +1,179 @@
+package rapidpro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/webhooks"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// number of workers delivering webhooks
+const webhookWorkers = 4
+
+// the type of events that are delivered to customer webhooks
+const (
+	webhookEventMsgStatus   = "msg_status"
+	webhookEventMsgReceived = "msg_received"
+)
+
+type webhookMsgStatus struct {
+	MsgID      courier.MsgID     `json:"msg_id,omitempty"`
+	ExternalID string            `json:"external_id,omitempty"`
+	Status     courier.MsgStatus `json:"status"`
+	ModifiedOn time.Time         `json:"modified_on"`
+}
+
+type webhookMsgReceived struct {
+	UUID        courier.MsgUUID `json:"uuid"`
+	ID          courier.MsgID   `json:"id"`
+	URN         urns.URN        `json:"urn"`
+	Text        string          `json:"text"`
+	Attachments []string        `json:"attachments,omitempty"`
+	ExternalID  string          `json:"external_id,omitempty"`
+	ReceivedOn  *time.Time      `json:"received_on,omitempty"`
+}
+
+type webhookPayload struct {
+	Type        string              `json:"type"`
+	ChannelUUID courier.ChannelUUID `json:"channel_uuid"`
+	Data        any                 `json:"data"`
+	CreatedOn   time.Time           `json:"created_on"`
+}
+
+// delivery webhooks can be configured on the channel or, for all of an org's channels, on the org
+func channelDeliveryWebhook(ch *Channel) (*webhooks.Config, error) {
+	raw := ch.ConfigForKey(courier.ConfigDeliveryWebhook, nil)
+	if raw == nil {
+		raw = ch.OrgConfigForKey(courier.ConfigDeliveryWebhook, nil)
+	}
+	if raw == nil {
+		return nil, nil
+	}
+	return webhooks.ReadConfig(raw)
+}
+
+// channels can also have a webhook to which incoming requests are forwarded
+func channelForwardWebhook(ch *Channel) (*webhooks.Config, error) {
+	raw := ch.ConfigForKey(courier.ConfigForwardWebhook, nil)
+	if raw == nil {
+		return nil, nil
+	}
+	return webhooks.ReadConfig(raw)
+}
+
+// queues an event to be delivered to the channel's delivery webhook if it has one
+func (b *backend) queueDeliveryWebhook(ch *Channel, typ string, data any) {
+	log := slog.With("comp", "backend", "channel_uuid", ch.UUID(), "type", typ)
+
+	cfg, err := channelDeliveryWebhook(ch)
+	if err != nil {
+		log.Error("error reading delivery webhook config", "error", err)
+		return
+	}
+	if cfg == nil {
+		return
+	}
+
+	body, err := json.Marshal(&webhookPayload{Type: typ, ChannelUUID: ch.UUID(), Data: data, CreatedOn: time.Now().In(time.UTC)})
+	if err != nil {
+		log.Error("error marshaling delivery webhook payload", "error", err)
+		return
+	}
+
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	if err := webhooks.Queue(rc, webhooks.NewJob(ch.UUID(), webhooks.KindDelivery, "application/json", body)); err != nil {
+		log.Error("error queuing delivery webhook", "error", err)
+	}
+}
+
+func (b *backend) queueStatusWebhook(s *StatusUpdate) {
+	if s.channel == nil {
+		return
+	}
+
+	b.queueDeliveryWebhook(s.channel, webhookEventMsgStatus, &webhookMsgStatus{
+		MsgID:      s.MsgID_,
+		ExternalID: s.ExternalID_,
+		Status:     s.Status_,
+		ModifiedOn: s.ModifiedOn_,
+	})
+}
+
+func (b *backend) queueMsgReceivedWebhook(m *Msg) {
+	b.queueDeliveryWebhook(m.channel, webhookEventMsgReceived, &webhookMsgReceived{
+		UUID:        m.UUID_,
+		ID:          m.ID_,
+		URN:         m.URN_,
+		Text:        m.Text_,
+		Attachments: m.Attachments_,
+		ExternalID:  string(m.ExternalID_),
+		ReceivedOn:  m.SentOn_,
+	})
+}
+
+// makes a single delivery attempt of a webhook job, recording it as a channel log
+func (b *backend) sendWebhook(ctx context.Context, job *webhooks.Job) error {
+	ch, err := b.channelsByUUID.GetOrFetch(ctx, job.ChannelUUID)
+	if err == courier.ErrChannelNotFound {
+		return fmt.Errorf("%w: channel no longer exists", webhooks.ErrNotConfigured)
+	} else if err != nil {
+		return fmt.Errorf("error loading channel for webhook: %w", err)
+	}
+
+	// look up the webhook config now rather than when the job was queued so that we use the latest
+	var cfg *webhooks.Config
+	switch job.Kind {
+	case webhooks.KindDelivery:
+		cfg, err = channelDeliveryWebhook(ch)
+	case webhooks.KindForward:
+		cfg, err = channelForwardWebhook(ch)
+	default:
+		return fmt.Errorf("%w: unknown kind %s", webhooks.ErrNotConfigured, job.Kind)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading webhook config: %w", err)
+	} else if cfg == nil {
+		return fmt.Errorf("%w: channel no longer has a %s webhook", webhooks.ErrNotConfigured, job.Kind)
+	}
+
+	var redactVals []string
+	if cfg.Secret != "" {
+		redactVals = append(redactVals, cfg.Secret)
+	}
+	if h := courier.GetHandler(ch.ChannelType()); h != nil {
+		redactVals = append(redactVals, h.RedactValues(ch)...)
+	}
+
+	clog := courier.NewChannelLog(courier.ChannelLogTypeWebhookSend, ch, redactVals)
+	defer func() {
+		clog.End()
+		b.WriteChannelLog(ctx, clog)
+	}()
+
+	req, err := job.NewRequest(ctx, cfg)
+	if err != nil {
+		clog.RawError(err)
+		return err
+	}
+
+	trace, err := httpx.DoTrace(b.httpClient, req, nil, b.httpAccess, 1024)
+	if trace != nil {
+		clog.HTTP(trace)
+	}
+	if err != nil {
+		return err
+	}
+	if trace.Response.StatusCode/100 != 2 {
+		clog.Error(courier.ErrorResponseStatusCode())
+		return fmt.Errorf("received non-2XX response: %d", trace.Response.StatusCode)
+	}
+	return nil
+}
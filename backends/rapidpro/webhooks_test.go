@@ -0,0 +1,64 @@
+package rapidpro
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/webhooks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelDeliveryWebhook(t *testing.T) {
+	ch := &Channel{}
+
+	cfg, err := channelDeliveryWebhook(ch)
+	assert.NoError(t, err)
+	assert.Nil(t, cfg)
+
+	// org config is used if channel doesn't have one
+	ch.OrgConfig_ = map[string]any{courier.ConfigDeliveryWebhook: map[string]any{"url": "https://org.example.com"}}
+
+	cfg, err = channelDeliveryWebhook(ch)
+	assert.NoError(t, err)
+	assert.Equal(t, &webhooks.Config{URL: "https://org.example.com", Method: "POST"}, cfg)
+
+	ch.Config_ = map[string]any{courier.ConfigDeliveryWebhook: map[string]any{"url": "https://channel.example.com", "secret": "sesame"}}
+
+	cfg, err = channelDeliveryWebhook(ch)
+	assert.NoError(t, err)
+	assert.Equal(t, &webhooks.Config{URL: "https://channel.example.com", Method: "POST", Secret: "sesame"}, cfg)
+
+	ch.Config_ = map[string]any{courier.ConfigDeliveryWebhook: map[string]any{"method": "POST"}}
+
+	_, err = channelDeliveryWebhook(ch)
+	assert.Error(t, err)
+}
+
+func TestSendWebhookNotConfigured(t *testing.T) {
+	ctx := context.Background()
+
+	ch := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c95d", Config_: map[string]any{}}
+
+	b := &backend{
+		channelsByUUID: newChannelCache(func(ctx context.Context, uuid courier.ChannelUUID, fromPrimary bool) (*Channel, error) {
+			if uuid == ch.UUID() {
+				return ch, nil
+			}
+			return nil, courier.ErrChannelNotFound
+		}),
+	}
+
+	// channel has been deleted
+	err := b.sendWebhook(ctx, webhooks.NewJob("0a1256fe-c6e4-494d-99d3-576286f31d3b", webhooks.KindDelivery, "application/json", []byte(`{}`)))
+	assert.ErrorIs(t, err, webhooks.ErrNotConfigured)
+
+	// channel no longer has the kind of webhook the job is for
+	err = b.sendWebhook(ctx, webhooks.NewJob(ch.UUID(), webhooks.KindDelivery, "application/json", []byte(`{}`)))
+	assert.ErrorIs(t, err, webhooks.ErrNotConfigured)
+
+	ch.Config_ = map[string]any{courier.ConfigDeliveryWebhook: map[string]any{"url": "https://channel.example.com"}}
+
+	err = b.sendWebhook(ctx, webhooks.NewJob(ch.UUID(), webhooks.KindForward, "application/json", []byte(`{}`)))
+	assert.ErrorIs(t, err, webhooks.ErrNotConfigured)
+}
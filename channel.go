@@ -55,6 +55,71 @@ const (
 
 	// ConfigSendHeaders is a constant key for channel configs
 	ConfigSendHeaders = "headers"
+
+	// ConfigDeliveryWebhook is a constant key for channel or org configs
+	ConfigDeliveryWebhook = "delivery_webhook"
+
+	// ConfigForwardWebhook is a constant key for channel configs
+	ConfigForwardWebhook = "webhook"
+
+	// ConfigRedactPII is a constant key for channel configs
+	ConfigRedactPII = "redact_pii"
+
+	// ConfigAllowedNetworks is a constant key for channel configs listing the IPs and networks incoming requests can come from
+	ConfigAllowedNetworks = "allowed_networks"
+
+	// ConfigInboundRateLimit is a constant key for channel configs overriding the max number of msgs per minute from a URN
+	ConfigInboundRateLimit = "inbound_rate_limit"
+
+	// ConfigBlockedURNs is a constant key for channel configs listing URNs whose incoming messages should be dropped
+	ConfigBlockedURNs = "blocked_urns"
+
+	// ConfigReassembleParts is a constant key for channel configs enabling reassembly of concatenated SMS segments
+	ConfigReassembleParts = "reassemble_parts"
+
+	// ConfigReassemblyTimeout is a constant key for channel configs setting how many seconds to wait for missing segments
+	ConfigReassemblyTimeout = "reassembly_timeout"
+
+	// ConfigReassemblyWindow is a constant key for channel configs setting a window in seconds in which messages from the
+	// same URN are merged, for providers which don't tell us which segments belong together
+	ConfigReassemblyWindow = "reassembly_window"
+
+	// ConfigDedupStrategy is a constant key for channel configs overriding how incoming messages are deduplicated
+	ConfigDedupStrategy = "dedup_strategy"
+
+	// ConfigDedupWindow is a constant key for channel configs overriding how many seconds after a message, another with
+	// the same content is considered a duplicate
+	ConfigDedupWindow = "dedup_window"
+
+	// ConfigDedupExternalIDWindow is a constant key for channel configs overriding how many seconds after a message,
+	// another with the same external ID is considered a duplicate
+	ConfigDedupExternalIDWindow = "dedup_external_id_window"
+
+	// ConfigOptKeywords is a constant key for channel configs enabling conversion of stop and start keywords to events
+	ConfigOptKeywords = "opt_keywords"
+
+	// ConfigStopKeywords is a constant key for channel configs overriding the keywords which stop a contact
+	ConfigStopKeywords = "stop_keywords"
+
+	// ConfigStartKeywords is a constant key for channel configs overriding the keywords which start a contact
+	ConfigStartKeywords = "start_keywords"
+
+	// ConfigStopReply is a constant key for channel configs setting a reply sent to contacts who send a stop keyword
+	ConfigStopReply = "stop_reply"
+
+	// ConfigStartReply is a constant key for channel configs setting a reply sent to contacts who send a start keyword
+	ConfigStartReply = "start_reply"
+
+	// ConfigMsgTTL is a constant key for channel configs setting how many seconds after creation outgoing messages expire,
+	// which doesn't apply to resends
+	ConfigMsgTTL = "msg_ttl"
+
+	// ConfigQuietHours is a constant key for channel configs setting a daily window like "21:00-08:00" during which flow
+	// and broadcast messages aren't sent
+	ConfigQuietHours = "quiet_hours"
+
+	// ConfigTimezone is a constant key for channel configs setting the timezone of the channel, e.g. for quiet hours
+	ConfigTimezone = "timezone"
 )
 
 // ChannelType is the 1-3 letter code used for channel types in the database
@@ -131,6 +196,9 @@ type Channel interface {
 
 	Roles() []ChannelRole
 
+	// is this channel owned by an anonymous org, i.e. one where URNs shouldn't be visible
+	OrgIsAnon() bool
+
 	// is this channel for the passed in scheme (and only that scheme)
 	IsScheme(*urns.Scheme) bool
 
@@ -0,0 +1,169 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/nyaruka/courier/utils"
+)
+
+// ConfigType is the type of value a channel config key should have
+type ConfigType string
+
+// possible config value types
+const (
+	ConfigTypeString ConfigType = "string"
+	ConfigTypeInt    ConfigType = "int"
+	ConfigTypeBool   ConfigType = "bool"
+	ConfigTypeURL    ConfigType = "url"
+	ConfigTypeMap    ConfigType = "map"
+)
+
+// ConfigField describes a single key in a channel's config
+type ConfigField struct {
+	Key      string     `json:"key"`
+	Type     ConfigType `json:"type"`
+	Required bool       `json:"required"`
+}
+
+// ConfigSchema describes the config keys used by channels of a particular type
+type ConfigSchema []*ConfigField
+
+// ConfigError is a problem with the value of a single key in a channel's config
+type ConfigError struct {
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+// Validate checks the given config values against this schema, returning an error for each invalid key
+func (s ConfigSchema) Validate(config map[string]any) []*ConfigError {
+	errs := make([]*ConfigError, 0)
+
+	for _, f := range s {
+		v := config[f.Key]
+
+		if v == nil || v == "" {
+			if f.Required {
+				errs = append(errs, &ConfigError{Key: f.Key, Message: "is required"})
+			}
+			continue
+		}
+
+		if !f.Type.isValid(v) {
+			errs = append(errs, &ConfigError{Key: f.Key, Message: fmt.Sprintf("must be a valid %s", f.Type)})
+		}
+	}
+
+	return errs
+}
+
+// returns whether the given (non-nil) value is valid for this type, allowing for how values are read by Channel
+// implementations, e.g. ints can be strings
+func (t ConfigType) isValid(v any) bool {
+	switch t {
+	case ConfigTypeString:
+		_, ok := v.(string)
+		return ok
+	case ConfigTypeInt:
+		switch typed := v.(type) {
+		case int, float64:
+			return true
+		case string:
+			_, err := strconv.Atoi(typed)
+			return err == nil
+		}
+		return false
+	case ConfigTypeBool:
+		_, ok := v.(bool)
+		return ok
+	case ConfigTypeURL:
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		u, err := url.Parse(s)
+		return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	case ConfigTypeMap:
+		_, ok := v.(map[string]any)
+		return ok
+	}
+	return true
+}
+
+// ValidateChannelConfig validates the config of the given channel against the schema declared by its handler, if
+// there is one, returning an error for each invalid key
+func ValidateChannelConfig(ch Channel) []*ConfigError {
+	d, ok := GetHandler(ch.ChannelType()).(ConfigSchemaDeclarer)
+	if !ok {
+		return nil
+	}
+
+	schema := d.ConfigSchema()
+	config := make(map[string]any, len(schema))
+	for _, f := range schema {
+		config[f.Key] = ch.ConfigForKey(f.Key, nil)
+	}
+
+	return schema.Validate(config)
+}
+
+type validateChannelRequest struct {
+	ChannelType ChannelType    `json:"channel_type" validate:"required"`
+	ChannelUUID ChannelUUID    `json:"channel_uuid" validate:"omitempty,uuid"`
+	Config      map[string]any `json:"config"`
+}
+
+type validateChannelResponse struct {
+	Valid  bool           `json:"valid"`
+	Errors []*ConfigError `json:"errors"`
+	Schema ConfigSchema   `json:"schema"`
+}
+
+// validates either the config of an existing channel or the given config for a channel of the given type
+func validateChannel(ctx context.Context, b Backend, r *http.Request) (*validateChannelResponse, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+
+	vr := &validateChannelRequest{}
+	if err := json.Unmarshal(body, vr); err != nil {
+		return nil, fmt.Errorf("error unmarshalling request: %w", err)
+	}
+	if err := utils.Validate(vr); err != nil {
+		return nil, err
+	}
+
+	handler := GetHandler(vr.ChannelType)
+	if handler == nil {
+		return nil, fmt.Errorf("unknown channel type: %s", vr.ChannelType)
+	}
+
+	var schema ConfigSchema
+	if d, ok := handler.(ConfigSchemaDeclarer); ok {
+		schema = d.ConfigSchema()
+	}
+
+	var errs []*ConfigError
+
+	if vr.ChannelUUID != NilChannelUUID {
+		ch, err := b.GetChannel(ctx, vr.ChannelType, vr.ChannelUUID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting channel: %w", err)
+		}
+		errs = ValidateChannelConfig(ch)
+	} else {
+		errs = schema.Validate(vr.Config)
+	}
+
+	if errs == nil {
+		errs = []*ConfigError{}
+	}
+
+	return &validateChannelResponse{Valid: len(errs) == 0, Errors: errs, Schema: schema}, nil
+}
@@ -0,0 +1,85 @@
+package courier_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigSchemaValidate(t *testing.T) {
+	schema := courier.ConfigSchema{
+		{Key: courier.ConfigAuthToken, Type: courier.ConfigTypeString, Required: true},
+		{Key: courier.ConfigSendURL, Type: courier.ConfigTypeURL, Required: true},
+		{Key: courier.ConfigMaxLength, Type: courier.ConfigTypeInt},
+		{Key: courier.ConfigUseNational, Type: courier.ConfigTypeBool},
+		{Key: courier.ConfigSendHeaders, Type: courier.ConfigTypeMap},
+	}
+
+	tcs := []struct {
+		config   map[string]any
+		expected []*courier.ConfigError
+	}{
+		{
+			config: map[string]any{courier.ConfigAuthToken: "123456", courier.ConfigSendURL: "https://example.com/send"},
+		},
+		{
+			config: map[string]any{
+				courier.ConfigAuthToken:   "123456",
+				courier.ConfigSendURL:     "http://example.com/send?to={{to}}",
+				courier.ConfigMaxLength:   float64(160),
+				courier.ConfigUseNational: true,
+				courier.ConfigSendHeaders: map[string]any{"foo": "bar"},
+			},
+		},
+		{
+			config: map[string]any{courier.ConfigAuthToken: "123456", courier.ConfigSendURL: "https://example.com/send", courier.ConfigMaxLength: "160"},
+		},
+		{
+			config: map[string]any{},
+			expected: []*courier.ConfigError{
+				{Key: "auth_token", Message: "is required"},
+				{Key: "send_url", Message: "is required"},
+			},
+		},
+		{
+			config: map[string]any{
+				courier.ConfigAuthToken:   "",
+				courier.ConfigSendURL:     "ftp://example.com",
+				courier.ConfigMaxLength:   "lots",
+				courier.ConfigUseNational: "true",
+				courier.ConfigSendHeaders: "foo: bar",
+			},
+			expected: []*courier.ConfigError{
+				{Key: "auth_token", Message: "is required"},
+				{Key: "send_url", Message: "must be a valid url"},
+				{Key: "max_length", Message: "must be a valid int"},
+				{Key: "use_national", Message: "must be a valid bool"},
+				{Key: "headers", Message: "must be a valid map"},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		expected := tc.expected
+		if expected == nil {
+			expected = []*courier.ConfigError{}
+		}
+		assert.Equal(t, expected, schema.Validate(tc.config), "errors mismatch for config %v", tc.config)
+	}
+}
+
+func TestValidateChannelConfig(t *testing.T) {
+	// mock handler declares max_length as an optional int
+	ch := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	assert.Equal(t, []*courier.ConfigError{}, courier.ValidateChannelConfig(ch))
+
+	ch = test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigMaxLength: "lots"})
+	assert.Equal(t, []*courier.ConfigError{{Key: "max_length", Message: "must be a valid int"}}, courier.ValidateChannelConfig(ch))
+
+	// channel types without a handler or whose handler doesn't declare a schema aren't validated
+	ch = test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "XX", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigMaxLength: "lots"})
+	assert.Nil(t, courier.ValidateChannelConfig(ch))
+}
@@ -0,0 +1,43 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/nyaruka/courier/utils"
+)
+
+type invalidateChannelRequest struct {
+	ChannelUUID    ChannelUUID    `json:"channel_uuid" validate:"required,uuid"`
+	ChannelAddress ChannelAddress `json:"channel_address"`
+}
+
+type invalidateChannelResponse struct {
+	ChannelUUID ChannelUUID `json:"channel_uuid"`
+}
+
+// invalidates a channel so that all instances evict their cached copies of it. This should be called by whatever has
+// changed or deleted the channel, e.g. RapidPro, including its previous address if that has changed.
+func invalidateChannel(ctx context.Context, b Backend, r *http.Request) (*invalidateChannelResponse, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+
+	ir := &invalidateChannelRequest{}
+	if err := json.Unmarshal(body, ir); err != nil {
+		return nil, fmt.Errorf("error unmarshalling request: %w", err)
+	}
+	if err := utils.Validate(ir); err != nil {
+		return nil, err
+	}
+
+	if err := b.InvalidateChannel(ctx, ir.ChannelUUID, ir.ChannelAddress); err != nil {
+		return nil, fmt.Errorf("error invalidating channel: %w", err)
+	}
+
+	return &invalidateChannelResponse{ChannelUUID: ir.ChannelUUID}, nil
+}
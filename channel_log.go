@@ -2,9 +2,19 @@ package courier
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/courier/utils/tracing"
 	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/gocommon/uuids"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -18,6 +28,7 @@ const (
 	ChannelLogTypeTokenRefresh    clogs.LogType = "token_refresh"
 	ChannelLogTypePageSubscribe   clogs.LogType = "page_subscribe"
 	ChannelLogTypeWebhookVerify   clogs.LogType = "webhook_verify"
+	ChannelLogTypeWebhookSend     clogs.LogType = "webhook_send"
 )
 
 func ErrorResponseStatusCode() *clogs.LogError {
@@ -45,10 +56,27 @@ func ErrorMediaUnresolveable(contentType string) *clogs.LogError {
 	return clogs.NewLogError("media_unresolveable", "", "Unable to find version of %s attachment compatible with channel.", contentType)
 }
 
+// ErrorChannelConfig is used when a channel's config is invalid according to the schema declared by its handler
+func ErrorChannelConfig(key, message string) *clogs.LogError {
+	return clogs.NewLogError("channel_config", "", "Channel config '%s' %s.", key, message)
+}
+
 func ErrorAttachmentNotDecodable() *clogs.LogError {
 	return clogs.NewLogError("attachment_not_decodable", "", "Unable to decode embedded attachment data.")
 }
 
+func WarningMsgDuplicate(uuid MsgUUID, by string) *clogs.LogError {
+	return clogs.NewLogError("msg_duplicate", "", "Message is a duplicate by %s of message %s.", by, uuid)
+}
+
+func ErrorMsgExpired(expiresOn time.Time) *clogs.LogError {
+	return clogs.NewLogError("msg_expired", "", "Message expired at %s before it could be sent.", expiresOn.UTC().Format(time.RFC3339))
+}
+
+func ErrorMsgNotDeferred(until time.Time) *clogs.LogError {
+	return clogs.NewLogError("msg_not_deferred", "", "Message couldn't be deferred until %s so will be retried as errored.", until.UTC().Format(time.RFC3339))
+}
+
 func ErrorExternal(code, message string) *clogs.LogError {
 	if message == "" {
 		message = fmt.Sprintf("Service specific error: %s.", code)
@@ -62,6 +90,7 @@ type ChannelLog struct {
 
 	channel  Channel
 	attached bool
+	span     trace.Span // span of the operation this log is for, if traced
 }
 
 // NewChannelLogForIncoming creates a new channel log for an incoming request, the type of which won't be known
@@ -86,11 +115,96 @@ func NewChannelLog(t clogs.LogType, ch Channel, redactVals []string) *ChannelLog
 }
 
 func newChannelLog(t clogs.LogType, ch Channel, r *httpx.Recorder, attached bool, redactVals []string) *ChannelLog {
-	return &ChannelLog{
+	l := &ChannelLog{
 		Log:      clogs.NewLog(t, r, redactVals),
 		channel:  ch,
 		attached: attached,
 	}
+
+	if ch != nil {
+		l.ChannelUUID = uuids.UUID(ch.UUID())
+		l.ChannelType = string(ch.ChannelType())
+	}
+
+	if RedactsPII(ch) {
+		l.AddRedactPatterns(piiPatterns...)
+
+		if h, ok := GetHandler(ch.ChannelType()).(PIIRedactor); ok {
+			l.AddRedactPatterns(h.RedactPatterns(ch)...)
+		}
+	}
+
+	return l
+}
+
+// patterns of PII which we redact from logs for all channel types, currently phone numbers in E164 format which might
+// be URL encoded
+var piiPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?:\+|%2B)[1-9]\d{6,14}\b`),
+}
+
+// values shorter than this aren't redacted as PII because they would mask too much of the logs
+const piiMinLength = 4
+
+// RedactsPII returns whether logs for the given channel should have PII such as URNs and message content redacted,
+// which is the case for channels owned by anonymous orgs or with the redact_pii config setting.
+func RedactsPII(ch Channel) bool {
+	return ch != nil && (ch.OrgIsAnon() || ch.BoolConfigForKey(ConfigRedactPII, false))
+}
+
+// RedactPII adds the given PII values, e.g. message text or URN paths, to be redacted from this log if the channel
+// requires that. Values are also redacted in their JSON and URL encoded forms as they appear in request bodies.
+func (l *ChannelLog) RedactPII(vals ...string) {
+	if !RedactsPII(l.channel) {
+		return
+	}
+
+	redact := make([]string, 0, len(vals)*3)
+	for _, v := range vals {
+		if len([]rune(v)) < piiMinLength {
+			continue
+		}
+
+		jsonEncoded := string(jsonx.MustMarshal(v))
+		redact = append(redact, v, jsonEncoded[1:len(jsonEncoded)-1], url.QueryEscape(v))
+	}
+
+	l.AddRedactValues(redact...)
+}
+
+// RedactMsgPII adds the PII values of the given message to be redacted from this log if the channel requires that
+func (l *ChannelLog) RedactMsgPII(urn urns.URN, text string, attachments []string) {
+	vals := []string{urn.Path(), text}
+	for _, a := range attachments {
+		// outgoing attachments are prefixed with their content type, incoming ones are plain URLs
+		if i := strings.Index(a, ":"); i >= 0 && !strings.HasPrefix(a[i+1:], "//") {
+			a = a[i+1:]
+		}
+		vals = append(vals, a)
+	}
+	l.RedactPII(vals...)
+}
+
+// HTTP adds the given HTTP trace to this log, and records it as a span if the operation is being traced
+func (l *ChannelLog) HTTP(t *httpx.Trace) {
+	l.Log.HTTP(t)
+
+	tracing.RecordHTTP(l.span, t)
+}
+
+// StartHTTP starts a span for the given request if the operation is being traced, adding headers to the request so the
+// trace can be continued by the receiver. The span should be ended by passing the request trace to EndHTTP.
+func (l *ChannelLog) StartHTTP(r *http.Request) trace.Span {
+	return tracing.StartHTTP(l.span, r)
+}
+
+// EndHTTP adds the given HTTP trace to this log and ends the span started for it with StartHTTP
+func (l *ChannelLog) EndHTTP(span trace.Span, t *httpx.Trace) {
+	if t != nil {
+		l.Log.HTTP(t)
+	}
+
+	tracing.EndHTTP(span, t)
 }
 
 // Deprecated: channel handlers should add user-facing error messages via .Error() instead
@@ -3,6 +3,7 @@ package courier_test
 import (
 	"errors"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -51,6 +52,8 @@ func TestChannelLog(t *testing.T) {
 	assert.Equal(t, clogs.LogUUID("0191e180-7d60-7000-aded-7d8b151cbd5b"), clog.UUID)
 	assert.Equal(t, courier.ChannelLogTypeTokenRefresh, clog.Type)
 	assert.Equal(t, channel, clog.Channel())
+	assert.Equal(t, uuids.UUID("fef91e9b-a6ed-44fb-b6ce-feed8af585a8"), clog.ChannelUUID)
+	assert.Equal(t, "NX", clog.ChannelType)
 	assert.False(t, clog.Attached())
 	assert.Equal(t, 2, len(clog.HttpLogs))
 	assert.Equal(t, 2, len(clog.Errors))
@@ -123,6 +126,11 @@ func TestChannelErrors(t *testing.T) {
 			expectedCode:    "attachment_not_decodable",
 			expectedMessage: "Unable to decode embedded attachment data.",
 		},
+		{
+			err:             courier.ErrorMsgExpired(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)),
+			expectedCode:    "msg_expired",
+			expectedMessage: "Message expired at 2024-01-02T03:04:05Z before it could be sent.",
+		},
 		{
 			err:             courier.ErrorExternal("20002", "Invalid FriendlyName."),
 			expectedCode:    "external",
@@ -143,3 +151,57 @@ func TestChannelErrors(t *testing.T) {
 		assert.Equal(t, tc.expectedMessage, tc.err.Message)
 	}
 }
+
+func TestChannelLogPII(t *testing.T) {
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://api.messages.com/send.json": {
+			httpx.NewMockResponse(200, nil, []byte(`{"to":"+250788383383","text":"Hello \"Bob\""}`)),
+			httpx.NewMockResponse(200, nil, []byte(`{"to":"+250788383383","text":"Hello \"Bob\""}`)),
+		},
+	}))
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	doRequest := func(clog *courier.ChannelLog) {
+		req, _ := http.NewRequest("POST", "https://api.messages.com/send.json", strings.NewReader(`to=%2B250788383383&text=Hello+%22Bob%22&media=https://x.com/a.jpg`))
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		assert.NoError(t, err)
+		clog.HTTP(trace)
+	}
+
+	// channel without PII redaction
+	channel := test.NewMockChannel("fef91e9b-a6ed-44fb-b6ce-feed8af585a8", "NX", "1234", "US", []string{urns.Phone.Prefix}, nil)
+	assert.False(t, courier.RedactsPII(channel))
+
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, channel, nil)
+	doRequest(clog)
+	clog.RedactMsgPII("tel:+250788383383", `Hello "Bob"`, []string{"image/jpeg:https://x.com/a.jpg"})
+	clog.End()
+
+	assert.Contains(t, clog.HttpLogs[0].Request, "to=%2B250788383383&text=Hello+%22Bob%22")
+	assert.Contains(t, clog.HttpLogs[0].Response, `{"to":"+250788383383","text":"Hello \"Bob\""}`)
+
+	// channel with PII redaction set in its config
+	channel = test.NewMockChannel("fef91e9b-a6ed-44fb-b6ce-feed8af585a8", "NX", "1234", "US", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigRedactPII: true})
+	assert.True(t, courier.RedactsPII(channel))
+
+	clog = courier.NewChannelLog(courier.ChannelLogTypeMsgSend, channel, nil)
+	doRequest(clog)
+	clog.RedactMsgPII("tel:+250788383383", `Hello "Bob"`, []string{"image/jpeg:https://x.com/a.jpg"})
+	clog.Error(clogs.NewLogError("", "", `Unable to send Hello "Bob" to +250788383383`))
+	clog.End()
+
+	assert.Contains(t, clog.HttpLogs[0].Request, "to=**********&text=**********&media=**********")
+	assert.Contains(t, clog.HttpLogs[0].Response, `{"to":"**********","text":"**********"}`)
+	assert.Equal(t, "Unable to send ********** to **********", clog.Errors[0].Message)
+
+	// channel with anonymous org
+	channel = test.NewMockChannel("fef91e9b-a6ed-44fb-b6ce-feed8af585a8", "NX", "1234", "US", []string{urns.Phone.Prefix}, nil)
+	channel.SetOrgIsAnon(true)
+	assert.True(t, courier.RedactsPII(channel))
+
+	// short values aren't redacted
+	clog = courier.NewChannelLog(courier.ChannelLogTypeMsgSend, channel, nil)
+	clog.RedactPII("Hi")
+	clog.Error(clogs.NewLogError("", "", "Hi there"))
+	assert.Equal(t, "Hi there", clog.Errors[0].Message)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	ulog "log"
 	"log/slog"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"github.com/getsentry/sentry-go"
 	_ "github.com/lib/pq"
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils/tracing"
 	slogmulti "github.com/samber/slog-multi"
 	slogsentry "github.com/samber/slog-sentry/v2"
 
@@ -114,6 +116,24 @@ func main() {
 	log := slog.With("comp", "main")
 	log.Info("starting courier", "version", version, "released", date)
 
+	// if we have a trace endpoint, start exporting traces to it
+	if config.TraceEndpoint != "" {
+		shutdownTracing, err := tracing.Start(context.Background(), config.TraceEndpoint, config.TraceSampleRate, version, config.InstanceID)
+		if err != nil {
+			log.Error("error starting tracing", "error", err)
+			os.Exit(1)
+		}
+
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := shutdownTracing(ctx); err != nil {
+				log.Error("error shutting down tracing", "error", err)
+			}
+		}()
+	}
+
 	// load our backend
 	backend, err := courier.NewBackend(config)
 	if err != nil {
@@ -17,14 +17,15 @@ import (
 
 // Config is our top level configuration object
 type Config struct {
-	Backend   string `help:"the backend that will be used by courier (currently only rapidpro is supported)"`
-	SentryDSN string `help:"the DSN used for logging errors to Sentry"`
-	Domain    string `help:"the domain courier is exposed on"`
-	Address   string `help:"the network interface address courier will bind to"`
-	Port      int    `help:"the port courier will listen on"`
-	DB        string `validate:"url,startswith=postgres:"   help:"URL for your Postgres database"`
-	Redis     string `validate:"url,startswith=redis:"      help:"URL for your Redis instance"`
-	SpoolDir  string `help:"the local directory where courier will write statuses or msgs that need to be retried (needs to be writable)"`
+	Backend    string `help:"the backend that will be used by courier (currently only rapidpro is supported)"`
+	SentryDSN  string `help:"the DSN used for logging errors to Sentry"`
+	Domain     string `help:"the domain courier is exposed on"`
+	Address    string `help:"the network interface address courier will bind to"`
+	Port       int    `help:"the port courier will listen on"`
+	DB         string `validate:"url,startswith=postgres:"   help:"URL for your Postgres database"`
+	ReadonlyDB string `validate:"omitempty,url,startswith=postgres:" help:"URL for a read-only replica of your Postgres database used for lookups (optional)"`
+	Redis      string `validate:"url,startswith=redis"       help:"URL for your Redis instance, or redis+sentinel://host:port,.../<primary name>/<db> to use Sentinel"`
+	SpoolDir   string `help:"the local directory where courier will write statuses or msgs that need to be retried (needs to be writable)"`
 
 	AWSAccessKeyID     string `help:"access key ID to use for AWS services"`
 	AWSSecretAccessKey string `help:"secret access key to use for AWS services"`
@@ -42,22 +43,38 @@ type Config struct {
 	S3AttachmentsBucket string `help:"S3 bucket to write attachments to"`
 	S3Minio             bool   `help:"S3 is actually Minio or other compatible service"`
 
+	ChannelLogSink       string `validate:"omitempty,oneof=dynamo file stdout" help:"where channel logs are written, one of dynamo, file or stdout"`
+	ChannelLogFile       string `help:"the path of the JSONL file channel logs are written to when using the file sink"`
+	ChannelLogFileSize   int    `help:"the size in MB at which the channel log file is rotated"`
+	ChannelLogFileBackup int    `help:"the number of rotated channel log files to keep"`
+
+	SecretsEnvPrefix string `help:"the prefix environment variables must have to be referenced as secrets in channel config, e.g. env:COURIER_SECRET_TOKEN"`
+	SecretsDir       string `help:"the directory files must be in to be referenced as secrets in channel config, e.g. file:/run/secrets/token"`
+
+	TraceEndpoint   string  `help:"the OTLP HTTP endpoint to export traces to, e.g. http://localhost:4318 (empty to disable tracing)"`
+	TraceSampleRate float64 `validate:"min=0,max=1" help:"the fraction of traces to sample, from 0 to 1"`
+
 	WhatsappCloudApplicationSecret string `help:"the Whatsapp Cloud app secret"`
 	WhatsappCloudWebhookSecret     string `help:"the secret for WhatsApp Cloud webhook URL verification"`
 	FacebookApplicationSecret      string `help:"the Facebook app secret"`
 	FacebookWebhookSecret          string `help:"the secret for Facebook webhook URL verification"`
 	WhatsappAdminSystemUserToken   string `help:"the token of the admin system user for WhatsApp"`
 
-	DisallowedNetworks string     `help:"comma separated list of IP addresses and networks which we disallow fetching attachments from"`
-	MediaDomain        string     `help:"the domain on which we'll try to resolve outgoing media URLs"`
-	MaxWorkers         int        `help:"the maximum number of go routines that will be used for sending (set to 0 to disable sending)"`
-	LibratoUsername    string     `help:"the username that will be used to authenticate to Librato"`
-	LibratoToken       string     `help:"the token that will be used to authenticate to Librato"`
-	StatusUsername     string     `help:"the username that is needed to authenticate against the /status endpoint"`
-	StatusPassword     string     `help:"the password that is needed to authenticate against the /status endpoint"`
-	AuthToken          string     `help:"the authentication token need to access non-channel endpoints"`
-	LogLevel           slog.Level `help:"the logging level courier should use"`
-	Version            string     `help:"the version that will be used in request and response headers"`
+	DisallowedNetworks   string     `help:"comma separated list of IP addresses and networks which we disallow fetching attachments from"`
+	TrustedProxies       string     `help:"comma separated list of IP addresses and networks of proxies whose X-Forwarded-For and X-Real-IP headers we trust"`
+	MediaDomain          string     `help:"the domain on which we'll try to resolve outgoing media URLs"`
+	MaxWorkers           int        `help:"the maximum number of go routines that will be used for sending (set to 0 to disable sending)"`
+	IncomingWorkers      int        `help:"the number of go routines that process incoming requests queued by handlers which receive asynchronously (set to 0 to handle all requests synchronously)"`
+	InboundRateLimit     int        `help:"the maximum number of messages per minute accepted from a single URN on a channel, which channels can override (set to 0 for no limit)"`
+	ArchiveIncomingHours int        `help:"the number of hours raw incoming requests are archived for so that they can be replayed (set to 0 to disable archiving)"`
+	DrainTimeout         int        `help:"the number of seconds to wait on shutdown for in-flight sends to complete before cancelling them"`
+	LibratoUsername      string     `help:"the username that will be used to authenticate to Librato"`
+	LibratoToken         string     `help:"the token that will be used to authenticate to Librato"`
+	StatusUsername       string     `help:"the username that is needed to authenticate against the /status endpoint"`
+	StatusPassword       string     `help:"the password that is needed to authenticate against the /status endpoint"`
+	AuthToken            string     `help:"the authentication token need to access non-channel endpoints"`
+	LogLevel             slog.Level `help:"the logging level courier should use"`
+	Version              string     `help:"the version that will be used in request and response headers"`
 
 	// IncludeChannels is the list of channels to enable, empty means include all
 	IncludeChannels []string
@@ -94,13 +111,26 @@ func NewDefaultConfig() *Config {
 		S3AttachmentsBucket: "temba-attachments",
 		S3Minio:             false,
 
+		ChannelLogSink:       "dynamo",
+		ChannelLogFile:       "/var/log/courier/channel-logs.jsonl",
+		ChannelLogFileSize:   100,
+		ChannelLogFileBackup: 5,
+
+		SecretsEnvPrefix: "COURIER_SECRET_",
+		SecretsDir:       "/run/secrets",
+
+		TraceEndpoint:   "",
+		TraceSampleRate: 1,
+
 		FacebookApplicationSecret:      "missing_facebook_app_secret",
 		FacebookWebhookSecret:          "missing_facebook_webhook_secret",
 		WhatsappAdminSystemUserToken:   "missing_whatsapp_admin_system_user_token",
 		WhatsappCloudApplicationSecret: "missing_whatsapp_cloud_app_secret",
 
 		DisallowedNetworks: `127.0.0.1,::1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,169.254.0.0/16,fe80::/10`,
+		TrustedProxies:     `127.0.0.1,::1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7`,
 		MaxWorkers:         32,
+		DrainTimeout:       30,
 		LogLevel:           slog.LevelWarn,
 		Version:            "Dev",
 	}
@@ -128,6 +158,13 @@ func (c *Config) Validate() error {
 	if _, _, err := c.ParseDisallowedNetworks(); err != nil {
 		return fmt.Errorf("unable to parse 'DisallowedNetworks': %w", err)
 	}
+	if _, _, err := c.ParseTrustedProxies(); err != nil {
+		return fmt.Errorf("unable to parse 'TrustedProxies': %w", err)
+	}
+
+	if c.ChannelLogSink == "file" && (c.ChannelLogFile == "" || c.ChannelLogFileSize <= 0) {
+		return fmt.Errorf("'ChannelLogFile' and 'ChannelLogFileSize' are required when 'ChannelLogSink' is file")
+	}
 	return nil
 }
 
@@ -140,3 +177,13 @@ func (c *Config) ParseDisallowedNetworks() ([]net.IP, []*net.IPNet, error) {
 
 	return httpx.ParseNetworks(addrs...)
 }
+
+// ParseTrustedProxies parses the list of IPs and IP networks (written in CIDR notation) of trusted proxies
+func (c *Config) ParseTrustedProxies() ([]net.IP, []*net.IPNet, error) {
+	addrs, err := csv.NewReader(strings.NewReader(c.TrustedProxies)).Read()
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+
+	return httpx.ParseNetworks(addrs...)
+}
@@ -0,0 +1,63 @@
+package courier
+
+import (
+	"time"
+)
+
+// DedupStrategy is how incoming messages are checked against recently received messages to detect duplicates
+type DedupStrategy string
+
+// possible values for DedupStrategy
+const (
+	DedupAuto       DedupStrategy = "auto"        // by external ID if message has one, otherwise by content
+	DedupExternalID DedupStrategy = "external_id" // only by external ID, messages without one are never duplicates
+	DedupContent    DedupStrategy = "content"     // only by content, even if message has an external ID
+	DedupNone       DedupStrategy = "none"        // messages are never duplicates
+)
+
+func (s DedupStrategy) isValid() bool {
+	return s == DedupAuto || s == DedupExternalID || s == DedupContent || s == DedupNone
+}
+
+// DedupPolicy is how incoming messages on a channel are deduplicated
+type DedupPolicy struct {
+	Strategy         DedupStrategy
+	ContentWindow    time.Duration // how long after a message, one from the same URN with the same content is a duplicate
+	ExternalIDWindow time.Duration // how long after a message, one from the same URN with the same external ID is a duplicate
+}
+
+// DedupPolicyDeclarer is the interface handlers which need a different dedup policy to the default should satisfy. Zero
+// values in the returned policy mean the default is used.
+type DedupPolicyDeclarer interface {
+	DedupPolicy() *DedupPolicy
+}
+
+// GetDedupPolicy returns the dedup policy for the given channel, which is the given default policy overridden by the
+// policy declared by its handler and then by its own config
+func GetDedupPolicy(ch Channel, defaults *DedupPolicy) *DedupPolicy {
+	policy := *defaults
+
+	if d, ok := GetHandler(ch.ChannelType()).(DedupPolicyDeclarer); ok {
+		policy.override(d.DedupPolicy())
+	}
+
+	policy.override(&DedupPolicy{
+		Strategy:         DedupStrategy(ch.StringConfigForKey(ConfigDedupStrategy, "")),
+		ContentWindow:    time.Duration(ch.IntConfigForKey(ConfigDedupWindow, 0)) * time.Second,
+		ExternalIDWindow: time.Duration(ch.IntConfigForKey(ConfigDedupExternalIDWindow, 0)) * time.Second,
+	})
+
+	return &policy
+}
+
+func (p *DedupPolicy) override(o *DedupPolicy) {
+	if o.Strategy.isValid() {
+		p.Strategy = o.Strategy
+	}
+	if o.ContentWindow > 0 {
+		p.ContentWindow = o.ContentWindow
+	}
+	if o.ExternalIDWindow > 0 {
+		p.ExternalIDWindow = o.ExternalIDWindow
+	}
+}
@@ -0,0 +1,29 @@
+package courier_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDedupPolicy(t *testing.T) {
+	defaults := &courier.DedupPolicy{Strategy: courier.DedupAuto, ContentWindow: 2 * time.Second, ExternalIDWindow: 24 * time.Hour}
+
+	ch := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "MCK", "1234", "EC", []string{urns.Phone.Prefix}, map[string]any{})
+	assert.Equal(t, defaults, courier.GetDedupPolicy(ch, defaults))
+
+	ch = test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "MCK", "1234", "EC", []string{urns.Phone.Prefix}, map[string]any{
+		courier.ConfigDedupStrategy:         "external_id",
+		courier.ConfigDedupWindow:           "60",
+		courier.ConfigDedupExternalIDWindow: float64(3600),
+	})
+	assert.Equal(t, &courier.DedupPolicy{Strategy: courier.DedupExternalID, ContentWindow: time.Minute, ExternalIDWindow: time.Hour}, courier.GetDedupPolicy(ch, defaults))
+
+	// invalid strategies are ignored
+	ch = test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "MCK", "1234", "EC", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigDedupStrategy: "xxx"})
+	assert.Equal(t, defaults, courier.GetDedupPolicy(ch, defaults))
+}
@@ -3,6 +3,8 @@ package courier
 import (
 	"context"
 	"net/http"
+	"regexp"
+	"slices"
 
 	"github.com/nyaruka/gocommon/urns"
 )
@@ -45,6 +47,39 @@ type AttachmentRequestBuilder interface {
 	BuildAttachmentRequest(context.Context, Backend, Channel, string, *ChannelLog) (*http.Request, error)
 }
 
+// PIIRedactor is the interface handlers which can match PII such as user identifiers in their requests and responses
+// should satisfy. Patterns are only applied to logs of channels which require PII to be redacted.
+type PIIRedactor interface {
+	RedactPatterns(Channel) []*regexp.Regexp
+}
+
+// ConfigSchemaDeclarer is the interface handlers which declare the config keys used by their channels should satisfy.
+// Channel configs are then validated before sends and by the channel validation endpoint.
+type ConfigSchemaDeclarer interface {
+	ConfigSchema() ConfigSchema
+}
+
+// SecretConfigDeclarer is the interface handlers which declare which of their channels' config keys hold secrets should
+// satisfy. Only the values of these keys are resolved if they are references to secrets stored outside of the database.
+type SecretConfigDeclarer interface {
+	SecretConfigKeys() []string
+}
+
+// IsSecretConfigKey returns whether the handler for the given channel type declares the given config key as a secret
+func IsSecretConfigKey(ct ChannelType, key string) bool {
+	if d, ok := GetHandler(ct).(SecretConfigDeclarer); ok {
+		return slices.Contains(d.SecretConfigKeys(), key)
+	}
+	return false
+}
+
+// AsyncReceiver is the interface handlers which want some incoming requests acknowledged immediately and processed in
+// the background should satisfy. Only requests for a known channel are considered, and handlers should only return true
+// for requests they have verified, e.g. by checking a signature, as the caller will receive a success response.
+type AsyncReceiver interface {
+	ReceiveAsync(context.Context, Channel, *http.Request) bool
+}
+
 // RegisterHandler adds a new handler for a channel type, this is called by individual handlers when they are initialized
 func RegisterHandler(handler ChannelHandler) {
 	registeredHandlers[handler.ChannelType()] = handler
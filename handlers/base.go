@@ -94,6 +94,11 @@ func (h *BaseHandler) RedactValues(ch courier.Channel) []string {
 	return vals
 }
 
+// SecretConfigKeys returns the config keys whose values are secrets, which are the same keys whose values are redacted
+func (h *BaseHandler) SecretConfigKeys() []string {
+	return h.redactConfigKeys
+}
+
 // GetChannel returns the channel
 func (h *BaseHandler) GetChannel(ctx context.Context, r *http.Request) (courier.Channel, error) {
 	uuid := courier.ChannelUUID(r.PathValue("uuid"))
@@ -117,9 +122,11 @@ func (h *BaseHandler) RequestHTTPWithClient(client *http.Client, req *http.Reque
 
 	req.Header.Set("User-Agent", fmt.Sprintf("Courier/%s", h.server.Config().Version))
 
+	span := clog.StartHTTP(req)
+
 	trace, err := httpx.DoTrace(client, req, nil, h.backend.HttpAccess(), 0)
+	clog.EndHTTP(span, trace)
 	if trace != nil {
-		clog.HTTP(trace)
 		resp = trace.Response
 		body = trace.ResponseBody
 	}
@@ -7,6 +7,7 @@ import (
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/secrets"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/stretchr/testify/assert"
@@ -22,7 +23,7 @@ func TestRequestHTTP(t *testing.T) {
 	defer httpx.SetRequestor(httpx.DefaultRequestor)
 
 	mb := test.NewMockBackend()
-	mc := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "NX", "1234", "EC", []string{urns.Phone.Prefix}, nil)
+	mc := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "MCK", "1234", "EC", []string{urns.Phone.Prefix}, nil)
 	mm := mb.NewOutgoingMsg(mc, 123, urns.URN("tel:+1234"), "Hello World", false, nil, "", "", courier.MsgOriginChat, nil)
 	clog := courier.NewChannelLogForSend(mm, nil)
 
@@ -53,3 +54,23 @@ func TestRequestHTTP(t *testing.T) {
 	assert.Equal(t, 400, hlog2.StatusCode)
 	assert.Equal(t, "https://api.messages.com/send.json", hlog2.URL)
 }
+
+func TestRedactValues(t *testing.T) {
+	t.Setenv("COURIER_SECRET_NX_AUTH_TOKEN", "sesame")
+	secrets.Configure("COURIER_SECRET_", "")
+	defer secrets.Configure("", "")
+	defer secrets.ClearCache()
+
+	h := handlers.NewBaseHandler("NX", "Test", handlers.WithRedactConfigKeys(courier.ConfigAuthToken, courier.ConfigAPIKey))
+
+	assert.Equal(t, []string{courier.ConfigAuthToken, courier.ConfigAPIKey}, h.SecretConfigKeys())
+
+	assert.Nil(t, h.RedactValues(nil))
+
+	// secret references are redacted by their resolved values
+	mc := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "MCK", "1234", "EC", []string{urns.Phone.Prefix}, map[string]any{
+		courier.ConfigAuthToken: "env:COURIER_SECRET_NX_AUTH_TOKEN",
+		courier.ConfigAPIKey:    "opensesame",
+	})
+	assert.Equal(t, []string{"sesame", "opensesame"}, h.RedactValues(mc))
+}
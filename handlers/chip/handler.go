@@ -75,7 +75,10 @@ func (h *handler) receive(ctx context.Context, c courier.Channel, w http.Respons
 		if event.Type == "msg_in" {
 			msg := h.Backend().NewIncomingMsg(c, urn, event.Msg.Text, "", clog)
 
-			if err = h.Backend().WriteMsg(ctx, msg, clog); err != nil {
+			if err = h.Backend().WriteMsg(ctx, msg, clog); errors.Is(err, courier.ErrMsgIgnored) {
+				data = append(data, courier.NewInfoData(err.Error()))
+				continue
+			} else if err != nil {
 				return nil, err
 			}
 
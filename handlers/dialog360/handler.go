@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -189,7 +190,10 @@ func (h *handler) processWhatsAppPayload(ctx context.Context, channel courier.Ch
 				}
 
 				err = h.Backend().WriteMsg(ctx, event, clog)
-				if err != nil {
+				if errors.Is(err, courier.ErrMsgIgnored) {
+					data = append(data, courier.NewInfoData(err.Error()))
+					continue
+				} else if err != nil {
 					return nil, nil, err
 				}
 
@@ -3,6 +3,7 @@ package external
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -35,9 +36,13 @@ const (
 	configMOResponse            = "mo_response"
 
 	configMTResponseCheck = "mt_response_check"
-	configEncoding        = "encoding"
-	encodingDefault       = "D"
-	encodingSmart         = "S"
+
+	signatureHeader = "X-Courier-Signature"
+	timestampHeader = "X-Courier-Timestamp"
+
+	configEncoding  = "encoding"
+	encodingDefault = "D"
+	encodingSmart   = "S"
 )
 
 var defaultFromFields = []string{"from", "sender"}
@@ -50,6 +55,18 @@ var contentTypeMappings = map[string]string{
 	contentXML:        "text/xml; charset=utf-8",
 }
 
+// channels with a secret require requests to be signed with an HMAC-SHA256 of the timestamp, full URL and body, e.g.
+// hex(hmac_sha256(secret, "1700000000.https://courier.example.com/c/ex/<uuid>/receive?from=...<body>"))
+var signature = &handlers.HMACSignature{
+	Header:          signatureHeader,
+	Prefix:          "sha256=",
+	Hash:            sha256.New,
+	Content:         handlers.SignedURLAndBody,
+	Secret:          handlers.ChannelConfigSecret(courier.ConfigSecret),
+	Optional:        true,
+	TimestampHeader: timestampHeader,
+}
+
 func init() {
 	courier.RegisterHandler(newHandler())
 }
@@ -62,26 +79,46 @@ func newHandler() courier.ChannelHandler {
 	return &handler{handlers.NewBaseHandler(courier.ChannelType("EX"), "External")}
 }
 
+// ConfigSchema returns the config keys used by external channels
+func (h *handler) ConfigSchema() courier.ConfigSchema {
+	return courier.ConfigSchema{
+		{Key: courier.ConfigSendURL, Type: courier.ConfigTypeURL, Required: true},
+		{Key: courier.ConfigSendMethod, Type: courier.ConfigTypeString},
+		{Key: courier.ConfigSendBody, Type: courier.ConfigTypeString},
+		{Key: courier.ConfigContentType, Type: courier.ConfigTypeString},
+		{Key: courier.ConfigSendAuthorization, Type: courier.ConfigTypeString},
+		{Key: courier.ConfigSendHeaders, Type: courier.ConfigTypeMap},
+		{Key: courier.ConfigMaxLength, Type: courier.ConfigTypeInt},
+		{Key: courier.ConfigUseNational, Type: courier.ConfigTypeBool},
+		{Key: configEncoding, Type: courier.ConfigTypeString},
+		{Key: configMTResponseCheck, Type: courier.ConfigTypeString},
+		{Key: courier.ConfigSecret, Type: courier.ConfigTypeString},
+	}
+}
+
 // Initialize is called by the engine once everything is loaded
 func (h *handler) Initialize(s courier.Server) error {
 	h.SetServer(s)
-	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeMsgReceive, h.receiveMessage)
-	s.AddHandlerRoute(h, http.MethodGet, "receive", courier.ChannelLogTypeMsgReceive, h.receiveMessage)
 
-	sentHandler := h.buildStatusHandler("sent")
+	receiveMessage := handlers.Verified(h, signature, h.receiveMessage)
+	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeMsgReceive, receiveMessage)
+	s.AddHandlerRoute(h, http.MethodGet, "receive", courier.ChannelLogTypeMsgReceive, receiveMessage)
+
+	sentHandler := handlers.Verified(h, signature, h.buildStatusHandler("sent"))
 	s.AddHandlerRoute(h, http.MethodGet, "sent", courier.ChannelLogTypeMsgStatus, sentHandler)
 	s.AddHandlerRoute(h, http.MethodPost, "sent", courier.ChannelLogTypeMsgStatus, sentHandler)
 
-	deliveredHandler := h.buildStatusHandler("delivered")
+	deliveredHandler := handlers.Verified(h, signature, h.buildStatusHandler("delivered"))
 	s.AddHandlerRoute(h, http.MethodGet, "delivered", courier.ChannelLogTypeMsgStatus, deliveredHandler)
 	s.AddHandlerRoute(h, http.MethodPost, "delivered", courier.ChannelLogTypeMsgStatus, deliveredHandler)
 
-	failedHandler := h.buildStatusHandler("failed")
+	failedHandler := handlers.Verified(h, signature, h.buildStatusHandler("failed"))
 	s.AddHandlerRoute(h, http.MethodGet, "failed", courier.ChannelLogTypeMsgStatus, failedHandler)
 	s.AddHandlerRoute(h, http.MethodPost, "failed", courier.ChannelLogTypeMsgStatus, failedHandler)
 
-	s.AddHandlerRoute(h, http.MethodPost, "stopped", courier.ChannelLogTypeEventReceive, h.receiveStopContact)
-	s.AddHandlerRoute(h, http.MethodGet, "stopped", courier.ChannelLogTypeEventReceive, h.receiveStopContact)
+	receiveStopContact := handlers.Verified(h, signature, h.receiveStopContact)
+	s.AddHandlerRoute(h, http.MethodPost, "stopped", courier.ChannelLogTypeEventReceive, receiveStopContact)
+	s.AddHandlerRoute(h, http.MethodGet, "stopped", courier.ChannelLogTypeEventReceive, receiveStopContact)
 
 	return nil
 }
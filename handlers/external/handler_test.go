@@ -9,8 +9,10 @@ import (
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
 )
 
 const (
@@ -295,13 +297,92 @@ var extReceiveTestCases = []IncomingTestCase{
 	},
 }
 
+var signedChannels = []courier.Channel{
+	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "EX", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigSecret: "sesame"}),
+}
+
+var signedTestCases = []IncomingTestCase{
+	{
+		Label:                "Receive Signed Message",
+		URL:                  receiveURL + "?sender=%2B2349067554729&text=Join",
+		Data:                 "empty",
+		Headers:              map[string]string{timestampHeader: "1523471070"},
+		PrepRequest:          addValidSignature,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedMsgText:      Sp("Join"),
+		ExpectedURN:          "tel:+2349067554729",
+	},
+	{
+		Label:                "Receive Signed Post",
+		URL:                  receiveURL,
+		Data:                 "sender=%2B2349067554729&text=Join",
+		Headers:              map[string]string{timestampHeader: "1523471070"},
+		PrepRequest:          addValidSignature,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedMsgText:      Sp("Join"),
+		ExpectedURN:          "tel:+2349067554729",
+	},
+	{
+		Label:                "Receive Missing Signature",
+		URL:                  receiveURL + "?sender=%2B2349067554729&text=Join",
+		Data:                 "empty",
+		Headers:              map[string]string{timestampHeader: "1523471070"},
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "missing request signature",
+	},
+	{
+		Label:                "Receive Invalid Signature",
+		URL:                  receiveURL + "?sender=%2B2349067554729&text=Join",
+		Data:                 "empty",
+		Headers:              map[string]string{timestampHeader: "1523471070", signatureHeader: "sha256=1234"},
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "invalid request signature",
+	},
+	{
+		Label:                "Receive Signature For Other Params",
+		URL:                  receiveURL + "?sender=%2B2349067554729&text=Join",
+		Data:                 "empty",
+		Headers:              map[string]string{timestampHeader: "1523471070"},
+		PrepRequest:          func(r *http.Request) { addValidSignature(r); r.URL.RawQuery = "sender=%2B2349067554729&text=Leave" },
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "invalid request signature",
+	},
+	{
+		Label:                "Receive Expired Timestamp",
+		URL:                  receiveURL + "?sender=%2B2349067554729&text=Join",
+		Data:                 "empty",
+		Headers:              map[string]string{timestampHeader: "1523470000"},
+		PrepRequest:          addValidSignature,
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "request timestamp outside of allowed window",
+	},
+	{
+		Label:                "Status Missing Signature",
+		URL:                  "/c/ex/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/delivered/?id=12345",
+		Data:                 "empty",
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "missing request signature",
+	},
+}
+
+func addValidSignature(r *http.Request) {
+	sig, _ := signature.Sign("sesame", r.Header.Get(timestampHeader), r)
+	r.Header.Set(signatureHeader, sig)
+}
+
 func TestIncoming(t *testing.T) {
+	dates.SetNowFunc(dates.NewFixedNow(time.Date(2018, 4, 11, 18, 24, 30, 0, time.UTC)))
+	defer dates.SetNowFunc(time.Now)
+
 	RunIncomingTestCases(t, testChannels, newHandler(), handleTestCases)
 	RunIncomingTestCases(t, testSOAPReceiveChannels, newHandler(), handleSOAPReceiveTestCases)
 	RunIncomingTestCases(t, gmChannels, newHandler(), gmTestCases)
 	RunIncomingTestCases(t, customChannels, newHandler(), customTestCases)
 
 	RunIncomingTestCases(t, extChannels, newHandler(), extReceiveTestCases)
+	RunIncomingTestCases(t, signedChannels, newHandler(), signedTestCases)
 }
 
 func BenchmarkHandler(b *testing.B) {
@@ -1027,3 +1108,10 @@ func TestOutgoing(t *testing.T) {
 		})
 	RunOutgoingTestCases(t, jsonChannelWithSendAuthorization, newHandler(), jsonSendTestCases, []string{"Token ABCDEF"}, nil)
 }
+
+func TestConfigSchema(t *testing.T) {
+	schema := newHandler().(courier.ConfigSchemaDeclarer).ConfigSchema()
+
+	assert.Equal(t, []*courier.ConfigError{}, schema.Validate(map[string]any{courier.ConfigSendURL: "http://example.com/send?to={{to}}&text={{text}}"}))
+	assert.Equal(t, []*courier.ConfigError{{Key: "send_url", Message: "must be a valid url"}}, schema.Validate(map[string]any{courier.ConfigSendURL: "example.com/send"}))
+}
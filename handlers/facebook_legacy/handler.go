@@ -398,7 +398,10 @@ func (h *handler) receiveEvents(ctx context.Context, channel courier.Channel, w
 			}
 
 			err := h.Backend().WriteMsg(ctx, event, clog)
-			if err != nil {
+			if errors.Is(err, courier.ErrMsgIgnored) {
+				data = append(data, courier.NewInfoData(err.Error()))
+				continue
+			} else if err != nil {
 				return nil, err
 			}
 
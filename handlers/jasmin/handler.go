@@ -71,6 +71,7 @@ type moForm struct {
 	From    string `name:"from"     validate:"required"`
 	To      string `name:"to"       validate:"required"`
 	ID      string `name:"id"       validate:"required"`
+	UDH     string `name:"udh"`
 }
 
 // receiveMessage is our HTTP handler function for incoming messages
@@ -97,6 +98,11 @@ func (h *handler) receiveMessage(ctx context.Context, c courier.Channel, w http.
 	// build our msg
 	msg := h.Backend().NewIncomingMsg(c, urn, text, form.ID, clog).WithReceivedOn(time.Now().UTC())
 
+	// if jasmin isn't reassembling long messages, each part includes a UDH which tells us how to reassemble them
+	if segment := handlers.ParseConcatUDHParam(form.UDH); segment != nil {
+		msg.WithSegment(segment)
+	}
+
 	// and finally queue our message
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
 }
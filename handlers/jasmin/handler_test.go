@@ -32,6 +32,17 @@ var handleTestCases = []IncomingTestCase{
 		ExpectedURN:          "tel:+2349067554729",
 		ExpectedExternalID:   "1001",
 	},
+	{
+		Label:                "Receive Message Part",
+		URL:                  receiveURL,
+		Data:                 "content=Join&coding=0&From=2349067554729&To=2349067554711&id=1002&udh=0500032A0302",
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "ACK/Jasmin",
+		ExpectedMsgText:      Sp("Join"),
+		ExpectedURN:          "tel:+2349067554729",
+		ExpectedExternalID:   "1002",
+		ExpectedSegment:      &courier.MsgSegment{Ref: "42", Seq: 2, Total: 3},
+	},
 	{
 		Label:                "Receive Missing To",
 		URL:                  receiveURL,
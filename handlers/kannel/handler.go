@@ -54,6 +54,7 @@ type moForm struct {
 	TS      int64  `validate:"required" name:"ts"`
 	Message string `name:"message"`
 	Sender  string `validate:"required" name:"sender"`
+	UDH     string `name:"udh"`
 }
 
 // receiveMessage is our HTTP handler function for incoming messages
@@ -77,6 +78,11 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	// build our msg
 	msg := h.Backend().NewIncomingMsg(channel, urn, form.Message, form.ID, clog).WithReceivedOn(date)
 
+	// if kannel isn't reassembling long messages, each part includes a UDH which tells us how to reassemble them
+	if segment := handlers.ParseConcatUDHParam(form.UDH); segment != nil {
+		msg.WithSegment(segment)
+	}
+
 	// and finally write our message
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
 }
@@ -43,6 +43,18 @@ var handleTestCases = []IncomingTestCase{
 		ExpectedExternalID:   "asdf-asdf",
 		ExpectedDate:         time.Date(2017, 5, 2, 14, 31, 49, 0, time.UTC),
 	},
+	{
+		Label:                "Receive Message Part",
+		URL:                  "/c/kn/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/?backend=NIG_MTN&sender=%2B2349067554729&message=Join&ts=1493735509&id=asdf-asdf&to=24453&udh=%05%00%03%2A%03%02",
+		Data:                 "empty",
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedMsgText:      Sp("Join"),
+		ExpectedURN:          "tel:+2349067554729",
+		ExpectedExternalID:   "asdf-asdf",
+		ExpectedSegment:      &courier.MsgSegment{Ref: "42", Seq: 2, Total: 3},
+		ExpectedDate:         time.Date(2017, 5, 2, 14, 31, 49, 0, time.UTC),
+	},
 	{
 		Label:                "Receive Empty Message",
 		URL:                  "/c/kn/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/?backend=NIG_MTN&sender=%2B2349067554729&message=&ts=1493735509&id=asdf-asdf&to=24453",
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/i18n"
+)
+
+// how long we wait for a keyword confirmation reply to be sent
+const replyTimeout = time.Minute
+
+// keywords which stop or start a contact on every channel which handles keywords
+var (
+	defaultStopKeywords  = []string{"STOP", "STOPALL", "UNSUBSCRIBE", "CANCEL", "END", "QUIT", "OPTOUT"}
+	defaultStartKeywords = []string{"START", "UNSTOP", "SUBSCRIBE"}
+)
+
+// additional keywords for channels in countries where other languages are spoken
+var (
+	localizedStopKeywords = map[i18n.Language][]string{
+		"fra": {"ARRET", "ARRÊT", "DESABONNER", "DÉSABONNER"},
+		"spa": {"ALTO", "BAJA", "PARAR", "DETENER"},
+		"por": {"PARAR", "SAIR", "CANCELAR"},
+	}
+	localizedStartKeywords = map[i18n.Language][]string{
+		"fra": {"DEMARRER", "DÉMARRER"},
+		"spa": {"ALTA", "INICIAR"},
+		"por": {"INICIAR", "VOLTAR"},
+	}
+	countryLanguages = map[i18n.Country]i18n.Language{
+		"BE": "fra", "BF": "fra", "BI": "fra", "BJ": "fra", "CD": "fra", "CF": "fra", "CG": "fra", "CI": "fra", "CM": "fra",
+		"FR": "fra", "GA": "fra", "GN": "fra", "HT": "fra", "MG": "fra", "ML": "fra", "NE": "fra", "SN": "fra", "TD": "fra",
+		"TG": "fra",
+		"AR": "spa", "BO": "spa", "CL": "spa", "CO": "spa", "CR": "spa", "CU": "spa", "DO": "spa", "EC": "spa", "ES": "spa",
+		"GQ": "spa", "GT": "spa", "HN": "spa", "MX": "spa", "NI": "spa", "PA": "spa", "PE": "spa", "PY": "spa", "SV": "spa",
+		"UY": "spa", "VE": "spa",
+		"AO": "por", "BR": "por", "CV": "por", "GW": "por", "MZ": "por", "PT": "por", "ST": "por",
+	}
+)
+
+// OptKeywordEvent returns the type of channel event that an incoming message with the given text should be converted to
+// because it's a stop or start keyword on the given channel, or empty if it isn't. Channels only handle keywords if they
+// have the opt_keywords config setting or their own lists of keywords.
+func OptKeywordEvent(ch courier.Channel, text string) courier.ChannelEventType {
+	stopKeywords := parseKeywordsConfig(ch.ConfigForKey(courier.ConfigStopKeywords, nil))
+	startKeywords := parseKeywordsConfig(ch.ConfigForKey(courier.ConfigStartKeywords, nil))
+
+	if !ch.BoolConfigForKey(courier.ConfigOptKeywords, false) && stopKeywords == nil && startKeywords == nil {
+		return ""
+	}
+
+	lang := countryLanguages[ch.Country()]
+	if stopKeywords == nil {
+		stopKeywords = slices.Concat(defaultStopKeywords, localizedStopKeywords[lang])
+	}
+	if startKeywords == nil {
+		startKeywords = slices.Concat(defaultStartKeywords, localizedStartKeywords[lang])
+	}
+
+	keyword := normalizeKeyword(text)
+	if slices.Contains(stopKeywords, keyword) {
+		return courier.EventTypeStopContact
+	} else if slices.Contains(startKeywords, keyword) {
+		return courier.EventTypeOptIn
+	}
+	return ""
+}
+
+// writes a channel event of the given type in place of the given incoming message, and sends the channel's confirmation
+// reply if it has one in the background so that the provider isn't kept waiting for our response
+func writeOptKeywordEvent(ctx context.Context, h courier.ChannelHandler, msg courier.MsgIn, eventType courier.ChannelEventType, clog *courier.ChannelLog) (courier.ChannelEvent, error) {
+	ch := msg.Channel()
+	event := h.Server().Backend().NewChannelEvent(ch, eventType, msg.URN(), clog)
+	if msg.ReceivedOn() != nil {
+		event.WithOccurredOn(*msg.ReceivedOn())
+	}
+
+	if err := h.Server().Backend().WriteChannelEvent(ctx, event, clog); err != nil {
+		return nil, err
+	}
+
+	replyKey := courier.ConfigStopReply
+	if eventType == courier.EventTypeOptIn {
+		replyKey = courier.ConfigStartReply
+	}
+
+	if reply := ch.StringConfigForKey(replyKey, ""); reply != "" {
+		wg := h.Server().WaitGroup()
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+			defer cancel()
+
+			courier.SendReply(ctx, h, ch, msg.URN(), reply)
+		}()
+	}
+
+	return event, nil
+}
+
+// normalizes the text of an incoming message for matching against keywords by upper casing it and removing surrounding
+// whitespace and punctuation, e.g. " stop! " becomes "STOP"
+func normalizeKeyword(text string) string {
+	return strings.ToUpper(strings.TrimFunc(text, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsPunct(r) }))
+}
+
+// parses a keywords config value which can be a comma separated string or a list of strings, returning nil if it's not set
+func parseKeywordsConfig(v any) []string {
+	var raw []string
+
+	switch typed := v.(type) {
+	case string:
+		raw = strings.Split(typed, ",")
+	case []string:
+		raw = typed
+	case []any:
+		for _, k := range typed {
+			if s, ok := k.(string); ok {
+				raw = append(raw, s)
+			}
+		}
+	default:
+		return nil
+	}
+
+	keywords := make([]string, 0, len(raw))
+	for _, k := range raw {
+		if k = normalizeKeyword(k); k != "" {
+			keywords = append(keywords, k)
+		}
+	}
+	return keywords
+}
@@ -0,0 +1,51 @@
+package handlers_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptKeywordEvent(t *testing.T) {
+	newChannel := func(country i18n.Country, config map[string]any) courier.Channel {
+		return test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "NX", "1234", country, []string{urns.Phone.Prefix}, config)
+	}
+
+	disabled := newChannel("US", map[string]any{})
+	english := newChannel("US", map[string]any{courier.ConfigOptKeywords: true})
+	french := newChannel("SN", map[string]any{courier.ConfigOptKeywords: true})
+	custom := newChannel("US", map[string]any{courier.ConfigStopKeywords: "halt, Enough", courier.ConfigStartKeywords: []any{"go"}})
+
+	tcs := []struct {
+		channel  courier.Channel
+		text     string
+		expected courier.ChannelEventType
+	}{
+		{disabled, "STOP", ""},
+		{english, "STOP", courier.EventTypeStopContact},
+		{english, " stop. ", courier.EventTypeStopContact},
+		{english, "Unsubscribe", courier.EventTypeStopContact},
+		{english, "START", courier.EventTypeOptIn},
+		{english, "stop please", ""},
+		{english, "ARRET", ""},
+		{english, "", ""},
+		{french, "STOP", courier.EventTypeStopContact},
+		{french, "arrêt", courier.EventTypeStopContact},
+		{french, "Démarrer", courier.EventTypeOptIn},
+		{french, "ALTO", ""},
+		{custom, "HALT", courier.EventTypeStopContact},
+		{custom, "enough!", courier.EventTypeStopContact},
+		{custom, "Go", courier.EventTypeOptIn},
+		{custom, "STOP", ""},
+		{custom, "START", ""},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.expected, handlers.OptKeywordEvent(tc.channel, tc.text), "event type mismatch for '%s' on %s channel", tc.text, tc.channel.Country())
+	}
+}
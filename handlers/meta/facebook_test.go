@@ -664,10 +664,13 @@ func TestSigning(t *testing.T) {
 		},
 	}
 
+	signature := newHandler("FBA", "Facebook").(*handler).signature()
+
 	for i, tc := range tcs {
-		sig, err := fbCalculateSignature("sesame", []byte(tc.Body))
+		r, _ := http.NewRequest(http.MethodPost, "https://example.com/c/fba/receive", strings.NewReader(tc.Body))
+		sig, err := signature.Sign("sesame", "", r)
 		assert.NoError(t, err)
-		assert.Equal(t, tc.Signature, sig, "%d: mismatched signature", i)
+		assert.Equal(t, "sha256="+tc.Signature, sig, "%d: mismatched signature", i)
 	}
 }
 
@@ -725,9 +728,8 @@ func createMockGraphAPI() *httptest.Server {
 }
 
 func addValidSignature(r *http.Request) {
-	body, _ := ReadBody(r, maxRequestBodyBytes)
-	sig, _ := fbCalculateSignature("fb_app_secret", body)
-	r.Header.Set(signatureHeader, fmt.Sprintf("sha256=%s", string(sig)))
+	sig, _ := newHandler("FBA", "Facebook").(*handler).signature().Sign("fb_app_secret", "", r)
+	r.Header.Set(signatureHeader, sig)
 }
 
 func addInvalidSignature(r *http.Request) {
@@ -3,14 +3,13 @@ package meta
 import (
 	"bytes"
 	"context"
-	"crypto/hmac"
 	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"regexp"
 	"slices"
 	"strconv"
 	"strings"
@@ -34,8 +33,6 @@ var (
 
 	signatureHeader = "X-Hub-Signature-256"
 
-	maxRequestBodyBytes int64 = 1024 * 1024
-
 	// max for the body
 	maxMsgLength = 1000
 
@@ -54,6 +51,12 @@ var (
 	}
 
 	wacThrottlingErrorCodes = []int{4, 80007, 130429, 131048, 131056, 133016}
+
+	// patterns matching user identifiers in WhatsApp, Facebook and Instagram payloads
+	piiPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`"(?:wa_id|from|to|recipient_id)":\s*"(\d+)"`),
+		regexp.MustCompile(`"(?:sender|recipient)":\s*\{\s*"(?:id|user_ref)":\s*"([^"]+)"`),
+	}
 )
 
 // keys for extra in channel events
@@ -85,7 +88,7 @@ type handler struct {
 func (h *handler) Initialize(s courier.Server) error {
 	h.SetServer(s)
 	s.AddHandlerRoute(h, http.MethodGet, "receive", courier.ChannelLogTypeWebhookVerify, h.receiveVerify)
-	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeMultiReceive, handlers.JSONPayload(h, h.receiveEvents))
+	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeMultiReceive, handlers.Verified(h, h.signature(), handlers.JSONPayload(h, h.receiveEvents)))
 	return nil
 }
 
@@ -133,6 +136,11 @@ func (h *handler) RedactValues(ch courier.Channel) []string {
 	return vals
 }
 
+// RedactPatterns returns the patterns of PII to redact from logs of channels that require it
+func (h *handler) RedactPatterns(ch courier.Channel) []*regexp.Regexp {
+	return piiPatterns
+}
+
 // WriteRequestError writes the passed in error to our response writer
 func (h *handler) WriteRequestError(ctx context.Context, w http.ResponseWriter, err error) error {
 	return courier.WriteError(w, http.StatusOK, err)
@@ -182,6 +190,12 @@ func (h *handler) GetChannel(ctx context.Context, r *http.Request) (courier.Chan
 	}
 }
 
+// ReceiveAsync returns whether the given request can be acknowledged immediately and processed in the background, which
+// is the case for event notifications with a valid signature since Meta expects a response within a few seconds
+func (h *handler) ReceiveAsync(ctx context.Context, channel courier.Channel, r *http.Request) bool {
+	return r.Method == http.MethodPost && h.signature().Verify(channel, r) == nil
+}
+
 // receiveVerify handles Facebook's webhook verification callback
 func (h *handler) receiveVerify(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
 	mode := r.URL.Query().Get("hub.mode")
@@ -234,11 +248,6 @@ func (h *handler) resolveMediaURL(mediaID string, token string, clog *courier.Ch
 
 // receiveEvents is our HTTP handler function for incoming messages and status updates
 func (h *handler) receiveEvents(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, payload *Notifications, clog *courier.ChannelLog) ([]courier.Event, error) {
-	err := h.validateSignature(r)
-	if err != nil {
-		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
-	}
-
 	// is not a 'page' and 'instagram' object? ignore it
 	if payload.Object != "page" && payload.Object != "instagram" && payload.Object != "whatsapp_business_account" {
 		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "ignoring request")
@@ -251,17 +260,14 @@ func (h *handler) receiveEvents(ctx context.Context, channel courier.Channel, w
 
 	var events []courier.Event
 	var data []any
+	var err error
 
 	if channel.ChannelType() == "FBA" || channel.ChannelType() == "IG" {
 		events, data, err = h.processFacebookInstagramPayload(ctx, channel, payload, w, r, clog)
 	} else {
 		events, data, err = h.processWhatsAppPayload(ctx, channel, payload, w, r, clog)
-		webhook := channel.ConfigForKey("webhook", nil)
-		if webhook != nil {
-			er := handlers.SendWebhooks(channel, r, webhook, clog)
-			if er != nil {
-				courier.LogRequestError(r, channel, fmt.Errorf("could not send webhook: %s", er))
-			}
+		if err := handlers.ForwardToWebhook(h.Backend(), channel, r); err != nil {
+			courier.LogRequestError(r, channel, fmt.Errorf("could not forward to webhook: %w", err))
 		}
 	}
 
@@ -376,7 +382,10 @@ func (h *handler) processWhatsAppPayload(ctx context.Context, channel courier.Ch
 				}
 
 				err = h.Backend().WriteMsg(ctx, event, clog)
-				if err != nil {
+				if errors.Is(err, courier.ErrMsgIgnored) {
+					data = append(data, courier.NewInfoData(err.Error()))
+					continue
+				} else if err != nil {
 					return nil, nil, err
 				}
 
@@ -630,7 +639,10 @@ func (h *handler) processFacebookInstagramPayload(ctx context.Context, channel c
 			}
 
 			err := h.Backend().WriteMsg(ctx, event, clog)
-			if err != nil {
+			if errors.Is(err, courier.ErrMsgIgnored) {
+				data = append(data, courier.NewInfoData(err.Error()))
+				continue
+			} else if err != nil {
 				return nil, nil, err
 			}
 
@@ -666,7 +678,10 @@ func (h *handler) processFacebookInstagramPayload(ctx context.Context, channel c
 			event := h.Backend().NewIncomingMsg(channel, urn, text, msg.Message.MID, clog).WithReceivedOn(date)
 
 			err := h.Backend().WriteMsg(ctx, event, clog)
-			if err != nil {
+			if errors.Is(err, courier.ErrMsgIgnored) {
+				data = append(data, courier.NewInfoData(err.Error()))
+				continue
+			} else if err != nil {
 				return nil, nil, err
 			}
 
@@ -1331,52 +1346,22 @@ func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn
 }
 
 // see https://developers.facebook.com/docs/messenger-platform/webhook#security
-func (h *handler) validateSignature(r *http.Request) error {
-	headerSignature := r.Header.Get(signatureHeader)
-	if headerSignature == "" {
-		return fmt.Errorf("missing request signature")
+func (h *handler) signature() *handlers.HMACSignature {
+	return &handlers.HMACSignature{
+		Header:  signatureHeader,
+		Prefix:  "sha256=",
+		Hash:    sha256.New,
+		Content: handlers.SignedBody,
+		Secret:  h.appSecret,
 	}
-
-	var appSecret string
-
-	if fmt.Sprint(h.ChannelType()) == "FBA" || fmt.Sprint(h.ChannelType()) == "IG" {
-		appSecret = h.Server().Config().FacebookApplicationSecret
-	} else {
-		appSecret = h.Server().Config().WhatsappCloudApplicationSecret
-	}
-
-	body, err := handlers.ReadBody(r, maxRequestBodyBytes)
-	if err != nil {
-		return fmt.Errorf("unable to read request body: %s", err)
-	}
-
-	expectedSignature, err := fbCalculateSignature(appSecret, body)
-	if err != nil {
-		return err
-	}
-
-	signature := ""
-	if len(headerSignature) == 71 && strings.HasPrefix(headerSignature, "sha256=") {
-		signature = strings.TrimPrefix(headerSignature, "sha256=")
-	}
-
-	// compare signatures in way that isn't sensitive to a timing attack
-	if !hmac.Equal([]byte(expectedSignature), []byte(signature)) {
-		return fmt.Errorf("invalid request signature, expected: %s got: %s for body: '%s'", expectedSignature, signature, string(body))
-	}
-
-	return nil
 }
 
-func fbCalculateSignature(appSecret string, body []byte) (string, error) {
-	var buffer bytes.Buffer
-	buffer.Write(body)
-
-	// hash with SHA1
-	mac := hmac.New(sha256.New, []byte(appSecret))
-	mac.Write(buffer.Bytes())
-
-	return hex.EncodeToString(mac.Sum(nil)), nil
+// requests are signed with the app secret which is shared by all channels of the same type
+func (h *handler) appSecret(courier.Channel) string {
+	if h.ChannelType() == "FBA" || h.ChannelType() == "IG" {
+		return h.Server().Config().FacebookApplicationSecret
+	}
+	return h.Server().Config().WhatsappCloudApplicationSecret
 }
 
 // BuildAttachmentRequest to download media for message attachment with Bearer token set
@@ -790,3 +790,14 @@ func newServerWithWAC(backend courier.Backend) courier.Server {
 	config.WhatsappAdminSystemUserToken = "wac_admin_system_user_token"
 	return courier.NewServer(config, backend)
 }
+
+func TestWhatsAppRedactPII(t *testing.T) {
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "", []string{urns.WhatsApp.Prefix}, map[string]any{courier.ConfigRedactPII: true})
+
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, ch, nil)
+	clog.Error(clogs.NewLogError("", "", `{"contacts": [{"wa_id": "5678"}], "messages": [{"from": "5678", "id": "external_id"}]}`))
+	clog.Error(clogs.NewLogError("", "", `{"recipient": {"id": "12345"}, "sender": {"user_ref": "abc"}}`))
+
+	assert.Equal(t, `{"contacts": [{"wa_id": "**********"}], "messages": [{"from": "**********", "id": "external_id"}]}`, clog.Errors[0].Message)
+	assert.Equal(t, `{"recipient": {"id": "**********"}, "sender": {"user_ref": "**********"}}`, clog.Errors[1].Message)
+}
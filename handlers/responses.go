@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
 
 	"github.com/nyaruka/courier"
@@ -9,16 +10,51 @@ import (
 
 // WriteMsgsAndResponse writes the passed in message to our backend
 func WriteMsgsAndResponse(ctx context.Context, h courier.ChannelHandler, msgs []courier.MsgIn, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
-	events := make([]courier.Event, len(msgs))
-	for i, m := range msgs {
+	events := make([]courier.Event, 0, len(msgs))
+	written := make([]courier.MsgIn, 0, len(msgs))
+	var ignored error
+
+	for _, m := range msgs {
+		// messages which are stop or start keywords on this channel are written as channel events instead, but only if
+		// they pass the same dedup check as other messages, with inbound limits checked when the event is written
+		if eventType := OptKeywordEvent(m.Channel(), m.Text()); eventType != "" && len(m.Attachments()) == 0 {
+			err := h.Server().Backend().AcceptMsg(ctx, m, clog)
+			if errors.Is(err, courier.ErrMsgIgnored) {
+				ignored = err
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			event, err := writeOptKeywordEvent(ctx, h, m, eventType, clog)
+			if errors.Is(err, courier.ErrMsgIgnored) {
+				ignored = err
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+			written = append(written, m)
+			continue
+		}
+
 		err := h.Server().Backend().WriteMsg(ctx, m, clog)
-		if err != nil {
+		if errors.Is(err, courier.ErrMsgIgnored) {
+			ignored = err
+			continue
+		} else if err != nil {
 			return nil, err
 		}
-		events[i] = m
+		events = append(events, m)
+		written = append(written, m)
+	}
+
+	// if all our messages were ignored, acknowledge the request without any events
+	if len(written) == 0 && ignored != nil {
+		return nil, WriteAndLogRequestIgnored(ctx, h, msgs[0].Channel(), w, r, ignored.Error())
 	}
 
-	return events, h.WriteMsgSuccessResponse(ctx, w, msgs)
+	return events, h.WriteMsgSuccessResponse(ctx, w, written)
 }
 
 // WriteMsgStatusAndResponse write the passed in status to our backend
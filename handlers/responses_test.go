@@ -0,0 +1,140 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+type testHandler struct {
+	handlers.BaseHandler
+}
+
+func (h *testHandler) Initialize(s courier.Server) error { return nil }
+
+func (h *testHandler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
+	return nil
+}
+
+func TestWriteMsgsAndResponse(t *testing.T) {
+	ctx := context.Background()
+	mb := test.NewMockBackend()
+	mc := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "NX", "1234", "EC", []string{urns.Phone.Prefix}, nil)
+
+	h := &testHandler{handlers.NewBaseHandler("NX", "Test")}
+	h.SetServer(test.NewMockServer(courier.NewDefaultConfig(), mb))
+
+	mb.BlockURN("tel:+12065550000")
+
+	r, _ := http.NewRequest("POST", "https://example.com/c/nx/receive", nil)
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, mc, nil)
+
+	// all messages from a blocked URN are ignored
+	msg1 := mb.NewIncomingMsg(mc, "tel:+12065550000", "hello", "", clog)
+
+	w := httptest.NewRecorder()
+	events, err := handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg1}, w, r, clog)
+	assert.NoError(t, err)
+	assert.Len(t, events, 0)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "Ignored")
+	assert.Contains(t, w.Body.String(), "URN is blocked on this channel")
+	assert.Len(t, mb.WrittenMsgs(), 0)
+
+	// messages from other URNs are still written
+	msg2 := mb.NewIncomingMsg(mc, "tel:+12065550001", "hi", "", clog)
+
+	w = httptest.NewRecorder()
+	events, err = handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg1, msg2}, w, r, clog)
+	assert.NoError(t, err)
+	assert.Equal(t, []courier.Event{msg2}, events)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "Message Accepted")
+	assert.Equal(t, []courier.MsgIn{msg2}, mb.WrittenMsgs())
+}
+
+func TestWriteMsgsAndResponseWithKeywords(t *testing.T) {
+	ctx := context.Background()
+	mb := test.NewMockBackend()
+	mc := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "NX", "1234", "FR", []string{urns.Phone.Prefix}, map[string]any{
+		courier.ConfigOptKeywords: true,
+		courier.ConfigStopReply:   "You have been unsubscribed",
+	})
+
+	h := &testHandler{handlers.NewBaseHandler("NX", "Test")}
+	h.SetServer(test.NewMockServer(courier.NewDefaultConfig(), mb))
+
+	r, _ := http.NewRequest("POST", "https://example.com/c/nx/receive", nil)
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, mc, nil)
+
+	// a stop keyword is written as a channel event and the channel's reply is sent in the background
+	msg := mb.NewIncomingMsg(mc, "tel:+12065550000", " Arrêt! ", "EX123", clog)
+
+	w := httptest.NewRecorder()
+	events, err := handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+	assert.NoError(t, err)
+	h.Server().WaitGroup().Wait()
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, mb.WrittenMsgs(), 0)
+	if assert.Len(t, mb.WrittenChannelEvents(), 1) {
+		assert.Equal(t, courier.EventTypeStopContact, mb.WrittenChannelEvents()[0].EventType())
+		assert.Equal(t, urns.URN("tel:+12065550000"), mb.WrittenChannelEvents()[0].URN())
+		assert.Equal(t, []courier.Event{mb.WrittenChannelEvents()[0]}, events)
+	}
+	if assert.Len(t, mb.WrittenChannelLogs(), 1) {
+		assert.Equal(t, courier.ChannelLogTypeMsgSend, mb.WrittenChannelLogs()[0].Type)
+	}
+
+	// if the provider resends the keyword, it's ignored as a duplicate and there's no second reply
+	msg = mb.NewIncomingMsg(mc, "tel:+12065550000", " Arrêt! ", "EX123", clog)
+
+	w = httptest.NewRecorder()
+	events, err = handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+	assert.NoError(t, err)
+	h.Server().WaitGroup().Wait()
+	assert.Len(t, events, 0)
+	assert.Contains(t, w.Body.String(), "message is a duplicate")
+	assert.Len(t, mb.WrittenChannelEvents(), 1)
+	assert.Len(t, mb.WrittenChannelLogs(), 1)
+
+	// and keywords from blocked URNs are ignored like any other message
+	mb.BlockURN("tel:+12065550001")
+	msg = mb.NewIncomingMsg(mc, "tel:+12065550001", "stop", "", clog)
+
+	w = httptest.NewRecorder()
+	events, err = handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+	assert.NoError(t, err)
+	h.Server().WaitGroup().Wait()
+	assert.Len(t, events, 0)
+	assert.Contains(t, w.Body.String(), "URN is blocked on this channel")
+	assert.Len(t, mb.WrittenChannelEvents(), 1)
+	assert.Len(t, mb.WrittenChannelLogs(), 1)
+
+	// a start keyword is written as an opt-in event, but without a reply as channel doesn't have one
+	mb.Reset()
+	msg = mb.NewIncomingMsg(mc, "tel:+12065550000", "start", "", clog)
+
+	_, err = handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, httptest.NewRecorder(), r, clog)
+	assert.NoError(t, err)
+	h.Server().WaitGroup().Wait()
+	if assert.Len(t, mb.WrittenChannelEvents(), 1) {
+		assert.Equal(t, courier.EventTypeOptIn, mb.WrittenChannelEvents()[0].EventType())
+	}
+	assert.Len(t, mb.WrittenChannelLogs(), 0)
+
+	// other messages are written as normal
+	mb.Reset()
+	msg = mb.NewIncomingMsg(mc, "tel:+12065550000", "stop that", "", clog)
+
+	_, err = handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, httptest.NewRecorder(), r, clog)
+	assert.NoError(t, err)
+	assert.Len(t, mb.WrittenMsgs(), 1)
+	assert.Len(t, mb.WrittenChannelEvents(), 0)
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/dates"
+)
+
+const (
+	maxSignedBodyBytes int64 = 1024 * 1024
+
+	defaultTimestampFormat = "%s.%s"
+	defaultTimestampMaxAge = 5 * time.Minute
+)
+
+var (
+	errMissingSignature = errors.New("missing request signature")
+	errInvalidSignature = errors.New("invalid request signature")
+)
+
+// SignatureVerifier is the interface for something which verifies that an incoming request was sent by the provider of
+// a channel, typically by checking a signature header
+type SignatureVerifier interface {
+	Verify(courier.Channel, *http.Request) error
+}
+
+// Verified wraps the given handler func so that only requests which pass the given verifier are passed to it, e.g.
+//
+//	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeMsgReceive, handlers.Verified(h, signature, h.receiveMessage))
+func Verified(h courier.ChannelHandler, v SignatureVerifier, handlerFunc courier.ChannelHandleFunc) courier.ChannelHandleFunc {
+	return func(ctx context.Context, c courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+		if err := v.Verify(c, r); err != nil {
+			return nil, WriteAndLogRequestError(ctx, h, c, w, r, err)
+		}
+
+		return handlerFunc(ctx, c, w, r, clog)
+	}
+}
+
+// SecretFunc returns the secret used to verify requests for the given channel, which may be nil for handlers which
+// don't route by channel UUID
+type SecretFunc func(courier.Channel) string
+
+// ChannelConfigSecret returns a secret func which reads the secret from the given channel config key
+func ChannelConfigSecret(key string) SecretFunc {
+	return func(c courier.Channel) string {
+		if c == nil {
+			return ""
+		}
+		return c.StringConfigForKey(key, "")
+	}
+}
+
+// SignatureEncoding is how a computed signature is encoded in a header
+type SignatureEncoding int
+
+const (
+	SignatureHex SignatureEncoding = iota
+	SignatureBase64
+)
+
+// SignedContentFunc returns the content of a request which is signed, given its body
+type SignedContentFunc func(*http.Request, []byte) ([]byte, error)
+
+// SignedBody is signed content which is just the request body
+func SignedBody(r *http.Request, body []byte) ([]byte, error) {
+	return body, nil
+}
+
+// SignedURLAndBody is signed content which is the full https URL of the request followed by its body
+func SignedURLAndBody(r *http.Request, body []byte) ([]byte, error) {
+	return append([]byte(fmt.Sprintf("https://%s%s", r.Host, r.URL.RequestURI())), body...), nil
+}
+
+// SignedURLAndForm returns signed content which is the full https URL of the request followed by its form parameters
+// and their values, sorted by key. If the given header is set on a request, it's used as the path of a request which has
+// been forwarded by a proxy.
+func SignedURLAndForm(proxyPathHeader string) SignedContentFunc {
+	return func(r *http.Request, body []byte) ([]byte, error) {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+
+		path := r.URL.RequestURI()
+		if proxyPathHeader != "" && r.Header.Get(proxyPathHeader) != "" {
+			path = r.Header.Get(proxyPathHeader)
+		}
+
+		var buffer bytes.Buffer
+		buffer.WriteString(fmt.Sprintf("https://%s%s", r.Host, path))
+
+		keys := make([]string, 0, len(r.PostForm))
+		for k := range r.PostForm {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			buffer.WriteString(k)
+			for _, v := range r.PostForm[k] {
+				buffer.WriteString(v)
+			}
+		}
+
+		return buffer.Bytes(), nil
+	}
+}
+
+// HMACSignature verifies requests which have a header containing an HMAC of some content of the request
+type HMACSignature struct {
+	Header   string            // the header containing the signature
+	Prefix   string            // the prefix of the signature in the header, e.g. sha256=
+	Hash     func() hash.Hash  // the hash function used, e.g. sha256.New
+	Encoding SignatureEncoding // how the signature is encoded
+	Content  SignedContentFunc // the content of the request which is signed
+	Secret   SecretFunc        // the secret used as the HMAC key
+	Optional bool              // whether requests are allowed for channels without a secret
+
+	TimestampHeader string        // the header containing a unix timestamp which is signed with the content, if any
+	TimestampFormat string        // how the timestamp and content are combined for signing, defaults to "%s.%s"
+	MaxAge          time.Duration // how old a timestamp can be, defaults to 5 minutes
+}
+
+// Verify verifies the signature of the given request
+func (s *HMACSignature) Verify(c courier.Channel, r *http.Request) error {
+	secret := s.Secret(c)
+	if secret == "" {
+		if s.Optional {
+			return nil
+		}
+		return errors.New("missing secret to verify request signature")
+	}
+
+	actual := r.Header.Get(s.Header)
+	if actual == "" {
+		return errMissingSignature
+	}
+
+	var timestamp string
+	if s.TimestampHeader != "" {
+		timestamp = r.Header.Get(s.TimestampHeader)
+
+		// requests being reprocessed, e.g. replayed from our archive, can be older than our max age
+		if !courier.IsReprocessing(r.Context()) {
+			if err := s.checkTimestamp(timestamp); err != nil {
+				return err
+			}
+		}
+	}
+
+	expected, err := s.Sign(secret, timestamp, r)
+	if err != nil {
+		return err
+	}
+
+	// compare signatures in way that isn't sensitive to a timing attack
+	if !hmac.Equal([]byte(expected), []byte(actual)) {
+		return errInvalidSignature
+	}
+
+	return nil
+}
+
+// Sign calculates the signature for the given request using the given secret and timestamp, as it would appear in the
+// signature header
+func (s *HMACSignature) Sign(secret, timestamp string, r *http.Request) (string, error) {
+	body, err := ReadBody(r, maxSignedBodyBytes)
+	if err != nil {
+		return "", fmt.Errorf("unable to read request body: %w", err)
+	}
+
+	content, err := s.Content(r, body)
+	if err != nil {
+		return "", err
+	}
+
+	// reset body again in case it was read to get the content
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if s.TimestampHeader != "" {
+		format := s.TimestampFormat
+		if format == "" {
+			format = defaultTimestampFormat
+		}
+		content = []byte(fmt.Sprintf(format, timestamp, content))
+	}
+
+	mac := hmac.New(s.Hash, []byte(secret))
+	mac.Write(content)
+	sum := mac.Sum(nil)
+
+	if s.Encoding == SignatureBase64 {
+		return s.Prefix + base64.StdEncoding.EncodeToString(sum), nil
+	}
+	return s.Prefix + hex.EncodeToString(sum), nil
+}
+
+func (s *HMACSignature) checkTimestamp(timestamp string) error {
+	if timestamp == "" {
+		return errors.New("missing request timestamp")
+	}
+
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid request timestamp: %s", timestamp)
+	}
+
+	maxAge := s.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultTimestampMaxAge
+	}
+
+	age := dates.Now().Sub(time.Unix(secs, 0))
+	if age > maxAge || age < -maxAge {
+		return errors.New("request timestamp outside of allowed window")
+	}
+
+	return nil
+}
+
+// SharedSecretHeader verifies requests which have a header containing a secret shared with the provider
+type SharedSecretHeader struct {
+	Header   string     // the header containing the secret
+	Secret   SecretFunc // the expected secret
+	Optional bool       // whether requests are allowed for channels without a secret
+}
+
+// Verify verifies the secret header of the given request
+func (s *SharedSecretHeader) Verify(c courier.Channel, r *http.Request) error {
+	secret := s.Secret(c)
+	if secret == "" {
+		if s.Optional {
+			return nil
+		}
+		return errors.New("missing secret to verify request")
+	}
+
+	actual := r.Header.Get(s.Header)
+	if actual == "" {
+		return errMissingSignature
+	}
+
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(actual)) != 1 {
+		return errInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package handlers_test
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHMACSignature(t *testing.T) {
+	dates.SetNowFunc(dates.NewFixedNow(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+	defer dates.SetNowFunc(time.Now)
+
+	withSecret := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "NX", "1234", "EC", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigSecret: "sesame"})
+	withoutSecret := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "NX", "1234", "EC", []string{urns.Phone.Prefix}, map[string]any{})
+
+	newRequest := func(body string, headers map[string]string) *http.Request {
+		r, _ := http.NewRequest("POST", "https://example.com/c/nx/receive?foo=bar", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		return r
+	}
+
+	// signature of the body
+	s := &handlers.HMACSignature{Header: "X-Signature", Prefix: "sha256=", Hash: sha256.New, Content: handlers.SignedBody, Secret: handlers.ChannelConfigSecret(courier.ConfigSecret)}
+
+	sig, err := s.Sign("sesame", "", newRequest("hello world", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256=f39034b29165ec6a5104d9aef27266484ab26c8caa7bca8bcb2dd02e8be61b17", sig)
+
+	r := newRequest("hello world", map[string]string{"X-Signature": sig})
+	assert.NoError(t, s.Verify(withSecret, r))
+	assert.EqualError(t, s.Verify(withSecret, newRequest("hello world", nil)), "missing request signature")
+	assert.EqualError(t, s.Verify(withSecret, newRequest("hello world2", map[string]string{"X-Signature": sig})), "invalid request signature")
+	assert.EqualError(t, s.Verify(withoutSecret, newRequest("hello world", nil)), "missing secret to verify request signature")
+
+	// body can still be read after verification
+	body, _ := handlers.ReadBody(r, 1000)
+	assert.Equal(t, "hello world", string(body))
+
+	// unless verification is optional
+	s.Optional = true
+	assert.NoError(t, s.Verify(withoutSecret, newRequest("hello world", nil)))
+
+	// base64 signature of URL and sorted form values
+	s = &handlers.HMACSignature{Header: "X-Signature", Hash: sha1.New, Encoding: handlers.SignatureBase64, Content: handlers.SignedURLAndForm("X-Forwarded-Path"), Secret: handlers.ChannelConfigSecret(courier.ConfigSecret)}
+
+	sig1, err := s.Sign("sesame", "", newRequest("b=2&a=1", nil))
+	assert.NoError(t, err)
+	sig2, err := s.Sign("sesame", "", newRequest("a=1&b=2", nil))
+	assert.NoError(t, err)
+	sig3, err := s.Sign("sesame", "", newRequest("a=1&b=2", map[string]string{"X-Forwarded-Path": "/proxied"}))
+	assert.NoError(t, err)
+	assert.Equal(t, sig1, sig2)
+	assert.NotEqual(t, sig1, sig3)
+
+	assert.NoError(t, s.Verify(withSecret, newRequest("a=1&b=2", map[string]string{"X-Signature": sig1})))
+	assert.NoError(t, s.Verify(withSecret, newRequest("a=1&b=2", map[string]string{"X-Signature": sig3, "X-Forwarded-Path": "/proxied"})))
+
+	// timestamped signature
+	s = &handlers.HMACSignature{Header: "X-Signature", Hash: sha256.New, Content: handlers.SignedURLAndBody, Secret: handlers.ChannelConfigSecret(courier.ConfigSecret), TimestampHeader: "X-Timestamp", MaxAge: time.Minute}
+
+	now := "1704164645"
+	sig, err = s.Sign("sesame", now, newRequest("hello", nil))
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.Verify(withSecret, newRequest("hello", map[string]string{"X-Signature": sig, "X-Timestamp": now})))
+	assert.EqualError(t, s.Verify(withSecret, newRequest("hello", map[string]string{"X-Signature": sig})), "missing request timestamp")
+	assert.EqualError(t, s.Verify(withSecret, newRequest("hello", map[string]string{"X-Signature": sig, "X-Timestamp": "xyz"})), "invalid request timestamp: xyz")
+	assert.EqualError(t, s.Verify(withSecret, newRequest("hello", map[string]string{"X-Signature": sig, "X-Timestamp": "1704164646"})), "invalid request signature")
+
+	old := "1704164500"
+	sig, _ = s.Sign("sesame", old, newRequest("hello", nil))
+	assert.EqualError(t, s.Verify(withSecret, newRequest("hello", map[string]string{"X-Signature": sig, "X-Timestamp": old})), "request timestamp outside of allowed window")
+
+	// unless the request is being reprocessed, e.g. replayed from our archive
+	r = newRequest("hello", map[string]string{"X-Signature": sig, "X-Timestamp": old})
+	assert.NoError(t, s.Verify(withSecret, r.WithContext(courier.WithReprocessing(r.Context()))))
+}
+
+func TestSharedSecretHeader(t *testing.T) {
+	withSecret := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "NX", "1234", "EC", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigSecret: "sesame"})
+	withoutSecret := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "NX", "1234", "EC", []string{urns.Phone.Prefix}, map[string]any{})
+
+	newRequest := func(secret string) *http.Request {
+		r, _ := http.NewRequest("POST", "https://example.com/c/nx/receive", nil)
+		if secret != "" {
+			r.Header.Set("X-Secret", secret)
+		}
+		return r
+	}
+
+	s := &handlers.SharedSecretHeader{Header: "X-Secret", Secret: handlers.ChannelConfigSecret(courier.ConfigSecret)}
+
+	assert.NoError(t, s.Verify(withSecret, newRequest("sesame")))
+	assert.EqualError(t, s.Verify(withSecret, newRequest("")), "missing request signature")
+	assert.EqualError(t, s.Verify(withSecret, newRequest("open")), "invalid request signature")
+	assert.EqualError(t, s.Verify(withoutSecret, newRequest("sesame")), "missing secret to verify request")
+
+	s.Optional = true
+	assert.NoError(t, s.Verify(withoutSecret, newRequest("")))
+}
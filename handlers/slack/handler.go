@@ -3,6 +3,7 @@ package slack
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -26,8 +27,26 @@ const (
 	configBotToken        = "bot_token"
 	configUserToken       = "user_token"
 	configValidationToken = "verification_token"
+	configSigningSecret   = "signing_secret"
+
+	signatureHeader = "X-Slack-Signature"
+	timestampHeader = "X-Slack-Request-Timestamp"
+
+	maxAsyncBodyBytes = 1024 * 1024
 )
 
+// channels with a signing secret require requests to be signed, see https://api.slack.com/authentication/verifying-requests-from-slack
+var signature = &handlers.HMACSignature{
+	Header:          signatureHeader,
+	Prefix:          "v0=",
+	Hash:            sha256.New,
+	Content:         handlers.SignedBody,
+	Secret:          handlers.ChannelConfigSecret(configSigningSecret),
+	Optional:        true,
+	TimestampHeader: timestampHeader,
+	TimestampFormat: "v0:%s:%s",
+}
+
 var (
 	ErrAlreadyPublic         = "already_public"
 	ErrPublicVideoNotAllowed = "public_video_not_allowed"
@@ -42,15 +61,33 @@ type handler struct {
 }
 
 func newHandler() courier.ChannelHandler {
-	return &handler{handlers.NewBaseHandler(courier.ChannelType("SL"), "Slack", handlers.WithRedactConfigKeys(configBotToken, configUserToken, configValidationToken))}
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("SL"), "Slack", handlers.WithRedactConfigKeys(configBotToken, configUserToken, configValidationToken, configSigningSecret))}
 }
 
 func (h *handler) Initialize(s courier.Server) error {
 	h.SetServer(s)
-	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeUnknown, handlers.JSONPayload(h, h.receiveEvent))
+	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeUnknown, handlers.Verified(h, signature, handlers.JSONPayload(h, h.receiveEvent)))
 	return nil
 }
 
+// ReceiveAsync returns whether the given request can be acknowledged immediately and processed in the background, which
+// is the case for signed event callbacks since Slack retries events which aren't acknowledged within 3 seconds and
+// resolving files can take longer than that. URL verifications need the challenge in their response so are never
+// received asynchronously.
+func (h *handler) ReceiveAsync(ctx context.Context, channel courier.Channel, r *http.Request) bool {
+	if channel.StringConfigForKey(configSigningSecret, "") == "" || signature.Verify(channel, r) != nil {
+		return false
+	}
+
+	body, err := handlers.ReadBody(r, maxAsyncBodyBytes)
+	if err != nil {
+		return false
+	}
+
+	eventType, _ := jsonparser.GetString(body, "type")
+	return eventType == "event_callback"
+}
+
 func handleURLVerification(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, payload *moPayload) ([]courier.Event, error) {
 	validationToken := channel.ConfigForKey(configValidationToken, "")
 	if validationToken != payload.Token {
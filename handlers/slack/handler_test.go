@@ -7,13 +7,16 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/test"
 	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/stretchr/testify/assert"
@@ -245,6 +248,81 @@ func TestIncoming(t *testing.T) {
 	RunIncomingTestCases(t, testChannels, newHandler(), handleTestCases)
 }
 
+func TestSignedIncoming(t *testing.T) {
+	dates.SetNowFunc(dates.NewFixedNow(time.Date(2018, 4, 11, 18, 24, 30, 0, time.UTC)))
+	defer dates.SetNowFunc(time.Now)
+
+	signedChannels := []courier.Channel{
+		test.NewMockChannel(channelUUID, "SL", "2022", "US", []string{urns.Slack.Prefix}, map[string]any{"bot_token": "xoxb-abc123", "verification_token": "one-long-verification-token", "signing_secret": "sesame"}),
+	}
+
+	addValidSignature := func(r *http.Request) {
+		sig, _ := signature.Sign("sesame", r.Header.Get(timestampHeader), r)
+		r.Header.Set(signatureHeader, sig)
+	}
+
+	RunIncomingTestCases(t, signedChannels, newHandler(), []IncomingTestCase{
+		{
+			Label:                "Receive Signed Hello Msg",
+			URL:                  receiveURL,
+			Headers:              map[string]string{"content-type": "text/plain", timestampHeader: "1523471070"},
+			Data:                 helloMsg,
+			PrepRequest:          addValidSignature,
+			ExpectedURN:          "slack:U0123ABCDEF",
+			ExpectedMsgText:      Sp("Hello World!"),
+			ExpectedRespStatus:   200,
+			ExpectedBodyContains: "Accepted",
+		},
+		{
+			Label:                "Receive Invalid Signature",
+			URL:                  receiveURL,
+			Headers:              map[string]string{"content-type": "text/plain", timestampHeader: "1523471070", signatureHeader: "v0=1234"},
+			Data:                 helloMsg,
+			ExpectedRespStatus:   400,
+			ExpectedBodyContains: "invalid request signature",
+		},
+		{
+			Label:                "Receive Missing Timestamp",
+			URL:                  receiveURL,
+			Headers:              map[string]string{"content-type": "text/plain", signatureHeader: "v0=1234"},
+			Data:                 helloMsg,
+			ExpectedRespStatus:   400,
+			ExpectedBodyContains: "missing request timestamp",
+		},
+	})
+}
+
+func TestReceiveAsync(t *testing.T) {
+	dates.SetNowFunc(dates.NewFixedNow(time.Date(2018, 4, 11, 18, 24, 30, 0, time.UTC)))
+	defer dates.SetNowFunc(time.Now)
+
+	signedChannel := test.NewMockChannel(channelUUID, "SL", "2022", "US", []string{urns.Slack.Prefix}, map[string]any{"bot_token": "xoxb-abc123", "verification_token": "one-long-verification-token", "signing_secret": "sesame"})
+	h := newHandler().(*handler)
+
+	newRequest := func(body string, sign bool) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, receiveURL, strings.NewReader(body))
+		r.Header.Set(timestampHeader, "1523471070")
+		if sign {
+			sig, _ := signature.Sign("sesame", "1523471070", r)
+			r.Header.Set(signatureHeader, sig)
+		}
+		return r
+	}
+
+	// signed event callbacks are received asynchronously and their body can still be read
+	r := newRequest(helloMsg, true)
+	assert.True(t, h.ReceiveAsync(context.Background(), signedChannel, r))
+	body, _ := io.ReadAll(r.Body)
+	assert.Equal(t, helloMsg, string(body))
+
+	// but not if they aren't signed correctly, or the channel can't verify them
+	assert.False(t, h.ReceiveAsync(context.Background(), signedChannel, newRequest(helloMsg, false)))
+	assert.False(t, h.ReceiveAsync(context.Background(), testChannels[0], newRequest(helloMsg, true)))
+
+	// URL verifications need the challenge in their response
+	assert.False(t, h.ReceiveAsync(context.Background(), signedChannel, newRequest(`{"token": "one-long-verification-token", "challenge": "abc", "type": "url_verification"}`, true)))
+}
+
 func TestOutgoing(t *testing.T) {
 	RunOutgoingTestCases(t, testChannels[0], newHandler(), defaultSendTestCases, []string{"xoxb-abc123", "one-long-verification-token"}, nil)
 }
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
@@ -37,9 +38,16 @@ func (h *handler) Initialize(s courier.Server) error {
 	return nil
 }
 
+// DedupPolicy returns how incoming messages are deduplicated. They don't have IDs and resent messages can arrive minutes
+// after the original, so we dedup by content over a longer window than the default.
+func (h *handler) DedupPolicy() *courier.DedupPolicy {
+	return &courier.DedupPolicy{Strategy: courier.DedupContent, ContentWindow: 5 * time.Minute}
+}
+
 type moForm struct {
 	Message string `name:"message"`
 	Mobile  string `name:"mobile" validate:"required" `
+	UDH     string `name:"udh"`
 }
 
 // receiveMessage is our HTTP handler function for incoming messages
@@ -58,6 +66,12 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 
 	// build our msg
 	msg := h.Backend().NewIncomingMsg(channel, urn, form.Message, "", clog)
+
+	// parts of long messages include a UDH which tells us how to reassemble them
+	if segment := handlers.ParseConcatUDHParam(form.UDH); segment != nil {
+		msg.WithSegment(segment)
+	}
+
 	// and finally write our message
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
 }
@@ -3,12 +3,14 @@ package smscentral
 import (
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/test"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
 )
 
 const (
@@ -31,6 +33,16 @@ var handleTestCases = []IncomingTestCase{
 		ExpectedMsgText:      Sp("Join"),
 		ExpectedURN:          "tel:+2349067554729",
 	},
+	{
+		Label:                "Receive Message Part",
+		URL:                  receiveURL,
+		Data:                 "mobile=%2B2349067554729&message=Join&udh=%05%00%03%2A%03%02",
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedMsgText:      Sp("Join"),
+		ExpectedURN:          "tel:+2349067554729",
+		ExpectedSegment:      &courier.MsgSegment{Ref: "42", Seq: 2, Total: 3},
+	},
 	{
 		Label:                "Receive No Message",
 		URL:                  receiveURL,
@@ -131,6 +143,11 @@ var defaultSendTestCases = []OutgoingTestCase{
 	},
 }
 
+func TestDedupPolicy(t *testing.T) {
+	policy := courier.GetDedupPolicy(testChannels[0], &courier.DedupPolicy{Strategy: courier.DedupAuto, ContentWindow: 2 * time.Second, ExternalIDWindow: 24 * time.Hour})
+	assert.Equal(t, &courier.DedupPolicy{Strategy: courier.DedupContent, ContentWindow: 5 * time.Minute, ExternalIDWindow: 24 * time.Hour}, policy)
+}
+
 func TestOutgoing(t *testing.T) {
 	var defaultChannel = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "SC", "2020", "US",
 		[]string{urns.Phone.Prefix},
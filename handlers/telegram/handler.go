@@ -21,6 +21,13 @@ import (
 
 var apiURL = "https://api.telegram.org"
 
+// patterns matching user identifiers in update payloads and send requests
+var piiPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`"(?:from|chat)":\s*\{\s*"id":\s*(-?\d+)`),
+	regexp.MustCompile(`"username":\s*"([^"]+)"`),
+	regexp.MustCompile(`chat_id=(-?\d+)`),
+}
+
 // see https://core.telegram.org/bots/api#sending-files
 var mediaSupport = map[handlers.MediaType]handlers.MediaTypeSupport{
 	handlers.MediaTypeImage:       {MaxBytes: 10 * 1024 * 1024},
@@ -41,6 +48,18 @@ func newHandler() courier.ChannelHandler {
 	return &handler{handlers.NewBaseHandler(courier.ChannelType("TG"), "Telegram")}
 }
 
+// RedactPatterns returns the patterns of PII to redact from logs of channels that require it
+func (h *handler) RedactPatterns(ch courier.Channel) []*regexp.Regexp {
+	return piiPatterns
+}
+
+// ConfigSchema returns the config keys used by Telegram channels
+func (h *handler) ConfigSchema() courier.ConfigSchema {
+	return courier.ConfigSchema{
+		{Key: courier.ConfigAuthToken, Type: courier.ConfigTypeString, Required: true},
+	}
+}
+
 // Initialize is called by the engine once everything is loaded
 func (h *handler) Initialize(s courier.Server) error {
 	h.SetServer(s)
@@ -138,7 +157,10 @@ type mtResponse struct {
 	Ok          bool   `json:"ok" validate:"required"`
 	ErrorCode   int    `json:"error_code"`
 	Description string `json:"description"`
-	Result      struct {
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+	Result struct {
 		MessageID int64 `json:"message_id"`
 	} `json:"result"`
 }
@@ -170,6 +192,8 @@ func (h *handler) sendMsgPart(msg courier.MsgOut, token, path string, form url.V
 	if err != nil || resp.StatusCode/100 != 2 || !response.Ok {
 		if response.ErrorCode == 403 && response.Description == "Forbidden: bot was blocked by the user" {
 			return "", courier.ErrContactStopped
+		} else if response.ErrorCode == 429 && response.Parameters.RetryAfter > 0 {
+			return "", courier.ErrRetryAfter(time.Duration(response.Parameters.RetryAfter) * time.Second)
 		} else if response.ErrorCode > 0 {
 			return "", courier.ErrFailedWithReason(strconv.Itoa(response.ErrorCode), response.Description)
 		}
@@ -874,6 +874,20 @@ var outgoingCases = []OutgoingTestCase{
 		},
 		ExpectedError: courier.ErrContactStopped,
 	},
+	{
+		Label:   "Rate Limited",
+		MsgText: "Simple Message",
+		MsgURN:  "telegram:12345",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendMessage": {
+				httpx.NewMockResponse(429, nil, []byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 35","parameters":{"retry_after":35}}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"text": {"Simple Message"}, "chat_id": {"12345"}, "parse_mode": []string{"Markdown"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+		},
+		ExpectedError: courier.ErrRetryAfter(35 * time.Second),
+	},
 	{
 		Label:          "Send Photo",
 		MsgText:        "My pic!",
@@ -960,3 +974,16 @@ func TestEscapeMarkdown(t *testing.T) {
 	expected := `This is a string with\_underscores and words\_without, - so one _ outside _now now_ and _now_ https://meusite.com/do\_checkout i know_ *BOLD* not\*bold \[secret\] is cold $!@#`
 	assert.Equal(t, result, expected)
 }
+
+func TestRedactPII(t *testing.T) {
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "TG", "2020", "US", []string{urns.Telegram.Prefix}, map[string]any{courier.ConfigRedactPII: true})
+
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, ch, nil)
+	clog.Error(clogs.NewLogError("", "", helloMsg))
+	clog.Error(clogs.NewLogError("", "", "chat_id=3527065&text=Hello"))
+
+	assert.NotContains(t, clog.Errors[0].Message, "3527065")
+	assert.NotContains(t, clog.Errors[0].Message, "nicpottier")
+	assert.Contains(t, clog.Errors[0].Message, `"username": "**********"`)
+	assert.Equal(t, "chat_id=**********&text=Hello", clog.Errors[1].Message)
+}
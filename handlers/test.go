@@ -66,6 +66,7 @@ type IncomingTestCase struct {
 	ExpectedAttachments   []string
 	ExpectedDate          time.Time
 	ExpectedExternalID    string
+	ExpectedSegment       *courier.MsgSegment
 	ExpectedMsgID         int64
 	ExpectedStatuses      []ExpectedStatus
 	ExpectedEvents        []ExpectedEvent
@@ -184,6 +185,7 @@ func RunIncomingTestCases(t *testing.T, channels []courier.Channel, handler cour
 				if tc.ExpectedExternalID != "" {
 					assert.Equal(t, tc.ExpectedExternalID, msg.ExternalID())
 				}
+				assert.Equal(t, tc.ExpectedSegment, msg.Segment())
 				assert.Equal(t, tc.ExpectedURN, msg.URN())
 			} else {
 				assert.Empty(t, mb.WrittenMsgs(), "unexpected msg written")
@@ -5,17 +5,13 @@ package twiml
  */
 
 import (
-	"bytes"
 	"context"
-	"crypto/hmac"
 	"crypto/sha1"
 	_ "embed"
-	"encoding/base64"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
-	"sort"
 	"strconv"
 	"strings"
 
@@ -57,6 +53,15 @@ var mediaSupport = map[handlers.MediaType]handlers.MediaTypeSupport{
 	handlers.MediaTypeApplication: {MaxBytes: 5 * 1024 * 1024},
 }
 
+// see https://www.twilio.com/docs/api/security
+var signature = &handlers.HMACSignature{
+	Header:   signatureHeader,
+	Hash:     sha1.New,
+	Encoding: handlers.SignatureBase64,
+	Content:  handlers.SignedURLAndForm(forwardedPathHeader),
+	Secret:   handlers.ChannelConfigSecret(courier.ConfigAuthToken),
+}
+
 // error code twilio returns when a contact has sent "stop"
 const errorStopped = 21610
 const errorThrottled = 63018
@@ -81,7 +86,13 @@ func init() {
 // Initialize is called by the engine once everything is loaded
 func (h *handler) Initialize(s courier.Server) error {
 	h.SetServer(s)
-	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeMsgReceive, h.receiveMessage)
+
+	receiveMessage := h.receiveMessage
+	if h.validateSignatures {
+		receiveMessage = handlers.Verified(h, signature, receiveMessage)
+	}
+
+	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeMsgReceive, receiveMessage)
 	s.AddHandlerRoute(h, http.MethodPost, "status", courier.ChannelLogTypeMsgStatus, h.receiveStatus)
 	return nil
 }
@@ -116,14 +127,9 @@ var statusMapping = map[string]courier.MsgStatus{
 
 // receiveMessage is our HTTP handler function for incoming messages
 func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
-	err := h.validateSignature(channel, r)
-	if err != nil {
-		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
-	}
-
 	// get our params
 	form := &moForm{}
-	err = handlers.DecodeAndValidateForm(form, r)
+	err := handlers.DecodeAndValidateForm(form, r)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
@@ -156,14 +162,15 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 
 // receiveStatus is our HTTP handler function for status updates
 func (h *handler) receiveStatus(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
-	err := h.validateSignature(channel, r)
-	if err != nil {
-		return nil, err
+	if h.validateSignatures {
+		if err := signature.Verify(channel, r); err != nil {
+			return nil, err
+		}
 	}
 
 	// get our params
 	form := &statusForm{}
-	err = handlers.DecodeAndValidateForm(form, r)
+	err := handlers.DecodeAndValidateForm(form, r)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "no msg status, ignoring")
 	}
@@ -471,77 +478,6 @@ func (h *handler) baseURL(c courier.Channel) string {
 	return c.StringConfigForKey(configSendURL, c.StringConfigForKey(configBaseURL, ""))
 }
 
-// see https://www.twilio.com/docs/api/security
-func (h *handler) validateSignature(c courier.Channel, r *http.Request) error {
-	if !h.validateSignatures {
-		return nil
-	}
-
-	actual := r.Header.Get(signatureHeader)
-	if actual == "" {
-		return fmt.Errorf("missing request signature")
-	}
-
-	if err := r.ParseForm(); err != nil {
-		return err
-	}
-
-	confAuth := c.ConfigForKey(courier.ConfigAuthToken, "")
-	authToken, isStr := confAuth.(string)
-	if !isStr || authToken == "" {
-		return fmt.Errorf("invalid or missing auth token in config")
-	}
-
-	path := r.URL.RequestURI()
-	proxyPath := r.Header.Get(forwardedPathHeader)
-	if proxyPath != "" {
-		path = proxyPath
-	}
-
-	url := fmt.Sprintf("https://%s%s", r.Host, path)
-	expected, err := twCalculateSignature(url, r.PostForm, authToken)
-	if err != nil {
-		return err
-	}
-
-	// compare signatures in way that isn't sensitive to a timing attack
-	if !hmac.Equal(expected, []byte(actual)) {
-		return fmt.Errorf("invalid request signature")
-	}
-
-	return nil
-}
-
-// see https://www.twilio.com/docs/api/security
-func twCalculateSignature(url string, form url.Values, authToken string) ([]byte, error) {
-	var buffer bytes.Buffer
-	buffer.WriteString(url)
-
-	keys := make(sort.StringSlice, 0, len(form))
-	for k := range form {
-		keys = append(keys, k)
-	}
-	keys.Sort()
-
-	for _, k := range keys {
-		buffer.WriteString(k)
-		for _, v := range form[k] {
-			buffer.WriteString(v)
-		}
-	}
-
-	// hash with SHA1
-	mac := hmac.New(sha1.New, []byte(authToken))
-	mac.Write(buffer.Bytes())
-	hash := mac.Sum(nil)
-
-	// encode with Base64
-	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(hash)))
-	base64.StdEncoding.Encode(encoded, hash)
-
-	return encoded, nil
-}
-
 // WriteMsgSuccessResponse writes our response in TWIML format
 func (h *handler) WriteMsgSuccessResponse(ctx context.Context, w http.ResponseWriter, msgs []courier.MsgIn) error {
 	w.Header().Set("Content-Type", "text/xml")
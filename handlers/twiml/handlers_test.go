@@ -4,10 +4,9 @@ import (
 	"context"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 
-	"fmt"
-
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/test"
@@ -171,6 +170,14 @@ var testCases = []IncomingTestCase{
 		},
 		PrepRequest: addValidSignature,
 	},
+	{
+		Label:                "Status Invalid Signature",
+		URL:                  statusURL,
+		Data:                 statusValid,
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "invalid request signature",
+		PrepRequest:          addInvalidSignature,
+	},
 	{
 		Label:                "Status Read",
 		URL:                  statusURL,
@@ -509,22 +516,51 @@ var twaTestCases = []IncomingTestCase{
 }
 
 func addValidSignature(r *http.Request) {
-	r.ParseForm()
-	sig, _ := twCalculateSignature(fmt.Sprintf("%s://%s%s", r.URL.Scheme, r.Host, r.URL.RequestURI()), r.PostForm, "6789")
-	r.Header.Set(signatureHeader, string(sig))
+	sig, _ := signature.Sign("6789", "", r)
+	r.Header.Set(signatureHeader, sig)
 }
 
 func addForwardSignature(r *http.Request) {
-	r.ParseForm()
-	path := r.Header.Get(forwardedPathHeader)
-	sig, _ := twCalculateSignature(fmt.Sprintf("%s://%s%s", r.URL.Scheme, r.Host, path), r.PostForm, "6789")
-	r.Header.Set(signatureHeader, string(sig))
+	sig, _ := signature.Sign("6789", "", r)
+	r.Header.Set(signatureHeader, sig)
 }
 
 func addInvalidSignature(r *http.Request) {
 	r.Header.Set(signatureHeader, "invalidsig")
 }
 
+func TestSignature(t *testing.T) {
+	channel := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "T", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{"auth_token": "12345"})
+
+	newRequest := func() *http.Request {
+		form := url.Values{"CallSid": {"CA1234567890ABCDE"}, "Caller": {"+12349013030"}, "Digits": {"1234"}, "From": {"+12349013030"}, "To": {"+18005551212"}}
+		r, _ := http.NewRequest(http.MethodPost, "https://mycompany.com/myapp.php?foo=1&bar=2", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	// example from https://www.twilio.com/docs/usage/security
+	sig, err := signature.Sign("12345", "", newRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, "0/KCTR6DLpKmkAf8muzZqo1nDgQ=", sig)
+
+	r := newRequest()
+	r.Header.Set(signatureHeader, "0/KCTR6DLpKmkAf8muzZqo1nDgQ=")
+	assert.NoError(t, signature.Verify(channel, r))
+
+	r = newRequest()
+	r.Header.Set(signatureHeader, "RSOYDt4T1cUTdK1PDd93/VVr8B8=")
+	assert.EqualError(t, signature.Verify(channel, r), "invalid request signature")
+
+	// a proxy can tell us the original path which was signed
+	r, _ = http.NewRequest(http.MethodPost, "https://mycompany.com/internal/path", strings.NewReader("Digits=1234"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set(forwardedPathHeader, "/myapp.php?foo=1&bar=2")
+	sig, err = signature.Sign("12345", "", r)
+	assert.NoError(t, err)
+	assert.Equal(t, "pA9T74XaVTq8oX3FDhv0CTa4HE4=", sig)
+}
+
 func TestIncoming(t *testing.T) {
 	RunIncomingTestCases(t, testChannels, newTWIMLHandler("T", "Twilio", true), testCases)
 	RunIncomingTestCases(t, tmsTestChannels, newTWIMLHandler("TMS", "Twilio Messaging Service", true), tmsTestCases)
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"strconv"
+
+	"github.com/nyaruka/courier"
+)
+
+// information element identifiers for concatenated SMS, see 3GPP TS 23.040 9.2.3.24
+const (
+	ieiConcat8Bit  = 0x00
+	ieiConcat16Bit = 0x08
+)
+
+// ParseConcatUDH parses the given user data header of an incoming SMS and returns the segment it identifies, or nil if
+// the header doesn't contain a valid concatenation element
+func ParseConcatUDH(udh []byte) *courier.MsgSegment {
+	if len(udh) < 1 || int(udh[0]) > len(udh)-1 {
+		return nil
+	}
+
+	// walk the information elements until we find a concatenation one
+	elements := udh[1 : int(udh[0])+1]
+	for len(elements) >= 2 {
+		iei, length := elements[0], int(elements[1])
+		if length > len(elements)-2 {
+			return nil
+		}
+		data := elements[2 : 2+length]
+		elements = elements[2+length:]
+
+		var ref, total, seq int
+		if iei == ieiConcat8Bit && length == 3 {
+			ref, total, seq = int(data[0]), int(data[1]), int(data[2])
+		} else if iei == ieiConcat16Bit && length == 4 {
+			ref, total, seq = int(data[0])<<8|int(data[1]), int(data[2]), int(data[3])
+		} else {
+			continue
+		}
+
+		if total < 1 || seq < 1 || seq > total {
+			return nil
+		}
+		return &courier.MsgSegment{Ref: strconv.Itoa(ref), Seq: seq, Total: total}
+	}
+
+	return nil
+}
+
+// ParseConcatUDHParam parses a user data header passed to us as a request parameter, which can be hex encoded or the
+// raw bytes, and returns the segment it identifies, or nil if it doesn't contain a valid concatenation element
+func ParseConcatUDHParam(param string) *courier.MsgSegment {
+	// raw headers are never valid hex because their first byte is their length, which can't be a hex digit
+	if udh, err := hex.DecodeString(param); err == nil {
+		return ParseConcatUDH(udh)
+	}
+	return ParseConcatUDH([]byte(param))
+}
@@ -0,0 +1,40 @@
+package handlers_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConcatUDH(t *testing.T) {
+	tcs := []struct {
+		udh      []byte
+		expected *courier.MsgSegment
+	}{
+		{nil, nil},
+		{[]byte{}, nil},
+		{[]byte{0x05, 0x00, 0x03, 0x2A, 0x03, 0x01}, &courier.MsgSegment{Ref: "42", Seq: 1, Total: 3}},
+		{[]byte{0x05, 0x00, 0x03, 0x2A, 0x03, 0x03}, &courier.MsgSegment{Ref: "42", Seq: 3, Total: 3}},
+		{[]byte{0x06, 0x08, 0x04, 0x01, 0x02, 0x02, 0x01}, &courier.MsgSegment{Ref: "258", Seq: 1, Total: 2}},
+		{[]byte{0x09, 0x04, 0x02, 0x11, 0x22, 0x00, 0x03, 0x07, 0x02, 0x02}, &courier.MsgSegment{Ref: "7", Seq: 2, Total: 2}},
+		{[]byte{0x04, 0x04, 0x02, 0x11, 0x22}, nil},             // no concatenation element
+		{[]byte{0x05, 0x00, 0x03, 0x2A, 0x03, 0x04}, nil},       // seq greater than total
+		{[]byte{0x05, 0x00, 0x03, 0x2A, 0x00, 0x00}, nil},       // zero total
+		{[]byte{0x05, 0x00, 0x03, 0x2A}, nil},                   // truncated
+		{[]byte{0x05, 0x00, 0x05, 0x2A, 0x03, 0x01, 0x00}, nil}, // element longer than header
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.expected, handlers.ParseConcatUDH(tc.udh), "segment mismatch for UDH %x", tc.udh)
+	}
+}
+
+func TestParseConcatUDHParam(t *testing.T) {
+	assert.Nil(t, handlers.ParseConcatUDHParam(""))
+	assert.Equal(t, &courier.MsgSegment{Ref: "42", Seq: 2, Total: 3}, handlers.ParseConcatUDHParam("0500032A0302"))
+	assert.Equal(t, &courier.MsgSegment{Ref: "42", Seq: 2, Total: 3}, handlers.ParseConcatUDHParam("0500032a0302"))
+	assert.Equal(t, &courier.MsgSegment{Ref: "42", Seq: 2, Total: 3}, handlers.ParseConcatUDHParam("\x05\x00\x03\x2A\x03\x02"))
+	assert.Nil(t, handlers.ParseConcatUDHParam("0400032A03"))
+}
@@ -251,7 +251,12 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, errors.New("no text or attachment"))
 	}
 	// save message to our backend
-	if err := h.Backend().WriteMsg(ctx, msg, clog); err != nil {
+	if err := h.Backend().WriteMsg(ctx, msg, clog); errors.Is(err, courier.ErrMsgIgnored) {
+		// still acknowledge the request so that it isn't retried
+		courier.LogRequestIgnored(r, channel, err.Error())
+		_, err = fmt.Fprint(w, responseIncomingMessage)
+		return nil, err
+	} else if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
 	// write required response
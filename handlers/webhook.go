@@ -3,48 +3,31 @@ package handlers
 import (
 	"fmt"
 	"net/http"
-	"net/url"
 
 	"github.com/nyaruka/courier"
-	"github.com/nyaruka/gocommon/httpx"
-	"github.com/pkg/errors"
+	"github.com/nyaruka/courier/webhooks"
 )
 
-func SendWebhooks(channel courier.Channel, r *http.Request, configWebhook interface{}, clog *courier.ChannelLog) error {
-	webhook, ok := configWebhook.(map[string]interface{})
-	if !ok {
-		return fmt.Errorf("conversion error")
+// ForwardToWebhook queues the body of the given request to be forwarded to the channel's webhook, if it has one.
+// Delivery is asynchronous with retries, and each attempt is recorded as a channel log.
+func ForwardToWebhook(b courier.Backend, channel courier.Channel, r *http.Request) error {
+	raw := channel.ConfigForKey(courier.ConfigForwardWebhook, nil)
+	if raw == nil {
+		return nil
 	}
 
-	// check if url is valid
-	_, err := url.ParseRequestURI(webhook["url"].(string))
-	if err != nil {
+	// check the config is valid but it's looked up again when the request is forwarded
+	if _, err := webhooks.ReadConfig(raw); err != nil {
 		return err
 	}
-	u, err := url.Parse(webhook["url"].(string))
-	if err != nil || u.Scheme == "" || u.Host == "" {
-		return fmt.Errorf("invalid url %s", err)
-	}
-
-	method := webhook["method"].(string)
-	if method == "" {
-		method = "POST"
-	}
 
-	req, _ := http.NewRequest(method, webhook["url"].(string), r.Body)
-
-	headers := webhook["headers"].(map[string]interface{})
-	for name, value := range headers {
-		req.Header.Set(name, value.(string))
+	body, err := ReadBody(r, maxBodyReadBytes)
+	if err != nil {
+		return fmt.Errorf("unable to read request body: %w", err)
 	}
 
-	trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 1024)
-	if trace != nil {
-		clog.HTTP(trace)
-	}
-	if err != nil || trace.Response.StatusCode/100 != 2 {
-		return errors.Wrap(err, "status other than 200")
-	}
+	rc := b.RedisPool().Get()
+	defer rc.Close()
 
-	return nil
+	return webhooks.Queue(rc, webhooks.NewJob(channel.UUID(), webhooks.KindForward, r.Header.Get("Content-Type"), body))
 }
@@ -0,0 +1,69 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/webhooks"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardToWebhook(t *testing.T) {
+	mb := test.NewMockBackend()
+
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	queued := func() []*webhooks.Job {
+		raw, err := redis.Strings(rc.Do("ZRANGE", "webhooks:pending", 0, -1))
+		require.NoError(t, err)
+
+		jobs := make([]*webhooks.Job, len(raw))
+		for i := range raw {
+			jobs[i] = &webhooks.Job{}
+			require.NoError(t, json.Unmarshal([]byte(raw[i]), jobs[i]))
+		}
+		return jobs
+	}
+
+	// channel without a webhook is a noop
+	mc := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "WA", "1234", "EC", []string{urns.WhatsApp.Prefix}, nil)
+	r := httptest.NewRequest("POST", "/c/wa/receive", strings.NewReader(`{"foo":"bar"}`))
+	assert.NoError(t, handlers.ForwardToWebhook(mb, mc, r))
+	assert.Len(t, queued(), 0)
+
+	// channel with an invalid webhook config errors rather than panicking
+	mc = test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "WA", "1234", "EC", []string{urns.WhatsApp.Prefix}, map[string]any{
+		"webhook": map[string]any{"method": "POST", "headers": 123},
+	})
+	assert.Error(t, handlers.ForwardToWebhook(mb, mc, r))
+	assert.Len(t, queued(), 0)
+
+	// channel with a valid webhook config has the request body queued for delivery, but not the config itself
+	mc = test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "WA", "1234", "EC", []string{urns.WhatsApp.Prefix}, map[string]any{
+		"webhook": map[string]any{"url": "https://example.com/forward", "headers": map[string]any{"Authorization": "Token 123"}, "secret": "sesame"},
+	})
+	r = httptest.NewRequest("POST", "/c/wa/receive", strings.NewReader(`{"foo":"bar"}`))
+	r.Header.Set("Content-Type", "application/json")
+	assert.NoError(t, handlers.ForwardToWebhook(mb, mc, r))
+
+	jobs := queued()
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, mc.UUID(), jobs[0].ChannelUUID)
+		assert.Equal(t, webhooks.KindForward, jobs[0].Kind)
+		assert.Equal(t, "application/json", jobs[0].ContentType)
+		assert.Equal(t, `{"foo":"bar"}`, jobs[0].Body)
+	}
+
+	raw, err := redis.Strings(rc.Do("ZRANGE", "webhooks:pending", 0, -1))
+	require.NoError(t, err)
+	assert.NotContains(t, raw[0], "sesame")
+	assert.NotContains(t, raw[0], "Token 123")
+}
@@ -36,6 +36,12 @@ func (h *handler) Initialize(s courier.Server) error {
 	return nil
 }
 
+// DedupPolicy returns how incoming messages are deduplicated. They don't have IDs and users often send the same text
+// several times in quick succession, e.g. "yes", so they're never treated as duplicates.
+func (h *handler) DedupPolicy() *courier.DedupPolicy {
+	return &courier.DedupPolicy{Strategy: courier.DedupNone}
+}
+
 type miPayload struct {
 	Type    string    `json:"type"           validate:"required"`
 	From    string    `json:"from,omitempty" validate:"required"`
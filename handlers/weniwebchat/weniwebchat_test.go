@@ -5,12 +5,14 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/test"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
 )
 
 const channelUUID = "8eb23e93-5ecb-45ba-b726-3b064e0c568c"
@@ -140,6 +142,11 @@ func TestIncoming(t *testing.T) {
 	RunIncomingTestCases(t, testChannels, newHandler(), testCases)
 }
 
+func TestDedupPolicy(t *testing.T) {
+	policy := courier.GetDedupPolicy(testChannels[0], &courier.DedupPolicy{Strategy: courier.DedupAuto, ContentWindow: 2 * time.Second, ExternalIDWindow: 24 * time.Hour})
+	assert.Equal(t, &courier.DedupPolicy{Strategy: courier.DedupNone, ContentWindow: 2 * time.Second, ExternalIDWindow: 24 * time.Hour}, policy)
+}
+
 func BenchmarkHandler(b *testing.B) {
 	RunChannelBenchmarks(b, testChannels, newHandler(), testCases)
 }
@@ -296,7 +296,10 @@ func (h *handler) receiveEvents(ctx context.Context, channel courier.Channel, w
 		}
 
 		err = h.Backend().WriteMsg(ctx, event, clog)
-		if err != nil {
+		if errors.Is(err, courier.ErrMsgIgnored) {
+			data = append(data, courier.NewInfoData(err.Error()))
+			continue
+		} else if err != nil {
 			return nil, err
 		}
 
@@ -327,12 +330,8 @@ func (h *handler) receiveEvents(ctx context.Context, channel courier.Channel, w
 		data = append(data, courier.NewStatusData(event))
 	}
 
-	webhook := channel.ConfigForKey("webhook", nil)
-	if webhook != nil {
-		er := handlers.SendWebhooks(channel, r, webhook, clog)
-		if er != nil {
-			courier.LogRequestError(r, channel, fmt.Errorf("could not send webhook: %s", er))
-		}
+	if err := handlers.ForwardToWebhook(h.Backend(), channel, r); err != nil {
+		courier.LogRequestError(r, channel, fmt.Errorf("could not forward to webhook: %w", err))
 	}
 
 	return events, courier.WriteDataResponse(w, http.StatusOK, "Events Handled", data)
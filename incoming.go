@@ -0,0 +1,166 @@
+package courier
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// the sorted set of queued requests, scored by when they are next due. Requests being processed stay in the set
+	// but are leased to a worker by being pushed back by the lease duration, so if the instance processing a request
+	// dies, any instance can reclaim it once its lease expires.
+	incomingPendingKey = "incoming:pending"
+
+	// the hash of how many times each queued request has been claimed
+	incomingAttemptsKey = "incoming:attempts"
+
+	// the list of requests which were claimed too many times without being completed, newest first
+	incomingDeadKey = "incoming:dead"
+
+	// how long a worker has to process a request before it can be reclaimed
+	incomingLeaseDuration = 2 * time.Minute
+
+	// the maximum number of times a request can be claimed, e.g. if it keeps crashing its worker
+	incomingMaxAttempts = 3
+
+	// the maximum number of requests we keep in the dead list
+	incomingMaxDead = 1000
+)
+
+// queues the given request for processing in the background
+func queueIncoming(rp *redis.Pool, rr *rawRequest) error {
+	payload, err := json.Marshal(rr)
+	if err != nil {
+		return err
+	}
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	_, err = rc.Do("ZADD", incomingPendingKey, epochMS(time.Now()), payload)
+	return err
+}
+
+//go:embed lua/claim_incoming.lua
+var luaClaimIncoming string
+var scriptClaimIncoming = redis.NewScript(2, luaClaimIncoming)
+
+// claims the next request that is due, returning nil if there are none, and how many times it has been claimed
+func claimIncoming(rc redis.Conn, now time.Time) ([]byte, int, error) {
+	values, err := redis.Values(scriptClaimIncoming.Do(rc, incomingPendingKey, incomingAttemptsKey, epochMS(now), epochMS(now.Add(incomingLeaseDuration))))
+	if err != nil || len(values) == 0 {
+		return nil, 0, err
+	}
+
+	var payload []byte
+	var attempts int
+	if _, err := redis.Scan(values, &payload, &attempts); err != nil {
+		return nil, 0, err
+	}
+	return payload, attempts, nil
+}
+
+// completes a claimed request by removing it from the queue
+func completeIncoming(rc redis.Conn, payload []byte) error {
+	rc.Send("MULTI")
+	rc.Send("ZREM", incomingPendingKey, payload)
+	rc.Send("HDEL", incomingAttemptsKey, payload)
+	_, err := rc.Do("EXEC")
+	return err
+}
+
+// gives up on a claimed request by moving it from the queue to the dead list, trimming the list to our max size
+func deadLetterIncoming(rc redis.Conn, payload []byte) error {
+	rc.Send("MULTI")
+	rc.Send("ZREM", incomingPendingKey, payload)
+	rc.Send("HDEL", incomingAttemptsKey, payload)
+	rc.Send("LPUSH", incomingDeadKey, payload)
+	rc.Send("LTRIM", incomingDeadKey, 0, incomingMaxDead-1)
+	_, err := rc.Do("EXEC")
+	return err
+}
+
+// starts the workers which process requests queued by handlers which receive asynchronously
+func (s *server) startIncomingWorkers() {
+	for i := 0; i < s.config.IncomingWorkers; i++ {
+		s.waitGroup.Add(1)
+
+		go func() {
+			defer s.waitGroup.Done()
+
+			log := slog.With("comp", "incoming worker", "worker_id", i)
+			log.Debug("started")
+
+			for {
+				select {
+				case <-s.stopChan:
+					log.Debug("stopped")
+					return
+				default:
+				}
+
+				processed, err := s.processNextIncoming()
+				if err != nil {
+					log.Error("error processing incoming request", "error", err)
+				}
+
+				// sleep a bit if there was nothing to do
+				if !processed {
+					time.Sleep(250 * time.Millisecond)
+				}
+			}
+		}()
+	}
+}
+
+// processes the next queued request if there is one, returning whether there was
+func (s *server) processNextIncoming() (bool, error) {
+	rc := s.backend.RedisPool().Get()
+	defer rc.Close()
+
+	payload, attempts, err := claimIncoming(rc, time.Now())
+	if err != nil {
+		return false, err
+	} else if payload == nil {
+		return false, nil
+	}
+
+	// if previous attempts at this request never completed, it may be what's killing them so give up on it
+	if attempts > incomingMaxAttempts {
+		slog.Error("incoming request claimed too many times, moving to dead list", "attempts", attempts-1, "dead_key", incomingDeadKey)
+
+		if err := deadLetterIncoming(rc, payload); err != nil {
+			return true, fmt.Errorf("error dead lettering incoming request: %w", err)
+		}
+		return true, nil
+	}
+
+	// regardless of what happens next, this request is done with once we return
+	defer func() {
+		if err := completeIncoming(rc, payload); err != nil {
+			slog.Error("error removing processed incoming request", "error", err)
+		}
+	}()
+
+	rr := &rawRequest{}
+	if err := json.Unmarshal(payload, rr); err != nil {
+		return true, fmt.Errorf("error unmarshalling queued request: %w", err)
+	}
+
+	if _, err := s.reprocess(rr); err != nil {
+		return true, fmt.Errorf("error recreating queued request: %w", err)
+	}
+
+	return true, nil
+}
+
+// formats a time as milliseconds since the epoch for use as a sorted set score
+func epochMS(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
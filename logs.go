@@ -0,0 +1,115 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/httpx"
+)
+
+const (
+	defaultLogsLimit = 50
+	maxLogsLimit     = 100
+)
+
+// ChannelLogQuery describes which logs to fetch for a channel. Logs can be fetched by UUID, by the message they are
+// attached to, or by the time range in which they were created.
+type ChannelLogQuery struct {
+	UUIDs  []clogs.LogUUID
+	MsgID  MsgID
+	After  time.Time
+	Before time.Time
+	Limit  int
+}
+
+type fetchLogsRequest struct {
+	ChannelType ChannelType     `json:"channel_type" validate:"required"`
+	ChannelUUID ChannelUUID     `json:"channel_uuid" validate:"required,uuid"`
+	LogUUIDs    []clogs.LogUUID `json:"log_uuids"    validate:"max=100"`
+	MsgID       MsgID           `json:"msg_id"`
+	After       time.Time       `json:"after"`
+	Before      time.Time       `json:"before"`
+	Limit       int             `json:"limit"        validate:"omitempty,min=1,max=100"`
+}
+
+type channelLogResponse struct {
+	UUID      clogs.LogUUID     `json:"uuid"`
+	Type      clogs.LogType     `json:"type"`
+	HttpLogs  []*httpx.Log      `json:"http_logs"`
+	Errors    []*clogs.LogError `json:"errors"`
+	CreatedOn time.Time         `json:"created_on"`
+	ElapsedMS int               `json:"elapsed_ms"`
+}
+
+type fetchLogsResponse struct {
+	Logs []*channelLogResponse `json:"logs"`
+}
+
+func fetchLogs(ctx context.Context, b Backend, r *http.Request) (*fetchLogsResponse, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+
+	fl := &fetchLogsRequest{}
+	if err := json.Unmarshal(body, fl); err != nil {
+		return nil, fmt.Errorf("error unmarshalling request: %w", err)
+	}
+	if err := utils.Validate(fl); err != nil {
+		return nil, err
+	}
+	if len(fl.LogUUIDs) == 0 && fl.MsgID == NilMsgID && fl.After.IsZero() {
+		return nil, errors.New("must provide log_uuids, msg_id or after")
+	}
+
+	ch, err := b.GetChannel(ctx, fl.ChannelType, fl.ChannelUUID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting channel: %w", err)
+	}
+
+	query := &ChannelLogQuery{UUIDs: fl.LogUUIDs, MsgID: fl.MsgID, After: fl.After, Before: fl.Before, Limit: fl.Limit}
+	if query.Before.IsZero() {
+		query.Before = time.Now()
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultLogsLimit
+	}
+
+	logs, err := b.GetChannelLogs(ctx, ch, query)
+	if err != nil {
+		return nil, fmt.Errorf("error getting channel logs: %w", err)
+	}
+
+	// logs were redacted when written but the channel's secrets may have changed since then
+	var redactVals []string
+	if h := GetHandler(ch.ChannelType()); h != nil {
+		redactVals = h.RedactValues(ch)
+	}
+
+	resp := &fetchLogsResponse{Logs: make([]*channelLogResponse, len(logs))}
+	for i, l := range logs {
+		l.Redact(redactVals)
+
+		resp.Logs[i] = newChannelLogResponse(l)
+	}
+
+	return resp, nil
+}
+
+func newChannelLogResponse(l *clogs.Log) *channelLogResponse {
+	return &channelLogResponse{
+		UUID:      l.UUID,
+		Type:      l.Type,
+		HttpLogs:  l.HttpLogs,
+		Errors:    l.Errors,
+		CreatedOn: l.CreatedOn,
+		ElapsedMS: int(l.Elapsed / time.Millisecond),
+	}
+}
@@ -0,0 +1,16 @@
+-- KEYS: [PendingKey, AttemptsKey]
+-- ARGV: [Now, LeaseUntil]
+
+-- get the first request that is due, which includes requests whose lease expired before they were completed
+local result = redis.call("zrangebyscore", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, 1)
+if not result[1] then
+    return {}
+end
+
+-- lease it to the caller by pushing back when it's next due, and it's removed when completed
+redis.call("zadd", KEYS[1], ARGV[2], result[1])
+
+-- and count this attempt at it so that a request which never completes can be given up on
+local attempts = redis.call("hincrby", KEYS[2], result[1], 1)
+
+return {result[1], attempts}
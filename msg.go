@@ -3,6 +3,8 @@ package courier
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"strconv"
 	"time"
 
@@ -32,6 +34,23 @@ type MsgUUID uuids.UUID
 // NilMsgUUID is a "zero value" message UUID
 const NilMsgUUID = MsgUUID("")
 
+// ErrMsgIgnored is returned by the backend when an incoming message is deliberately not written, in which case the request
+// should still be acknowledged
+var ErrMsgIgnored = errors.New("message ignored")
+
+// ErrMsgDuplicate is returned when accepting an incoming message which has already been received
+var ErrMsgDuplicate = fmt.Errorf("%w, message is a duplicate", ErrMsgIgnored)
+
+// ErrURNBlocked is returned when writing an incoming message from a URN which is blocked on the channel
+var ErrURNBlocked = fmt.Errorf("%w, URN is blocked on this channel", ErrMsgIgnored)
+
+// ErrURNRateLimited is returned when writing an incoming message from a URN which has sent too many messages recently
+var ErrURNRateLimited = fmt.Errorf("%w, URN has exceeded the rate limit for this channel", ErrMsgIgnored)
+
+// ErrMsgBuffered is returned when writing an incoming message which is one part of a longer message, and so is buffered
+// until it can be reassembled with the other parts
+var ErrMsgBuffered = fmt.Errorf("%w, part buffered for reassembly", ErrMsgIgnored)
+
 type FlowReference struct {
 	UUID string `json:"uuid" validate:"uuid4"`
 	Name string `json:"name"`
@@ -112,6 +131,7 @@ type MsgOut interface {
 	UserID() UserID
 	SessionStatus() string
 	HighPriority() bool
+	ExpiresOn() *time.Time
 }
 
 // MsgIn is our interface to represent an incoming
@@ -124,4 +144,12 @@ type MsgIn interface {
 	WithContactName(name string) MsgIn
 	WithURNAuthTokens(tokens map[string]string) MsgIn
 	WithReceivedOn(date time.Time) MsgIn
+	WithSegment(segment *MsgSegment) MsgIn
+}
+
+// MsgSegment identifies an incoming message as one segment of a concatenated SMS which the provider delivered separately
+type MsgSegment struct {
+	Ref   string // the reference shared by all segments of the message
+	Seq   int    // the position of this segment, starting at 1
+	Total int    // the total number of segments
 }
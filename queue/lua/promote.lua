@@ -0,0 +1,28 @@
+-- KEYS: [EpochMS, QueueType, Limit]
+
+-- get the delayed values which are now due
+local delayedKey = KEYS[2] .. ":delayed"
+local due = redis.call("zrangebyscore", delayedKey, "-inf", KEYS[1], "WITHSCORES", "LIMIT", 0, KEYS[3])
+
+for i=1,#due,2 do
+    local item = cjson.decode(due[i])
+    local queueKey = KEYS[2] .. ":" .. item["queue"] .. "|" .. item["tps"]
+
+    -- push onto its priority queue scored by its not-before time so that it goes ahead of values pushed since
+    redis.call("zadd", queueKey .. "/" .. item["priority"], due[i+1], item["value"])
+    redis.call("zrem", delayedKey, due[i])
+
+    -- if the queue isn't currently throttled then make sure it's active
+    local tps = tonumber(item["tps"])
+    local curr = -1
+    if tps > 0 then
+        local tpsKey = queueKey .. ":tps:" .. math.floor(KEYS[1])
+        curr = tonumber(redis.call("get", tpsKey))
+    end
+
+    if not curr or curr < tps then
+        redis.call("zincrby", KEYS[2] .. ":active", 0, queueKey)
+    end
+end
+
+return #due / 2
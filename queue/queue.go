@@ -2,8 +2,11 @@ package queue
 
 import (
 	_ "embed"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -41,8 +44,27 @@ var scriptPush = redis.NewScript(6, luaPush)
 // specified transactions per second are popped off at a time. A tps value of 0 means there is no
 // limit to the rate that messages can be consumed
 func PushOntoQueue(conn redis.Conn, qType string, queue string, tps int, value string, priority Priority) error {
-	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
-	_, err := redis.Int(scriptPush.Do(conn, epochMS, qType, queue, tps, priority, value))
+	_, err := redis.Int(scriptPush.Do(conn, epochMS(time.Now()), qType, queue, tps, priority, value))
+	return err
+}
+
+// a value which has been delayed until it can be pushed onto its queue
+type delayedValue struct {
+	Queue    string   `json:"queue"`
+	TPS      int      `json:"tps"`
+	Priority Priority `json:"priority"`
+	Value    string   `json:"value"`
+}
+
+// PushOntoQueueAt pushes the passed in value to the passed in queue like PushOntoQueue, but it won't be pushed onto the
+// queue and so can't be popped until the given time. Delayed values are pushed onto their queues by StartPromoter.
+func PushOntoQueueAt(conn redis.Conn, qType string, queue string, tps int, value string, priority Priority, notBefore time.Time) error {
+	delayed, err := json.Marshal(&delayedValue{Queue: queue, TPS: tps, Priority: priority, Value: value})
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Do("ZADD", qType+":delayed", epochMS(notBefore), delayed)
 	return err
 }
 
@@ -55,8 +77,7 @@ var scriptPop = redis.NewScript(2, luaPop)
 // worker token of EmptyQueue will be returned if there are no more items to retrive.
 // Otherwise the WorkerToken should be saved in order to mark the task as complete later.
 func PopFromQueue(conn redis.Conn, qType string) (WorkerToken, string, error) {
-	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
-	values, err := redis.Strings(scriptPop.Do(conn, epochMS, qType))
+	values, err := redis.Strings(scriptPop.Do(conn, epochMS(time.Now()), qType))
 	if err != nil {
 		slog.Error("error popping from queue", "error", err)
 		return "", "", err
@@ -79,6 +100,53 @@ func MarkComplete(conn redis.Conn, qType string, token WorkerToken) error {
 	return err
 }
 
+// Requeue pushes a single value which was popped with the given worker token back onto the front of the queue it was
+// popped from and marks the task as complete. Callers can use this to return values they popped but won't process.
+func Requeue(conn redis.Conn, qType string, token WorkerToken, value string, priority Priority) error {
+	queue, tps, err := parseWorkerToken(qType, token)
+	if err != nil {
+		return err
+	}
+
+	// pushing with a zero timestamp puts this value ahead of everything else in the queue
+	if _, err := redis.Int(scriptPush.Do(conn, "0", qType, queue, tps, priority, "["+value+"]")); err != nil {
+		return err
+	}
+
+	return MarkComplete(conn, qType, token)
+}
+
+// RequeueAt is like Requeue but the value can't be popped again until the given time, e.g. because a channel asked us
+// to retry later or can't send right now.
+func RequeueAt(conn redis.Conn, qType string, token WorkerToken, value string, priority Priority, notBefore time.Time) error {
+	queue, tps, err := parseWorkerToken(qType, token)
+	if err != nil {
+		return err
+	}
+
+	if err := PushOntoQueueAt(conn, qType, queue, tps, "["+value+"]", priority, notBefore); err != nil {
+		return err
+	}
+
+	return MarkComplete(conn, qType, token)
+}
+
+// worker tokens are the full queue key, e.g. "msgs:uuid1-uuid2-uuid3-uuid4|tps"
+func parseWorkerToken(qType string, token WorkerToken) (string, int, error) {
+	key, found := strings.CutPrefix(string(token), qType+":")
+	if !found {
+		return "", 0, fmt.Errorf("invalid worker token for queue type %s: %s", qType, token)
+	}
+
+	queue, tpsStr, found := strings.Cut(key, "|")
+	tps, err := strconv.Atoi(tpsStr)
+	if !found || err != nil {
+		return "", 0, fmt.Errorf("invalid worker token for queue type %s: %s", qType, token)
+	}
+
+	return queue, tps, nil
+}
+
 //go:embed lua/dethrottle.lua
 var luaDethrottle string
 var scriptDethrottle = redis.NewScript(1, luaDethrottle)
@@ -111,3 +179,54 @@ func StartDethrottler(redis *redis.Pool, quitter chan bool, wg *sync.WaitGroup,
 		}
 	}()
 }
+
+//go:embed lua/promote.lua
+var luaPromote string
+var scriptPromote = redis.NewScript(3, luaPromote)
+
+// max number of delayed values promoted by a single call of the promote script
+const maxPromote = 1000
+
+// PromoteDue pushes delayed values which are due by the given time onto their queues, returning how many were pushed
+func PromoteDue(conn redis.Conn, qType string, now time.Time) (int, error) {
+	total := 0
+	for {
+		promoted, err := redis.Int(scriptPromote.Do(conn, epochMS(now), qType, maxPromote))
+		if err != nil {
+			return total, err
+		}
+		total += promoted
+
+		if promoted < maxPromote {
+			return total, nil
+		}
+	}
+}
+
+// StartPromoter starts a goroutine responsible for pushing delayed values onto their queues once they're due, every
+// second. The passed in quitter chan can be used to shut down the goroutine
+func StartPromoter(redis *redis.Pool, quitter chan bool, wg *sync.WaitGroup, qType string) {
+	wg.Add(1)
+
+	go func() {
+		for {
+			select {
+			case <-quitter:
+				wg.Done()
+				return
+
+			case <-time.After(time.Second):
+				rc := redis.Get()
+				if _, err := PromoteDue(rc, qType, time.Now()); err != nil {
+					slog.Error("error promoting delayed values", "error", err)
+				}
+				rc.Close()
+			}
+		}
+	}()
+}
+
+// queue scores are epoch seconds with microsecond precision
+func epochMS(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+}
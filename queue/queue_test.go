@@ -288,6 +288,99 @@ func TestThrottle(t *testing.T) {
 	wg.Wait()
 }
 
+func TestRequeue(t *testing.T) {
+	rp := getPool()
+	rc := rp.Get()
+	defer rc.Close()
+
+	for i := 0; i < 3; i++ {
+		err := PushOntoQueue(rc, "msgs", "chan1", 10, fmt.Sprintf(`[{"id":%d}]`, i), HighPriority)
+		require.NoError(t, err)
+	}
+
+	token, value, err := PopFromQueue(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, WorkerToken("msgs:chan1|10"), token)
+	assert.Equal(t, `{"id":0}`, value)
+
+	assertredis.ZGetAll(t, rc, "msgs:active", map[string]float64{"msgs:chan1|10": 1})
+
+	// put it back and it should be the next value popped, and our worker count should be back to zero
+	err = Requeue(rc, "msgs", token, value, HighPriority)
+	assert.NoError(t, err)
+
+	assertredis.ZGetAll(t, rc, "msgs:active", map[string]float64{"msgs:chan1|10": 0})
+
+	token, value, err = PopFromQueue(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, WorkerToken("msgs:chan1|10"), token)
+	assert.Equal(t, `{"id":0}`, value)
+
+	err = Requeue(rc, "msgs", WorkerToken("foo:chan1|10"), value, HighPriority)
+	assert.EqualError(t, err, "invalid worker token for queue type msgs: foo:chan1|10")
+
+	err = Requeue(rc, "msgs", WorkerToken("msgs:chan1"), value, HighPriority)
+	assert.EqualError(t, err, "invalid worker token for queue type msgs: msgs:chan1")
+}
+
+func TestDelayed(t *testing.T) {
+	rp := getPool()
+	rc := rp.Get()
+	defer rc.Close()
+
+	err := PushOntoQueueAt(rc, "msgs", "chan1", 10, `[{"id":0}]`, HighPriority, time.Now().Add(100*time.Millisecond))
+	require.NoError(t, err)
+
+	assertredis.ZCard(t, rc, "msgs:delayed", 1)
+
+	// value isn't on its queue yet so can't be popped
+	_, value, err := PopFromQueue(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, "", value)
+
+	promoted, err := PromoteDue(rc, "msgs", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, promoted)
+
+	time.Sleep(150 * time.Millisecond)
+
+	// once it's due it's pushed onto its queue and can be popped
+	promoted, err = PromoteDue(rc, "msgs", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, promoted)
+
+	assertredis.ZCard(t, rc, "msgs:delayed", 0)
+
+	token, value, err := PopFromQueue(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, WorkerToken("msgs:chan1|10"), token)
+	assert.Equal(t, `{"id":0}`, value)
+
+	// put it back with a delay, it can't be popped until it's promoted again, and our worker count should be back to zero
+	err = RequeueAt(rc, "msgs", token, value, HighPriority, time.Now().Add(100*time.Millisecond))
+	assert.NoError(t, err)
+
+	assertredis.ZGetAll(t, rc, "msgs:active", map[string]float64{"msgs:chan1|10": 0})
+
+	_, value, err = PopFromQueue(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, "", value)
+
+	time.Sleep(150 * time.Millisecond)
+
+	promoted, err = PromoteDue(rc, "msgs", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, promoted)
+
+	token, value, err = PopFromQueue(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, WorkerToken("msgs:chan1|10"), token)
+	assert.Equal(t, `{"id":0}`, value)
+
+	err = RequeueAt(rc, "msgs", WorkerToken("foo:chan1|10"), value, HighPriority, time.Now())
+	assert.EqualError(t, err, "invalid worker token for queue type msgs: foo:chan1|10")
+}
+
 func BenchmarkQueue(b *testing.B) {
 	assert := assert.New(b)
 	pool := getPool()
@@ -0,0 +1,107 @@
+package courier
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// origins of messages which can't be sent during quiet hours, messages from other origins (e.g. chat or ticket replies)
+// are exempt
+var quietHoursOrigins = []MsgOrigin{MsgOriginFlow, MsgOriginBroadcast}
+
+// QuietHours is a daily window in a channel's timezone during which some outgoing messages shouldn't be sent
+type QuietHours struct {
+	Start    time.Duration // offset from midnight of when quiet hours start
+	End      time.Duration // offset from midnight of when quiet hours end, can be before start if window spans midnight
+	Location *time.Location
+}
+
+// ParseQuietHours parses a quiet hours window like "21:00-08:00" in the given location
+func ParseQuietHours(s string, loc *time.Location) (*QuietHours, error) {
+	startStr, endStr, found := strings.Cut(s, "-")
+	if !found {
+		return nil, fmt.Errorf("invalid quiet hours: %s", s)
+	}
+
+	start, err := parseTimeOfDay(strings.TrimSpace(startStr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid quiet hours: %s", s)
+	}
+	end, err := parseTimeOfDay(strings.TrimSpace(endStr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid quiet hours: %s", s)
+	}
+	if start == end {
+		return nil, fmt.Errorf("invalid quiet hours: %s", s)
+	}
+
+	return &QuietHours{Start: start, End: end, Location: loc}, nil
+}
+
+// GetQuietHours returns the quiet hours of the given channel, or nil if it doesn't have valid quiet hours
+func GetQuietHours(ch Channel) *QuietHours {
+	window := ch.StringConfigForKey(ConfigQuietHours, "")
+	if window == "" {
+		return nil
+	}
+
+	loc, err := time.LoadLocation(ch.StringConfigForKey(ConfigTimezone, "UTC"))
+	if err != nil {
+		return nil
+	}
+
+	qh, err := ParseQuietHours(window, loc)
+	if err != nil {
+		return nil
+	}
+	return qh
+}
+
+// EndOf returns when the quiet hours window that the given time is in ends, or nil if it isn't in quiet hours
+func (q *QuietHours) EndOf(t time.Time) *time.Time {
+	local := t.In(q.Location)
+	sinceMidnight := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute + time.Duration(local.Second())*time.Second
+
+	var endDay time.Time
+	if q.Start < q.End {
+		if sinceMidnight < q.Start || sinceMidnight >= q.End {
+			return nil
+		}
+		endDay = local
+	} else if sinceMidnight >= q.Start {
+		endDay = local.AddDate(0, 0, 1)
+	} else if sinceMidnight < q.End {
+		endDay = local
+	} else {
+		return nil
+	}
+
+	// construct from the date rather than adding durations so that DST changes are handled
+	end := time.Date(endDay.Year(), endDay.Month(), endDay.Day(), int(q.End/time.Hour), int(q.End%time.Hour/time.Minute), 0, 0, q.Location)
+	return &end
+}
+
+// QuietHoursEnd returns when the given message can be sent if it can't be sent now because of its channel's quiet
+// hours, or nil if it can be sent now
+func QuietHoursEnd(msg MsgOut, now time.Time) *time.Time {
+	if !slices.Contains(quietHoursOrigins, msg.Origin()) {
+		return nil
+	}
+
+	qh := GetQuietHours(msg.Channel())
+	if qh == nil {
+		return nil
+	}
+	return qh.EndOf(now)
+}
+
+// parses a time of day like "08:30" as an offset from midnight
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,70 @@
+package courier_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuietHours(t *testing.T) {
+	kigali, _ := time.LoadLocation("Africa/Kigali")
+
+	qh, err := courier.ParseQuietHours("21:00-08:30", kigali)
+	require.NoError(t, err)
+	assert.Equal(t, &courier.QuietHours{Start: 21 * time.Hour, End: 8*time.Hour + 30*time.Minute, Location: kigali}, qh)
+
+	for _, s := range []string{"", "21:00", "21:00-", "9pm-8am", "25:00-08:00", "08:00-08:00"} {
+		_, err := courier.ParseQuietHours(s, kigali)
+		assert.Error(t, err, "expected error for '%s'", s)
+	}
+
+	// times are in UTC and Kigali is UTC+2
+	assert.Nil(t, qh.EndOf(time.Date(2024, 6, 1, 18, 59, 0, 0, time.UTC)))
+	assert.Equal(t, time.Date(2024, 6, 2, 8, 30, 0, 0, kigali), *qh.EndOf(time.Date(2024, 6, 1, 19, 0, 0, 0, time.UTC)))
+	assert.Equal(t, time.Date(2024, 6, 2, 8, 30, 0, 0, kigali), *qh.EndOf(time.Date(2024, 6, 1, 23, 0, 0, 0, time.UTC)))
+	assert.Equal(t, time.Date(2024, 6, 2, 8, 30, 0, 0, kigali), *qh.EndOf(time.Date(2024, 6, 2, 6, 29, 0, 0, time.UTC)))
+	assert.Nil(t, qh.EndOf(time.Date(2024, 6, 2, 6, 30, 0, 0, time.UTC)))
+
+	// windows can also be within a single day
+	qh, err = courier.ParseQuietHours("12:00 - 14:00", time.UTC)
+	require.NoError(t, err)
+	assert.Nil(t, qh.EndOf(time.Date(2024, 6, 1, 11, 59, 0, 0, time.UTC)))
+	assert.Equal(t, time.Date(2024, 6, 1, 14, 0, 0, 0, time.UTC), *qh.EndOf(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)))
+	assert.Nil(t, qh.EndOf(time.Date(2024, 6, 1, 14, 0, 0, 0, time.UTC)))
+}
+
+func TestQuietHoursEnd(t *testing.T) {
+	now := time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC)
+	kigali, _ := time.LoadLocation("Africa/Kigali")
+
+	noQuiet := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "MCK", "1234", "RW", []string{urns.Phone.Prefix}, map[string]any{})
+	quiet := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "MCK", "1234", "RW", []string{urns.Phone.Prefix}, map[string]any{
+		courier.ConfigQuietHours: "21:00-08:00",
+		courier.ConfigTimezone:   "Africa/Kigali",
+	})
+	badTimezone := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "MCK", "1234", "RW", []string{urns.Phone.Prefix}, map[string]any{
+		courier.ConfigQuietHours: "21:00-08:00",
+		courier.ConfigTimezone:   "Africa/Xyz",
+	})
+
+	newMsg := func(ch courier.Channel, origin courier.MsgOrigin) courier.MsgOut {
+		return test.NewMockMsg(courier.MsgID(101), courier.NilMsgUUID, ch, "tel:+250788383383", "hi", nil).WithOrigin(origin)
+	}
+
+	assert.Nil(t, courier.QuietHoursEnd(newMsg(noQuiet, courier.MsgOriginBroadcast), now))
+	assert.Nil(t, courier.QuietHoursEnd(newMsg(badTimezone, courier.MsgOriginBroadcast), now))
+	assert.Equal(t, time.Date(2024, 6, 2, 8, 0, 0, 0, kigali), *courier.QuietHoursEnd(newMsg(quiet, courier.MsgOriginBroadcast), now))
+	assert.Equal(t, time.Date(2024, 6, 2, 8, 0, 0, 0, kigali), *courier.QuietHoursEnd(newMsg(quiet, courier.MsgOriginFlow), now))
+
+	// chat and ticket messages are exempt
+	assert.Nil(t, courier.QuietHoursEnd(newMsg(quiet, courier.MsgOriginChat), now))
+	assert.Nil(t, courier.QuietHoursEnd(newMsg(quiet, courier.MsgOriginTicket), now))
+
+	// and nothing is deferred outside of quiet hours
+	assert.Nil(t, courier.QuietHoursEnd(newMsg(quiet, courier.MsgOriginBroadcast), time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)))
+}
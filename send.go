@@ -0,0 +1,164 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/gocommon/uuids"
+)
+
+type sendRequest struct {
+	ChannelType  ChannelType `json:"channel_type"`
+	ChannelUUID  ChannelUUID `json:"channel_uuid"  validate:"required,uuid"`
+	URN          urns.URN    `json:"urn"           validate:"required"`
+	Text         string      `json:"text"`
+	Attachments  []string    `json:"attachments"`
+	QuickReplies []string    `json:"quick_replies"`
+	Locale       i18n.Locale `json:"locale"`
+	Templating   *Templating `json:"templating"`
+}
+
+type sendResponse struct {
+	Status      MsgStatus           `json:"status"`
+	ExternalIDs []string            `json:"external_ids"`
+	NewURN      urns.URN            `json:"new_urn,omitempty"`
+	Log         *channelLogResponse `json:"log"`
+}
+
+// sends a message described by the request synchronously through the channel's handler, so that channels can be
+// tested without needing to create a message in the backend. Nothing is written to the backend except for any events
+// which the handler's response requires, e.g. a contact opting out.
+func sendTestMsg(ctx context.Context, s Server, r *http.Request) (*sendResponse, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+
+	sr := &sendRequest{}
+	if err := json.Unmarshal(body, sr); err != nil {
+		return nil, fmt.Errorf("error unmarshalling request: %w", err)
+	}
+	if err := utils.Validate(sr); err != nil {
+		return nil, err
+	}
+	if sr.Text == "" && len(sr.Attachments) == 0 && sr.Templating == nil {
+		return nil, errors.New("must provide text, attachments or templating")
+	}
+	if err := sr.URN.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid URN: %w", err)
+	}
+
+	ch, err := s.Backend().GetChannel(ctx, sr.ChannelType, sr.ChannelUUID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting channel: %w", err)
+	}
+
+	handler := s.GetHandler(ch)
+	if handler == nil {
+		return nil, fmt.Errorf("no handler for channel type: %s", ch.ChannelType())
+	}
+
+	msg := &directMsg{
+		uuid:         MsgUUID(uuids.NewV4()),
+		channel:      ch,
+		urn:          sr.URN,
+		text:         sr.Text,
+		attachments:  sr.Attachments,
+		quickReplies: sr.QuickReplies,
+		locale:       sr.Locale,
+		templating:   sr.Templating,
+	}
+
+	log := slog.With("comp", "send", "channel_uuid", ch.UUID(), "msg_uuid", msg.uuid)
+
+	clog := NewChannelLogForSend(msg, handler.RedactValues(ch))
+	clog.RedactMsgPII(msg.URN(), msg.Text(), msg.Attachments())
+
+	res := &SendResult{newURN: urns.NilURN}
+	status := sendByHandler(ctx, s.Backend(), handler, msg, res, clog, log)
+
+	clog.End()
+
+	resp := &sendResponse{
+		Status:      status.Status(),
+		ExternalIDs: res.ExternalIDs(),
+		Log:         newChannelLogResponse(clog.Log),
+	}
+	if resp.ExternalIDs == nil {
+		resp.ExternalIDs = []string{}
+	}
+	if res.newURN != urns.NilURN {
+		resp.NewURN = res.newURN
+	}
+
+	return resp, nil
+}
+
+// SendReply sends the given text to the given URN synchronously through the given handler, without a message being
+// created in the backend, e.g. for automatic confirmations of keywords. The send is logged to the channel.
+func SendReply(ctx context.Context, handler ChannelHandler, ch Channel, urn urns.URN, text string) MsgStatus {
+	backend := handler.Server().Backend()
+	msg := &directMsg{uuid: MsgUUID(uuids.NewV4()), channel: ch, urn: urn, text: text}
+
+	log := slog.With("comp", "send", "channel_uuid", ch.UUID(), "msg_uuid", msg.uuid)
+
+	clog := NewChannelLog(ChannelLogTypeMsgSend, ch, handler.RedactValues(ch))
+	clog.RedactMsgPII(msg.URN(), msg.Text(), msg.Attachments())
+
+	status := sendByHandler(ctx, backend, handler, msg, &SendResult{newURN: urns.NilURN}, clog, log)
+
+	clog.End()
+
+	if err := backend.WriteChannelLog(ctx, clog); err != nil {
+		log.Error("error writing reply log", "error", err)
+	}
+
+	return status.Status()
+}
+
+// directMsg is an outgoing message which only exists for the duration of a test send or reply
+type directMsg struct {
+	uuid         MsgUUID
+	channel      Channel
+	urn          urns.URN
+	text         string
+	attachments  []string
+	quickReplies []string
+	locale       i18n.Locale
+	templating   *Templating
+}
+
+func (m *directMsg) EventID() int64                { return 0 }
+func (m *directMsg) ID() MsgID                     { return NilMsgID }
+func (m *directMsg) UUID() MsgUUID                 { return m.uuid }
+func (m *directMsg) ExternalID() string            { return "" }
+func (m *directMsg) Text() string                  { return m.text }
+func (m *directMsg) Attachments() []string         { return m.attachments }
+func (m *directMsg) URN() urns.URN                 { return m.urn }
+func (m *directMsg) Channel() Channel              { return m.channel }
+func (m *directMsg) QuickReplies() []string        { return m.quickReplies }
+func (m *directMsg) Locale() i18n.Locale           { return m.locale }
+func (m *directMsg) Templating() *Templating       { return m.templating }
+func (m *directMsg) URNAuth() string               { return "" }
+func (m *directMsg) Origin() MsgOrigin             { return MsgOriginChat }
+func (m *directMsg) ContactLastSeenOn() *time.Time { return nil }
+func (m *directMsg) Topic() string                 { return "" }
+func (m *directMsg) Metadata() json.RawMessage     { return nil }
+func (m *directMsg) ResponseToExternalID() string  { return "" }
+func (m *directMsg) SentOn() *time.Time            { return nil }
+func (m *directMsg) IsResend() bool                { return false }
+func (m *directMsg) Flow() *FlowReference          { return nil }
+func (m *directMsg) OptIn() *OptInReference        { return nil }
+func (m *directMsg) UserID() UserID                { return 0 }
+func (m *directMsg) SessionStatus() string         { return "" }
+func (m *directMsg) HighPriority() bool            { return true }
+func (m *directMsg) ExpiresOn() *time.Time         { return nil }
@@ -5,15 +5,20 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/courier/utils/tracing"
 	"github.com/nyaruka/gocommon/urns"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type SendResult struct {
 	externalIDs []string
 	newURN      urns.URN
+	retryAfter  time.Duration
 }
 
 func (r *SendResult) AddExternalID(id string) {
@@ -41,6 +46,8 @@ type SendError struct {
 	clogCode    string
 	clogMsg     string
 	clogExtCode string
+
+	retryAfter time.Duration
 }
 
 func (e *SendError) Error() string {
@@ -130,21 +137,45 @@ func ErrFailedWithReason(code, desc string) *SendError {
 	}
 }
 
+// ErrRetryAfter should be returned when channel tells us to retry the send after a given time, the message will be put
+// back on its queue until then
+func ErrRetryAfter(after time.Duration) *SendError {
+	return &SendError{
+		msg:        "channel asked to retry later",
+		retryable:  true,
+		loggable:   false,
+		clogCode:   "retry_after",
+		clogMsg:    fmt.Sprintf("Channel asked for send to be retried after %s.", after),
+		retryAfter: after,
+	}
+}
+
 // Foreman takes care of managing our set of sending workers and assigns msgs for each to send
 type Foreman struct {
 	server           Server
 	senders          []*Sender
 	availableSenders chan *Sender
 	quit             chan bool
+	assignerDone     chan bool
+
+	// in-flight sends use this context so they can be cancelled if they don't finish before our drain deadline
+	sendCtx    context.Context
+	cancelSend context.CancelFunc
+	sending    sync.WaitGroup
 }
 
 // NewForeman creates a new Foreman for the passed in server with the number of max senders
 func NewForeman(server Server, maxSenders int) *Foreman {
+	sendCtx, cancelSend := context.WithCancel(context.Background())
+
 	foreman := &Foreman{
 		server:           server,
 		senders:          make([]*Sender, maxSenders),
 		availableSenders: make(chan *Sender, maxSenders),
 		quit:             make(chan bool),
+		assignerDone:     make(chan bool),
+		sendCtx:          sendCtx,
+		cancelSend:       cancelSend,
 	}
 
 	for i := 0; i < maxSenders; i++ {
@@ -162,13 +193,99 @@ func (f *Foreman) Start() {
 	go f.Assign()
 }
 
-// Stop stops the foreman and all its senders, the wait group of the server can be used to track progress
+// Stop drains the foreman, it stops popping new messages, waits for in-flight sends to complete up to the configured
+// drain timeout, after which they are cancelled, and then stops all its senders
 func (f *Foreman) Stop() {
+	log := slog.With("comp", "foreman")
+	log.Info("foreman stopping", "state", "stopping")
+
+	// stop assigning messages and wait for our assigner to exit so that nothing more gets assigned to senders
+	close(f.quit)
+	<-f.assignerDone
+
+	// senders will exit once they've finished their current send
 	for _, sender := range f.senders {
 		sender.Stop()
 	}
-	close(f.quit)
-	slog.Info("foreman stopping", "comp", "foreman", "state", "stopping")
+
+	sendsDone := make(chan bool)
+	go func() {
+		f.sending.Wait()
+		close(sendsDone)
+	}()
+
+	timeout := time.Duration(f.server.Config().DrainTimeout) * time.Second
+
+	select {
+	case <-sendsDone:
+	case <-time.After(timeout):
+		log.Warn("drain timeout reached, cancelling in-flight sends", "timeout", timeout)
+		f.cancelSend()
+		<-sendsDone
+	}
+
+	f.cancelSend()
+}
+
+// returns whether we've been told to stop
+func (f *Foreman) draining() bool {
+	select {
+	case <-f.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// returns a message that was popped but won't be sent to the backend queue
+func (f *Foreman) requeue(msg MsgOut, log *slog.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if err := f.server.Backend().RequeueOutgoingMsg(ctx, msg); err != nil {
+		log.Error("error requeuing outgoing msg", "error", err, "msg_id", msg.ID())
+	} else {
+		log.Info("requeued outgoing msg", "msg_id", msg.ID())
+	}
+}
+
+// returns a message that was popped but can't be sent yet to the backend to be popped again after the given time,
+// which can fail, e.g. if the message has already been deferred too many times
+func (f *Foreman) deferUntil(msg MsgOut, until time.Time, log *slog.Logger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if err := f.server.Backend().DeferOutgoingMsg(ctx, msg, until); err != nil {
+		log.Error("error deferring outgoing msg", "error", err, "msg_id", msg.ID())
+		return err
+	}
+
+	log.Debug("deferred outgoing msg", "msg_id", msg.ID(), "until", until)
+	return nil
+}
+
+// errors a message that was popped but couldn't be deferred, so that it's retried like any other failed send rather
+// than being lost
+func (f *Foreman) errorUndeferred(msg MsgOut, until time.Time, log *slog.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	backend := f.server.Backend()
+	clog := NewChannelLogForSend(msg, nil)
+	clog.Error(ErrorMsgNotDeferred(until))
+
+	status := backend.NewStatusUpdate(msg.Channel(), msg.ID(), MsgStatusErrored, clog)
+	if err := backend.WriteStatusUpdate(ctx, status); err != nil {
+		log.Error("error writing msg status", "error", err, "msg_id", msg.ID())
+	}
+
+	clog.End()
+
+	if err := backend.WriteChannelLog(ctx, clog); err != nil {
+		log.Error("error writing msg logs", "error", err, "msg_id", msg.ID())
+	}
+
+	backend.OnSendComplete(ctx, msg, status, clog)
 }
 
 // Assign is our main loop for the Foreman, it takes care of popping the next outgoing messages from our
@@ -176,6 +293,7 @@ func (f *Foreman) Stop() {
 func (f *Foreman) Assign() {
 	f.server.WaitGroup().Add(1)
 	defer f.server.WaitGroup().Done()
+	defer close(f.assignerDone)
 	log := slog.With("comp", "foreman")
 
 	log.Info("senders started and waiting",
@@ -200,7 +318,24 @@ func (f *Foreman) Assign() {
 			cancel()
 
 			if err == nil && msg != nil {
+				// if we started draining while popping, this message needs to go back
+				if f.draining() {
+					f.requeue(msg, log)
+					log.Info("foreman stopped", "state", "stopped")
+					return
+				}
+
+				// if this message can't be sent during the channel's quiet hours, it needs to go back until they end
+				if until := QuietHoursEnd(msg, time.Now()); until != nil {
+					if err := f.deferUntil(msg, *until, log); err != nil {
+						f.errorUndeferred(msg, *until, log)
+					}
+					f.availableSenders <- sender
+					continue
+				}
+
 				// if so, assign it to our sender
+				f.sending.Add(1)
 				sender.job <- msg
 				lastSleep = false
 			} else {
@@ -259,6 +394,7 @@ func (w *Sender) Start() {
 			}
 
 			w.sendMessage(msg)
+			w.foreman.sending.Done()
 		}
 	}()
 }
@@ -275,8 +411,14 @@ func (w *Sender) sendMessage(msg MsgOut) {
 	server := w.foreman.server
 	backend := server.Backend()
 
+	ctx, span := tracing.Tracer().Start(w.foreman.sendCtx, "send",
+		trace.WithAttributes(tracing.ChannelAttrs(string(msg.Channel().UUID()), string(msg.Channel().ChannelType()))...),
+		trace.WithAttributes(tracing.AttrMsgID.Int64(int64(msg.ID()))),
+	)
+	defer span.End()
+
 	// we don't want any individual send taking more than 35s
-	sendCTX, cancel := context.WithTimeout(context.Background(), time.Second*35)
+	sendCTX, cancel := context.WithTimeout(ctx, time.Second*35)
 	defer cancel()
 
 	log = log.With("msg_id", msg.ID(), "msg_text", msg.Text(), "msg_urn", msg.URN().Identity())
@@ -304,6 +446,7 @@ func (w *Sender) sendMessage(msg MsgOut) {
 	}
 
 	var status StatusUpdate
+	var deferred bool
 	var redactValues []string
 	handler := server.GetHandler(msg.Channel())
 	if handler != nil {
@@ -311,6 +454,8 @@ func (w *Sender) sendMessage(msg MsgOut) {
 	}
 
 	clog := NewChannelLogForSend(msg, redactValues)
+	clog.span = span
+	clog.RedactMsgPII(msg.URN(), msg.Text(), msg.Attachments())
 
 	if handler == nil {
 		// if there's no handler, create a FAILED status for it
@@ -322,17 +467,49 @@ func (w *Sender) sendMessage(msg MsgOut) {
 		status = backend.NewStatusUpdate(msg.Channel(), msg.ID(), MsgStatusWired, clog)
 		log.Warn("duplicate send, marking as wired")
 
+	} else if expiresOn := msg.ExpiresOn(); expiresOn != nil && time.Now().After(*expiresOn) {
+		// if this message expired while it was queued, fail it rather than send it late
+		status = backend.NewStatusUpdate(msg.Channel(), msg.ID(), MsgStatusFailed, clog)
+		clog.Error(ErrorMsgExpired(*expiresOn))
+		log.Warn("msg expired before send, marking as failed", "expires_on", *expiresOn)
+
+	} else if w.foreman.sendCtx.Err() != nil {
+		// we've been cancelled before we could start sending so put this message back on its queue
+		w.foreman.requeue(msg, log)
+		return
+
 	} else {
-		status = w.sendByHandler(sendCTX, handler, msg, clog, log)
+		res := &SendResult{newURN: urns.NilURN}
+		status = sendByHandler(sendCTX, backend, handler, msg, res, clog, log)
+
+		// if the channel asked us to retry later, put this message back on its queue until then rather than erroring it,
+		// but if we can't or some of it was already sent, it keeps its errored status and is retried like any other
+		// failed send
+		if res.retryAfter > 0 && len(res.ExternalIDs()) == 0 {
+			until := time.Now().Add(res.retryAfter)
+			if err := w.foreman.deferUntil(msg, until, log); err == nil {
+				deferred = true
+			} else {
+				clog.Error(ErrorMsgNotDeferred(until))
+			}
+		}
+	}
+
+	span.SetAttributes(tracing.AttrMsgStatus.String(string(status.Status())))
+	if status.Status() == MsgStatusFailed || status.Status() == MsgStatusErrored {
+		span.SetStatus(codes.Error, "error sending message")
 	}
 
-	// we allot 10 seconds to write our status to the db
-	writeCTX, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	// we allot 10 seconds to write our status to the db, even if the send itself was cancelled
+	writeCTX, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second*10)
 	defer cancel()
 
-	err = backend.WriteStatusUpdate(writeCTX, status)
-	if err != nil {
-		log.Info("error writing msg status", "error", err)
+	// deferred messages will be sent later so there's no status to write yet
+	if !deferred {
+		err = backend.WriteStatusUpdate(writeCTX, status)
+		if err != nil {
+			log.Info("error writing msg status", "error", err)
+		}
 	}
 
 	clog.End()
@@ -343,13 +520,27 @@ func (w *Sender) sendMessage(msg MsgOut) {
 		log.Info("error writing msg logs", "error", err)
 	}
 
+	// and if deferred, the message is back on its queue so its send isn't complete
+	if deferred {
+		return
+	}
+
 	// mark our send task as complete
 	backend.OnSendComplete(writeCTX, msg, status, clog)
 }
 
-func (w *Sender) sendByHandler(ctx context.Context, h ChannelHandler, m MsgOut, clog *ChannelLog, log *slog.Logger) StatusUpdate {
-	backend := w.foreman.server.Backend()
-	res := &SendResult{newURN: urns.NilURN}
+// sends the given message using the given handler, returning the resulting status update
+func sendByHandler(ctx context.Context, backend Backend, h ChannelHandler, m MsgOut, res *SendResult, clog *ChannelLog, log *slog.Logger) StatusUpdate {
+	// if the handler declares a config schema, check the channel's config is valid before trying to send
+	if errs := ValidateChannelConfig(m.Channel()); len(errs) > 0 {
+		for _, e := range errs {
+			clog.Error(ErrorChannelConfig(e.Key, e.Message))
+		}
+		log.Warn("channel config invalid, failing msg", "config_key", errs[0].Key)
+
+		return backend.NewStatusUpdate(m.Channel(), m.ID(), MsgStatusFailed, clog)
+	}
+
 	err := h.Send(ctx, m, res, clog)
 
 	status := backend.NewStatusUpdate(m.Channel(), m.ID(), MsgStatusWired, clog)
@@ -376,6 +567,7 @@ func (w *Sender) sendByHandler(ctx context.Context, h ChannelHandler, m MsgOut,
 		} else {
 			status.SetStatus(MsgStatusFailed)
 		}
+		res.retryAfter = serr.retryAfter
 
 		clog.Error(clogs.NewLogError(serr.clogCode, serr.clogExtCode, serr.clogMsg))
 
@@ -19,8 +19,13 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/courier/utils/secrets"
+	"github.com/nyaruka/courier/utils/tracing"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/jsonx"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // for use in request.Context
@@ -29,8 +34,20 @@ type contextKey int
 const (
 	contextRequestURL contextKey = iota
 	contextRequestStart
+	contextReprocessing
 )
 
+// WithReprocessing returns a copy of the given context which marks a request as being reprocessed
+func WithReprocessing(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextReprocessing, true)
+}
+
+// IsReprocessing returns whether the request with the given context is being reprocessed from our queue or archive, in
+// which case it was accepted when it was first received
+func IsReprocessing(ctx context.Context) bool {
+	return ctx.Value(contextReprocessing) != nil
+}
+
 // Server is the main interface ChannelHandlers use to interact with backends. It provides an
 // abstraction that makes mocking easier for isolated unit tests
 type Server interface {
@@ -62,11 +79,14 @@ func NewServer(config *Config, backend Backend) Server {
 // NewServerWithLogger creates a new Server for the passed in configuration. The server will have to be started
 // afterwards, which is when configuration options are checked.
 func NewServerWithLogger(config *Config, backend Backend, logger *slog.Logger) Server {
+	// only trust client IP headers set by our own proxies (config is validated so errors are ignored)
+	trustedIPs, trustedNets, _ := config.ParseTrustedProxies()
+
 	router := chi.NewRouter()
 	router.Use(middleware.Compress(flate.DefaultCompression))
 	router.Use(middleware.StripSlashes)
 	router.Use(middleware.RequestID)
-	router.Use(middleware.RealIP)
+	router.Use(trustedRealIP(trustedIPs, trustedNets))
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.Timeout(30 * time.Second))
 
@@ -90,6 +110,9 @@ func NewServerWithLogger(config *Config, backend Backend, logger *slog.Logger) S
 // if it encounters any unrecoverable (or ignorable) error, though its bias is to move forward despite
 // connection errors
 func (s *server) Start() error {
+	// limit which env vars and files can be referenced as secrets in channel config
+	secrets.Configure(s.config.SecretsEnvPrefix, s.config.SecretsDir)
+
 	// start our backend
 	err := s.backend.Start()
 	if err != nil {
@@ -104,7 +127,12 @@ func (s *server) Start() error {
 	s.router.MethodNotAllowed(s.handle405)
 	s.router.Get("/", s.handleIndex)
 	s.router.Get("/status", s.basicAuthRequired(s.handleStatus))
-	s.publicRouter.Post("/_fetch-attachment", s.tokenAuthRequired(s.handleFetchAttachment)) // becomes /c/_fetch-attachment
+	s.publicRouter.Post("/_fetch-attachment", s.tokenAuthRequired(s.handleFetchAttachment))     // becomes /c/_fetch-attachment
+	s.publicRouter.Post("/_logs", s.tokenAuthRequired(s.handleFetchLogs))                       // becomes /c/_logs
+	s.publicRouter.Post("/_send", s.tokenAuthRequired(s.handleSend))                            // becomes /c/_send
+	s.publicRouter.Post("/_validate-channel", s.tokenAuthRequired(s.handleValidateChannel))     // becomes /c/_validate-channel
+	s.publicRouter.Post("/_replay", s.tokenAuthRequired(s.handleReplay))                        // becomes /c/_replay
+	s.publicRouter.Post("/_invalidate-channel", s.tokenAuthRequired(s.handleInvalidateChannel)) // becomes /c/_invalidate-channel
 
 	// initialize our handlers
 	s.initializeChannelHandlers()
@@ -136,6 +164,11 @@ func (s *server) Start() error {
 		"version", s.config.Version,
 	)
 
+	// start our workers for requests queued by handlers which receive asynchronously
+	if s.config.IncomingWorkers > 0 {
+		s.startIncomingWorkers()
+	}
+
 	// start our foreman for outgoing messages
 	s.foreman = NewForeman(s, s.config.MaxWorkers)
 	s.foreman.Start()
@@ -234,6 +267,13 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 		// add a 30 second timeout to the request
 		ctx, cancel := context.WithTimeout(baseCtx, time.Second*30)
 		defer cancel()
+
+		ctx, span := tracing.Tracer().Start(ctx, "receive", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			tracing.AttrChannelType.String(string(handler.ChannelType())),
+			semconv.URLPath(r.URL.Path),
+		))
+		defer span.End()
+
 		r = r.WithContext(ctx)
 
 		recorder, err := httpx.NewRecorder(r, w, true)
@@ -252,6 +292,51 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 		var channelUUID ChannelUUID
 		if channel != nil {
 			channelUUID = channel.UUID()
+			span.SetAttributes(tracing.AttrChannelUUID.String(string(channelUUID)))
+		}
+
+		// requests being reprocessed from our queue or archive have already been archived and shouldn't be queued again
+		reprocessing := IsReprocessing(r.Context())
+
+		// if the channel restricts where requests can come from, reject anything from elsewhere
+		if channel != nil && !reprocessing {
+			if err := checkAllowedNetworks(channel, r); err != nil {
+				slog.Warn("rejected incoming request", "error", err, "channel_uuid", channelUUID, "remote_addr", r.RemoteAddr, "url", r.URL.String())
+				WriteDataResponse(w, http.StatusForbidden, "Forbidden", []any{NewErrorData(err.Error())})
+				span.SetAttributes(semconv.HTTPResponseStatusCode(http.StatusForbidden))
+				return
+			}
+		}
+
+		var raw *rawRequest
+		if channel != nil && !reprocessing && (s.config.ArchiveIncomingHours > 0 || s.config.IncomingWorkers > 0) {
+			if raw, err = newRawRequest(r); err != nil {
+				writeAndLogRequestError(ctx, handler, recorder.ResponseWriter, r, channel, err)
+				return
+			}
+		}
+
+		// archives the raw request so that it can be replayed later if necessary, which we only do once it's accepted
+		archive := func() {
+			if raw != nil && s.config.ArchiveIncomingHours > 0 {
+				if err := archiveIncoming(s.backend.RedisPool(), channel, raw.redacted(handler.RedactValues(channel)), time.Duration(s.config.ArchiveIncomingHours)*time.Hour); err != nil {
+					slog.Error("error archiving incoming request", "error", err, "channel_uuid", channelUUID)
+				}
+			}
+		}
+
+		// if the handler wants this request processed in the background, queue it and acknowledge it immediately
+		if raw != nil && s.config.IncomingWorkers > 0 {
+			if ar, ok := handler.(AsyncReceiver); ok && ar.ReceiveAsync(ctx, channel, r) {
+				if err := queueIncoming(s.backend.RedisPool(), raw); err != nil {
+					slog.Error("error queuing incoming request, handling synchronously", "error", err, "channel_uuid", channelUUID)
+				} else {
+					archive()
+					WriteDataResponse(w, http.StatusOK, "Accepted", []any{NewInfoData("request queued for processing")})
+					span.SetAttributes(semconv.HTTPResponseStatusCode(http.StatusOK))
+					return
+				}
+			}
 		}
 
 		defer func() {
@@ -265,13 +350,25 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 		}()
 
 		clog := NewChannelLogForIncoming(logType, channel, recorder, handler.RedactValues(channel))
+		clog.span = span
 
 		events, hErr := handlerFunc(ctx, channel, recorder.ResponseWriter, r, clog)
 
+		// handlers which write a single event return the backend's error if it's ignored, e.g. a channel event from a
+		// blocked URN, which we acknowledge rather than report as an error so that the provider doesn't retry it
+		if channel != nil && errors.Is(hErr, ErrMsgIgnored) {
+			LogRequestIgnored(r, channel, hErr.Error())
+			handler.WriteRequestIgnored(ctx, recorder.ResponseWriter, hErr.Error())
+			hErr = nil
+		}
+
 		// if we received an error, write it out and report it
 		if hErr != nil {
 			slog.Error("error handling request", "error", err, "channel_uuid", channelUUID, "request", recorder.Trace.RequestTrace)
 			writeAndLogRequestError(ctx, handler, recorder.ResponseWriter, r, channel, hErr)
+
+			span.RecordError(hErr)
+			span.SetStatus(codes.Error, "error handling request")
 		}
 
 		// end recording of the request so that we have a response trace
@@ -280,16 +377,29 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 			writeAndLogRequestError(ctx, handler, w, r, channel, err)
 		}
 
+		span.SetAttributes(tracing.AttrEvents.Int(len(events)))
+		if recorder.Trace.Response != nil {
+			span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.Trace.Response.StatusCode))
+
+			if hErr == nil && recorder.Trace.Response.StatusCode/100 == 2 {
+				archive()
+			}
+		}
+
 		if channel != nil {
 			for _, event := range events {
 				switch e := event.(type) {
 				case MsgIn:
 					clog.SetAttached(true)
+					clog.RedactMsgPII(e.URN(), e.Text(), e.Attachments())
 					LogMsgReceived(r, e)
 				case StatusUpdate:
 					clog.SetAttached(true)
+					oldURN, newURN := e.URNUpdate()
+					clog.RedactPII(oldURN.Path(), newURN.Path())
 					LogMsgStatusReceived(r, e)
 				case ChannelEvent:
+					clog.RedactPII(e.URN().Path())
 					LogChannelEventReceived(r, e)
 				}
 			}
@@ -369,6 +479,86 @@ func (s *server) handleFetchAttachment(w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonx.MustMarshal(resp))
 }
 
+func (s *server) handleFetchLogs(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	resp, err := fetchLogs(ctx, s.backend, r)
+	if err != nil {
+		slog.Error("error fetching logs", "error", err)
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonx.MustMarshal(resp))
+}
+
+func (s *server) handleSend(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*35)
+	defer cancel()
+
+	resp, err := sendTestMsg(ctx, s, r)
+	if err != nil {
+		slog.Error("error sending test message", "error", err)
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonx.MustMarshal(resp))
+}
+
+func (s *server) handleValidateChannel(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	resp, err := validateChannel(ctx, s.backend, r)
+	if err != nil {
+		slog.Error("error validating channel", "error", err)
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonx.MustMarshal(resp))
+}
+
+func (s *server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+	defer cancel()
+
+	resp, err := replayIncoming(ctx, s, r)
+	if err != nil {
+		slog.Error("error replaying requests", "error", err)
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonx.MustMarshal(resp))
+}
+
+func (s *server) handleInvalidateChannel(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	resp, err := invalidateChannel(ctx, s.backend, r)
+	if err != nil {
+		slog.Error("error invalidating channel", "error", err)
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonx.MustMarshal(resp))
+}
+
 func (s *server) handle404(w http.ResponseWriter, r *http.Request) {
 	slog.Info("not found", "url", r.URL.String(), "method", r.Method, "resp_status", "404")
 	errors := []any{NewErrorData(fmt.Sprintf("not found: %s", r.URL.String()))}
@@ -2,6 +2,7 @@ package courier_test
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
@@ -9,11 +10,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gomodule/redigo/redis"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/test"
 	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/gocommon/uuids"
 	"github.com/stretchr/testify/assert"
@@ -116,6 +119,39 @@ func TestIncoming(t *testing.T) {
 	assert.Len(t, clog.HttpLogs, 1)
 }
 
+func TestIncomingIgnored(t *testing.T) {
+	mb := test.NewMockBackend()
+	mb.BlockURN("tel:2065551212")
+
+	s := courier.NewServer(testConfig(), mb)
+	s.Start()
+	defer s.Stop()
+
+	// wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+
+	get := func(url string) (int, string) {
+		resp, err := http.Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// events from blocked URNs are acknowledged as ignored rather than errors so that they aren't retried
+	status, body := get("http://localhost:8081/c/mck/e4bb1578-29da-4fa5-a214-9da19dd24230/event?from=2065551212")
+	assert.Equal(t, 200, status)
+	assert.Contains(t, body, "Ignored")
+	assert.Contains(t, body, "URN is blocked on this channel")
+	assert.Len(t, mb.WrittenChannelEvents(), 0)
+
+	// events from other URNs are written
+	status, body = get("http://localhost:8081/c/mck/e4bb1578-29da-4fa5-a214-9da19dd24230/event?from=2065551313")
+	assert.Equal(t, 200, status)
+	assert.Contains(t, body, "Event Accepted")
+	assert.Len(t, mb.WrittenChannelEvents(), 1)
+}
+
 func TestOutgoing(t *testing.T) {
 	defer httpx.SetRequestor(httpx.DefaultRequestor)
 	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
@@ -125,6 +161,9 @@ func TestOutgoing(t *testing.T) {
 			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
 			httpx.NewMockResponse(429, nil, []byte(`too much!`)),
 			httpx.NewMockResponse(403, nil, []byte(`stop!`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
 		},
 	}))
 
@@ -221,6 +260,61 @@ func TestOutgoing(t *testing.T) {
 	assert.Equal(t, 1, len(mb.WrittenChannelEvents()))
 	assert.Equal(t, courier.EventTypeStopContact, mb.WrittenChannelEvents()[0].EventType())
 	mb.Reset()
+
+	// send message which channel asks us to retry later
+	msg = test.NewMockMsg(courier.MsgID(108), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "err:retry", nil)
+	mb.PushOutgoingMsg(msg)
+	time.Sleep(time.Millisecond * 500)
+
+	// message should be deferred rather than given a status, but we still have a log of the attempt
+	assert.Len(t, mb.WrittenMsgStatuses(), 0)
+	if assert.Len(t, mb.DeferredMsgs(), 1) {
+		assert.Equal(t, msg, mb.DeferredMsgs()[0].Msg)
+		assert.WithinDuration(t, time.Now().Add(30*time.Second), mb.DeferredMsgs()[0].Until, time.Second)
+	}
+	assert.Len(t, mb.WrittenChannelLogs(), 1)
+	assert.Equal(t, []*clogs.LogError{clogs.NewLogError("seeds", "", "contains ********** seeds"), clogs.NewLogError("retry_after", "", "Channel asked for send to be retried after 30s.")}, mb.WrittenChannelLogs()[0].Errors)
+
+	sent, err := mb.WasMsgSent(context.Background(), msg.ID())
+	assert.NoError(t, err)
+	assert.False(t, sent)
+	mb.Reset()
+
+	// if the message can't be deferred, it's errored instead so that it's retried like any other failed send
+	mb.SetErrorOnDefer(true)
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(109), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "err:retry", nil))
+	mb.SetErrorOnDefer(false)
+
+	assert.Len(t, mb.DeferredMsgs(), 0)
+	if assert.Len(t, mb.WrittenMsgStatuses(), 1) {
+		assert.Equal(t, courier.MsgStatusErrored, mb.WrittenMsgStatuses()[0].Status())
+	}
+	if assert.Len(t, mb.WrittenChannelLogs(), 1) {
+		assert.Equal(t, "msg_not_deferred", mb.WrittenChannelLogs()[0].Errors[2].Code)
+	}
+	mb.Reset()
+
+	// and if part of the message was already sent, it's not deferred because that part would be sent again
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(110), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "err:retry_partial", nil))
+
+	assert.Len(t, mb.DeferredMsgs(), 0)
+	if assert.Len(t, mb.WrittenMsgStatuses(), 1) {
+		assert.Equal(t, courier.MsgStatusErrored, mb.WrittenMsgStatuses()[0].Status())
+		assert.Equal(t, "ext1", mb.WrittenMsgStatuses()[0].ExternalID())
+	}
+	mb.Reset()
+
+	// send message which expired while it was queued
+	expiresOn := time.Now().Add(-time.Minute)
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(107), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "your code is 1234", nil).WithExpiresOn(expiresOn))
+
+	// message should be marked as failed without being sent
+	assert.Equal(t, 1, len(mb.WrittenMsgStatuses()))
+	assert.Equal(t, courier.MsgStatusFailed, mb.WrittenMsgStatuses()[0].Status())
+	assert.Equal(t, 1, len(mb.WrittenChannelLogs()))
+	assert.Equal(t, []*clogs.LogError{courier.ErrorMsgExpired(expiresOn)}, mb.WrittenChannelLogs()[0].Errors)
+	assert.Len(t, mb.WrittenChannelLogs()[0].HttpLogs, 0)
+	mb.Reset()
 }
 
 func TestFetchAttachment(t *testing.T) {
@@ -312,6 +406,297 @@ func TestFetchAttachment(t *testing.T) {
 	assert.JSONEq(t, `{"attachment": {"content_type": "unavailable", "url": "http://mock.com/media/hello.pdf", "size": 0}, "log_uuid": "0191e180-8530-7000-8ef6-384876655d1b"}`, string(respBody))
 }
 
+func TestFetchLogs(t *testing.T) {
+	httpMocks := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://mock.com/send?key=sesame": {httpx.NewMockResponse(200, nil, []byte(`OK`))},
+	})
+	httpMocks.SetIgnoreLocal(true)
+
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(httpMocks)
+
+	logger := slog.Default()
+	config := courier.NewDefaultConfig()
+	config.AuthToken = "sesame"
+	config.Port = 8081
+
+	mb := test.NewMockBackend()
+	mockChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	mb.AddChannel(mockChannel)
+
+	// write a log which has an unredacted secret in it
+	clog1 := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, mockChannel, nil)
+	req, _ := httpx.NewRequest("GET", "http://mock.com/send?key=sesame", nil, nil)
+	trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, -1)
+	require.NoError(t, err)
+	clog1.HTTP(trace)
+	clog1.Error(clogs.NewLogError("code1", "", "bad key sesame"))
+	clog1.End()
+	mb.WriteChannelLog(context.Background(), clog1)
+
+	clog2 := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, mockChannel, nil)
+	clog2.End()
+	mb.WriteChannelLog(context.Background(), clog2)
+
+	server := courier.NewServerWithLogger(config, mb, logger)
+	server.Start()
+	defer server.Stop()
+
+	// wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+
+	submit := func(body, authToken string) (int, []byte) {
+		req, _ := http.NewRequest("POST", "http://localhost:8081/c/_logs", strings.NewReader(body))
+		if authToken != "" {
+			req.Header.Set("Authorization", "Bearer "+authToken)
+		}
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode, trace.ResponseBody
+	}
+
+	// try to submit with wrong auth header
+	statusCode, respBody := submit(`{}`, "23462")
+	assert.Equal(t, 401, statusCode)
+	assert.Equal(t, "Unauthorized", string(respBody))
+
+	// try to submit with no query
+	statusCode, respBody = submit(`{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "channel_type": "MCK"}`, "sesame")
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, string(respBody), `must provide log_uuids, msg_id or after`)
+
+	// try to submit with non-existent channel
+	statusCode, respBody = submit(`{"channel_uuid": "c25aab53-f23a-46c9-8ae3-1af850ad9fd9", "channel_type": "VV", "after": "2024-01-01T00:00:00Z"}`, "sesame")
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, string(respBody), `channel not found`)
+
+	// fetch by UUID
+	statusCode, respBody = submit(fmt.Sprintf(`{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "channel_type": "MCK", "log_uuids": ["%s"]}`, clog1.UUID), "sesame")
+	assert.Equal(t, 200, statusCode)
+
+	resp := &struct {
+		Logs []struct {
+			UUID     clogs.LogUUID     `json:"uuid"`
+			Type     clogs.LogType     `json:"type"`
+			HttpLogs []*httpx.Log      `json:"http_logs"`
+			Errors   []*clogs.LogError `json:"errors"`
+		} `json:"logs"`
+	}{}
+	jsonx.MustUnmarshal(respBody, resp)
+
+	if assert.Len(t, resp.Logs, 1) {
+		assert.Equal(t, clog1.UUID, resp.Logs[0].UUID)
+		assert.Equal(t, courier.ChannelLogTypeMsgSend, resp.Logs[0].Type)
+		assert.Equal(t, "http://mock.com/send?key=**********", resp.Logs[0].HttpLogs[0].URL)
+		assert.Equal(t, []*clogs.LogError{clogs.NewLogError("code1", "", "bad key **********")}, resp.Logs[0].Errors)
+	}
+
+	// fetch by time range, newest first
+	statusCode, respBody = submit(`{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "channel_type": "MCK", "after": "2024-01-01T00:00:00Z"}`, "sesame")
+	assert.Equal(t, 200, statusCode)
+	jsonx.MustUnmarshal(respBody, resp)
+
+	if assert.Len(t, resp.Logs, 2) {
+		assert.Equal(t, clog2.UUID, resp.Logs[0].UUID)
+		assert.Equal(t, clog1.UUID, resp.Logs[1].UUID)
+	}
+
+	// with a limit
+	statusCode, respBody = submit(`{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "channel_type": "MCK", "after": "2024-01-01T00:00:00Z", "limit": 1}`, "sesame")
+	assert.Equal(t, 200, statusCode)
+	jsonx.MustUnmarshal(respBody, resp)
+	assert.Len(t, resp.Logs, 1)
+}
+
+func TestSend(t *testing.T) {
+	httpMocks := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://mock.com/send": {
+			httpx.NewMockResponse(200, nil, []byte(`OK`)),
+			httpx.NewMockResponse(403, nil, []byte(`Stopped`)),
+		},
+	})
+	httpMocks.SetIgnoreLocal(true)
+
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(httpMocks)
+
+	logger := slog.Default()
+	config := courier.NewDefaultConfig()
+	config.AuthToken = "sesame"
+	config.Port = 8081
+
+	mb := test.NewMockBackend()
+	mb.AddChannel(test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{}))
+
+	server := courier.NewServerWithLogger(config, mb, logger)
+	server.Start()
+	defer server.Stop()
+
+	// wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+
+	submit := func(body, authToken string) (int, []byte) {
+		req, _ := http.NewRequest("POST", "http://localhost:8081/c/_send", strings.NewReader(body))
+		if authToken != "" {
+			req.Header.Set("Authorization", "Bearer "+authToken)
+		}
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode, trace.ResponseBody
+	}
+
+	// try to submit with wrong auth header
+	statusCode, respBody := submit(`{}`, "23462")
+	assert.Equal(t, 401, statusCode)
+	assert.Equal(t, "Unauthorized", string(respBody))
+
+	// try to submit with no content
+	statusCode, respBody = submit(`{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "urn": "tel:+12065551212"}`, "sesame")
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, string(respBody), `must provide text, attachments or templating`)
+
+	// try to submit with invalid URN
+	statusCode, respBody = submit(`{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "urn": "xyz:123", "text": "Hi"}`, "sesame")
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, string(respBody), `invalid URN`)
+
+	// try to submit with non-existent channel
+	statusCode, respBody = submit(`{"channel_uuid": "c25aab53-f23a-46c9-8ae3-1af850ad9fd9", "urn": "tel:+12065551212", "text": "Hi"}`, "sesame")
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, string(respBody), `channel not found`)
+
+	resp := &struct {
+		Status      courier.MsgStatus `json:"status"`
+		ExternalIDs []string          `json:"external_ids"`
+		Log         struct {
+			Type     clogs.LogType     `json:"type"`
+			HttpLogs []*httpx.Log      `json:"http_logs"`
+			Errors   []*clogs.LogError `json:"errors"`
+		} `json:"log"`
+	}{}
+
+	// a successful send
+	statusCode, respBody = submit(`{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "urn": "tel:+12065551212", "text": "Hi", "quick_replies": ["Yes", "No"]}`, "sesame")
+	assert.Equal(t, 200, statusCode)
+	jsonx.MustUnmarshal(respBody, resp)
+
+	assert.Equal(t, courier.MsgStatusWired, resp.Status)
+	assert.Equal(t, []string{}, resp.ExternalIDs)
+	assert.Equal(t, courier.ChannelLogTypeMsgSend, resp.Log.Type)
+	if assert.Len(t, resp.Log.HttpLogs, 1) {
+		assert.Equal(t, "http://mock.com/send", resp.Log.HttpLogs[0].URL)
+		assert.Contains(t, resp.Log.HttpLogs[0].Request, "Authorization: Token **********")
+	}
+	assert.Equal(t, []*clogs.LogError{clogs.NewLogError("seeds", "", "contains ********** seeds")}, resp.Log.Errors)
+
+	// nothing should have been written to the backend
+	assert.Len(t, mb.WrittenMsgStatuses(), 0)
+	assert.Len(t, mb.WrittenChannelLogs(), 0)
+
+	// a send which tells us the contact has opted out
+	statusCode, respBody = submit(`{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "urn": "tel:+12065551212", "text": "Hi"}`, "sesame")
+	assert.Equal(t, 200, statusCode)
+	jsonx.MustUnmarshal(respBody, resp)
+
+	assert.Equal(t, courier.MsgStatusFailed, resp.Status)
+	assert.Equal(t, []*clogs.LogError{clogs.NewLogError("contact_stopped", "", "Contact has opted-out of messages from this channel.")}, resp.Log.Errors)
+	assert.Len(t, mb.WrittenChannelEvents(), 1)
+}
+
+func TestInvalidateChannel(t *testing.T) {
+	logger := slog.Default()
+	config := courier.NewDefaultConfig()
+	config.AuthToken = "sesame"
+	config.Port = 8081
+
+	mb := test.NewMockBackend()
+
+	server := courier.NewServerWithLogger(config, mb, logger)
+	server.Start()
+	defer server.Stop()
+
+	// wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+
+	submit := func(body string) (int, []byte) {
+		req, _ := http.NewRequest("POST", "http://localhost:8081/c/_invalidate-channel", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer sesame")
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode, trace.ResponseBody
+	}
+
+	statusCode, respBody := submit(`{"channel_address": "2020"}`)
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, string(respBody), `field 'channeluuid' required`)
+
+	statusCode, respBody = submit(`{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "channel_address": "2020"}`)
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230"}`, string(respBody))
+	assert.Equal(t, []courier.ChannelUUID{"e4bb1578-29da-4fa5-a214-9da19dd24230"}, mb.InvalidatedChannels())
+}
+
+func TestValidateChannel(t *testing.T) {
+	logger := slog.Default()
+	config := courier.NewDefaultConfig()
+	config.AuthToken = "sesame"
+	config.Port = 8081
+
+	mb := test.NewMockBackend()
+	mb.AddChannel(test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigMaxLength: "lots"}))
+
+	server := courier.NewServerWithLogger(config, mb, logger)
+	server.Start()
+	defer server.Stop()
+
+	// wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+
+	submit := func(url, body string) (int, []byte) {
+		req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer sesame")
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode, trace.ResponseBody
+	}
+
+	// try to validate for non-existent channel type
+	statusCode, respBody := submit("http://localhost:8081/c/_validate-channel", `{"channel_type": "XX", "config": {}}`)
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, string(respBody), `unknown channel type: XX`)
+
+	// validate a config for a new channel
+	statusCode, respBody = submit("http://localhost:8081/c/_validate-channel", `{"channel_type": "MCK", "config": {"max_length": 160}}`)
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"valid": true, "errors": [], "schema": [{"key": "max_length", "type": "int", "required": false}]}`, string(respBody))
+
+	statusCode, respBody = submit("http://localhost:8081/c/_validate-channel", `{"channel_type": "MCK", "config": {"max_length": true}}`)
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"valid": false, "errors": [{"key": "max_length", "message": "must be a valid int"}], "schema": [{"key": "max_length", "type": "int", "required": false}]}`, string(respBody))
+
+	// validate the config of an existing channel
+	statusCode, respBody = submit("http://localhost:8081/c/_validate-channel", `{"channel_type": "MCK", "channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230"}`)
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"valid": false, "errors": [{"key": "max_length", "message": "must be a valid int"}], "schema": [{"key": "max_length", "type": "int", "required": false}]}`, string(respBody))
+
+	// sending via a channel with invalid config fails without calling the handler
+	statusCode, respBody = submit("http://localhost:8081/c/_send", `{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "urn": "tel:+12065551212", "text": "Hi"}`)
+	assert.Equal(t, 200, statusCode)
+
+	resp := &struct {
+		Status courier.MsgStatus `json:"status"`
+		Log    struct {
+			HttpLogs []*httpx.Log      `json:"http_logs"`
+			Errors   []*clogs.LogError `json:"errors"`
+		} `json:"log"`
+	}{}
+	jsonx.MustUnmarshal(respBody, resp)
+
+	assert.Equal(t, courier.MsgStatusFailed, resp.Status)
+	assert.Len(t, resp.Log.HttpLogs, 0)
+	assert.Equal(t, []*clogs.LogError{courier.ErrorChannelConfig("max_length", "must be a valid int")}, resp.Log.Errors)
+}
+
 // utility to send a message on a mocked backend and block until it's marked as sent
 func sendAndWait(mb *test.MockBackend, m courier.MsgOut) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
@@ -327,3 +712,418 @@ func sendAndWait(mb *test.MockBackend, m courier.MsgOut) {
 		}
 	}
 }
+
+// a handler whose sends block until they're cancelled
+type blockingHandler struct {
+	courier.ChannelHandler
+	sending chan bool
+}
+
+func (h *blockingHandler) ChannelType() courier.ChannelType  { return courier.ChannelType("BLK") }
+func (h *blockingHandler) Initialize(s courier.Server) error { return nil }
+func (h *blockingHandler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
+	h.sending <- true
+	<-ctx.Done()
+	return courier.ErrConnectionFailed
+}
+
+func TestQuietHoursDeferral(t *testing.T) {
+	mb := test.NewMockBackend()
+	s := courier.NewServer(testConfig(), mb)
+
+	s.Start()
+	defer s.Stop()
+
+	// give channel a quiet hours window which we're currently in the middle of
+	now := time.Now().UTC()
+	quietHours := fmt.Sprintf("%s-%s", now.Add(-time.Hour).Format("15:04"), now.Add(time.Hour).Format("15:04"))
+	channel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigQuietHours: quietHours})
+	mb.AddChannel(channel)
+
+	// a chat message is still sent
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(101), courier.NilMsgUUID, channel, "tel:+250788383383", "hi", nil).WithOrigin(courier.MsgOriginChat))
+	assert.Len(t, mb.WrittenMsgStatuses(), 1)
+	assert.Len(t, mb.DeferredMsgs(), 0)
+	mb.Reset()
+
+	// but a broadcast message is deferred until quiet hours end
+	msg := test.NewMockMsg(courier.MsgID(102), courier.NilMsgUUID, channel, "tel:+250788383383", "buy now", nil).WithOrigin(courier.MsgOriginBroadcast)
+	mb.PushOutgoingMsg(msg)
+	time.Sleep(time.Millisecond * 500)
+
+	assert.Len(t, mb.WrittenMsgStatuses(), 0)
+	if assert.Len(t, mb.DeferredMsgs(), 1) {
+		assert.Equal(t, msg, mb.DeferredMsgs()[0].Msg)
+		assert.True(t, mb.DeferredMsgs()[0].Until.After(now))
+	}
+	mb.Reset()
+
+	// and if it can't be deferred, it's errored instead of being lost
+	mb.SetErrorOnDefer(true)
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(103), courier.NilMsgUUID, channel, "tel:+250788383383", "buy now", nil).WithOrigin(courier.MsgOriginBroadcast))
+
+	assert.Len(t, mb.DeferredMsgs(), 0)
+	if assert.Len(t, mb.WrittenMsgStatuses(), 1) {
+		assert.Equal(t, courier.MsgStatusErrored, mb.WrittenMsgStatuses()[0].Status())
+	}
+	if assert.Len(t, mb.WrittenChannelLogs(), 1) {
+		assert.Equal(t, "msg_not_deferred", mb.WrittenChannelLogs()[0].Errors[0].Code)
+		assert.Len(t, mb.WrittenChannelLogs()[0].HttpLogs, 0)
+	}
+}
+
+func TestDrainOnStop(t *testing.T) {
+	handler := &blockingHandler{ChannelHandler: test.NewMockHandler(), sending: make(chan bool, 1)}
+	courier.RegisterHandler(handler)
+
+	config := testConfig()
+	config.MaxWorkers = 1
+	config.DrainTimeout = 1
+
+	mb := test.NewMockBackend()
+	channel := test.NewMockChannel("5a3e4cbc-66a4-4b2a-a4ea-cf2ffc4baf09", "BLK", "2020", "US", []string{urns.Phone.Prefix}, nil)
+	mb.AddChannel(channel)
+
+	msg1 := test.NewMockMsg(courier.MsgID(101), courier.NilMsgUUID, channel, "tel:+250788383383", "hi", nil)
+	msg2 := test.NewMockMsg(courier.MsgID(102), courier.NilMsgUUID, channel, "tel:+250788383383", "there", nil)
+	mb.PushOutgoingMsg(msg1)
+
+	s := courier.NewServer(config, mb)
+	s.Start()
+
+	// wait for our only sender to be busy with the first message, and then queue another
+	<-handler.sending
+	mb.PushOutgoingMsg(msg2)
+
+	start := time.Now()
+	s.Stop()
+	elapsed := time.Since(start)
+
+	// in-flight send should have been cancelled at the drain deadline and marked as errored so it can be retried
+	assert.GreaterOrEqual(t, elapsed, time.Second)
+	assert.Less(t, elapsed, 3*time.Second)
+	assert.Len(t, mb.WrittenMsgStatuses(), 1)
+	assert.Equal(t, courier.MsgID(101), mb.WrittenMsgStatuses()[0].MsgID())
+	assert.Equal(t, courier.MsgStatusErrored, mb.WrittenMsgStatuses()[0].Status())
+
+	// second message should never have been popped or should have been put back
+	next, err := mb.PopNextOutgoingMsg(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, msg2, next)
+}
+
+// a handler which receives requests with a from param asynchronously
+type asyncHandler struct {
+	courier.ChannelHandler
+	backend courier.Backend
+}
+
+func (h *asyncHandler) ChannelType() courier.ChannelType { return courier.ChannelType("ASY") }
+
+func (h *asyncHandler) Initialize(s courier.Server) error {
+	h.backend = s.Backend()
+	s.AddHandlerRoute(h, http.MethodGet, "receive", courier.ChannelLogTypeMsgReceive, h.receive)
+	return nil
+}
+
+func (h *asyncHandler) GetChannel(ctx context.Context, r *http.Request) (courier.Channel, error) {
+	return h.backend.GetChannel(ctx, h.ChannelType(), courier.ChannelUUID(r.PathValue("uuid")))
+}
+
+func (h *asyncHandler) ReceiveAsync(ctx context.Context, ch courier.Channel, r *http.Request) bool {
+	return r.URL.Query().Get("from") != ""
+}
+
+func (h *asyncHandler) receive(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	from, text := r.URL.Query().Get("from"), r.URL.Query().Get("text")
+	if from == "" {
+		return nil, courier.WriteError(w, http.StatusBadRequest, fmt.Errorf("missing from"))
+	}
+
+	msg := h.backend.NewIncomingMsg(channel, urns.URN("tel:"+from), text, "", clog)
+	h.backend.WriteMsg(ctx, msg, clog)
+	return []courier.Event{msg}, courier.WriteMsgSuccess(w, []courier.MsgIn{msg})
+}
+
+func TestAsyncReceive(t *testing.T) {
+	courier.RegisterHandler(&asyncHandler{ChannelHandler: test.NewMockHandler()})
+
+	config := testConfig()
+	config.IncomingWorkers = 1
+
+	mb := test.NewMockBackend()
+	mb.AddChannel(test.NewMockChannel("5ce35a2c-7a8d-4ab6-a6b4-8f3f9e59f8c5", "ASY", "2020", "US", []string{urns.Phone.Prefix}, nil))
+
+	s := courier.NewServer(config, mb)
+	s.Start()
+	defer s.Stop()
+
+	// wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+
+	get := func(url string) (int, string) {
+		resp, err := http.Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// request that the handler doesn't want handled asynchronously gets the handler's response
+	status, body := get("http://localhost:8081/c/asy/5ce35a2c-7a8d-4ab6-a6b4-8f3f9e59f8c5/receive")
+	assert.Equal(t, 400, status)
+	assert.Contains(t, body, "missing from")
+	assert.Len(t, mb.WrittenChannelLogs(), 1)
+
+	mb.Reset()
+
+	// request that is handled asynchronously gets acknowledged immediately
+	status, body = get("http://localhost:8081/c/asy/5ce35a2c-7a8d-4ab6-a6b4-8f3f9e59f8c5/receive?from=%2B12065551212&text=hello")
+	assert.Equal(t, 200, status)
+	assert.Contains(t, body, "request queued for processing")
+
+	// and then processed by a worker
+	assert.Eventually(t, func() bool { return len(mb.WrittenChannelLogs()) == 1 }, 2*time.Second, 50*time.Millisecond)
+
+	require.Len(t, mb.WrittenMsgs(), 1)
+	assert.Equal(t, "hello", mb.WrittenMsgs()[0].Text())
+	assert.Equal(t, urns.URN("tel:+12065551212"), mb.WrittenMsgs()[0].URN())
+
+	clog := mb.WrittenChannelLogs()[0]
+	assert.Equal(t, courier.ChannelLogTypeMsgReceive, clog.Type)
+	require.Len(t, clog.HttpLogs, 1)
+	assert.Equal(t, 200, clog.HttpLogs[0].StatusCode)
+	assert.Equal(t, "http://localhost:8081/c/asy/5ce35a2c-7a8d-4ab6-a6b4-8f3f9e59f8c5/receive?from=%2B12065551212&text=hello", clog.HttpLogs[0].URL)
+
+	mb.Reset()
+
+	// simulate requests leased by an instance which died, one whose lease has expired and one whose lease hasn't
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	leased := func(text string) string {
+		return fmt.Sprintf(`{"method":"GET","url":"http://localhost:8081/c/asy/5ce35a2c-7a8d-4ab6-a6b4-8f3f9e59f8c5/receive?from=%%2B12065551212&text=%s","header":{},"body":null,"remote_addr":"127.0.0.1:1234","received_on":"2024-09-11T14:33:00Z"}`, text)
+	}
+	_, err := rc.Do("ZADD", "incoming:pending", time.Now().Add(-time.Second).UnixMilli(), leased("expired"))
+	require.NoError(t, err)
+	_, err = rc.Do("ZADD", "incoming:pending", time.Now().Add(time.Minute).UnixMilli(), leased("active"))
+	require.NoError(t, err)
+
+	// request with the expired lease is reclaimed and processed
+	assert.Eventually(t, func() bool { return len(mb.WrittenMsgs()) == 1 }, 2*time.Second, 50*time.Millisecond)
+	assert.Equal(t, "expired", mb.WrittenMsgs()[0].Text())
+
+	// and the other is left alone until its lease expires
+	time.Sleep(500 * time.Millisecond)
+	assert.Len(t, mb.WrittenMsgs(), 1)
+
+	pending, err := redis.Strings(rc.Do("ZRANGE", "incoming:pending", 0, -1))
+	require.NoError(t, err)
+	assert.Equal(t, []string{leased("active")}, pending)
+
+	// simulate a request which has already been claimed too many times without completing
+	_, err = rc.Do("ZADD", "incoming:pending", time.Now().Add(-time.Second).UnixMilli(), leased("crashy"))
+	require.NoError(t, err)
+	_, err = rc.Do("HSET", "incoming:attempts", leased("crashy"), 3)
+	require.NoError(t, err)
+
+	// it's not processed again but moved to the dead list
+	assert.Eventually(t, func() bool {
+		dead, _ := redis.Strings(rc.Do("LRANGE", "incoming:dead", 0, -1))
+		return len(dead) == 1 && dead[0] == leased("crashy")
+	}, 2*time.Second, 50*time.Millisecond)
+	assert.Len(t, mb.WrittenMsgs(), 1)
+
+	pending, err = redis.Strings(rc.Do("ZRANGE", "incoming:pending", 0, -1))
+	require.NoError(t, err)
+	assert.Equal(t, []string{leased("active")}, pending)
+
+	attempts, err := redis.IntMap(rc.Do("HGETALL", "incoming:attempts"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{}, attempts)
+
+	rc.Do("DEL", "incoming:pending", "incoming:attempts", "incoming:dead")
+}
+
+func TestReplayIncoming(t *testing.T) {
+	config := testConfig()
+	config.AuthToken = "sesame"
+	config.ArchiveIncomingHours = 24
+
+	mb := test.NewMockBackend()
+	mb.AddChannel(test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, nil))
+
+	s := courier.NewServer(config, mb)
+	s.Start()
+	defer s.Stop()
+
+	// wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+
+	receive := func(text string) int {
+		req, _ := http.NewRequest("GET", "http://localhost:8081/c/mck/e4bb1578-29da-4fa5-a214-9da19dd24230/receive?from=%2B12065551212&text="+text, nil)
+		req.Header.Set("Authorization", "Bearer 123456")
+		req.Header.Set("X-Token", "open-sesame") // contains a redact value of the mock handler
+		req.Header.Set("X-Other", "hello")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	replay := func(body string) (int, string) {
+		req, _ := http.NewRequest("POST", "http://localhost:8081/c/_replay", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer sesame")
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode, string(trace.ResponseBody)
+	}
+
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	assert.Equal(t, 200, receive("one"))
+	time.Sleep(10 * time.Millisecond)
+	t1 := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 200, receive("two"))
+	assert.Equal(t, 400, receive("")) // rejected by handler
+
+	assert.Len(t, mb.WrittenMsgs(), 2)
+	mb.Reset()
+
+	// only accepted requests are archived and secret headers are redacted
+	archived, err := redis.Strings(rc.Do("ZRANGE", "incoming:archive:e4bb1578-29da-4fa5-a214-9da19dd24230", 0, -1))
+	require.NoError(t, err)
+	require.Len(t, archived, 2)
+	assert.Contains(t, archived[0], `"Authorization":["**********"]`)
+	assert.Contains(t, archived[0], `"X-Token":["**********"]`)
+	assert.Contains(t, archived[0], `"X-Other":["hello"]`)
+	assert.NotContains(t, archived[0], "123456")
+	assert.NotContains(t, archived[0], "sesame")
+
+	// requires a valid channel UUID
+	status, body := replay(`{}`)
+	assert.Equal(t, 400, status)
+	assert.Contains(t, body, "field 'channeluuid' required")
+
+	// nothing archived for other channels
+	status, body = replay(`{"channel_uuid": "8eb23e93-5ecb-45ba-b726-3b064e0c568c"}`)
+	assert.Equal(t, 200, status)
+	assert.JSONEq(t, `{"replayed": []}`, body)
+
+	// replay requests received before our timestamp
+	status, body = replay(fmt.Sprintf(`{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "before": "%s"}`, t1.Format(time.RFC3339Nano)))
+	assert.Equal(t, 200, status)
+	assert.Contains(t, body, `"url":"/c/mck/e4bb1578-29da-4fa5-a214-9da19dd24230/receive?from=%2B12065551212&text=one","received_on":`)
+	assert.Contains(t, body, `"status":200`)
+
+	require.Len(t, mb.WrittenMsgs(), 1)
+	assert.Equal(t, "one", mb.WrittenMsgs()[0].Text())
+	assert.Len(t, mb.WrittenChannelLogs(), 1)
+
+	mb.Reset()
+
+	// replay everything, replayed requests aren't archived again
+	status, body = replay(`{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230"}`)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, 2, strings.Count(body, `"status":200`))
+
+	require.Len(t, mb.WrittenMsgs(), 2)
+	assert.Equal(t, "one", mb.WrittenMsgs()[0].Text())
+	assert.Equal(t, "two", mb.WrittenMsgs()[1].Text())
+}
+
+func TestAllowedNetworks(t *testing.T) {
+	courier.RegisterHandler(&asyncHandler{ChannelHandler: test.NewMockHandler()})
+
+	channel := test.NewMockChannel("5ce35a2c-7a8d-4ab6-a6b4-8f3f9e59f8c5", "ASY", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+
+	mb := test.NewMockBackend()
+	mb.AddChannel(channel)
+
+	receiveWithHeader := func(header, value string) (int, string) {
+		req, _ := http.NewRequest("GET", "http://localhost:8081/c/asy/5ce35a2c-7a8d-4ab6-a6b4-8f3f9e59f8c5/receive?from=%2B12065551212&text=hello", nil)
+		if value != "" {
+			req.Header.Set(header, value)
+		}
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode, string(trace.ResponseBody)
+	}
+	receive := func(forwardedFor string) (int, string) { return receiveWithHeader("X-Forwarded-For", forwardedFor) }
+
+	// by default we trust proxies on local and private networks
+	s := courier.NewServer(testConfig(), mb)
+	s.Start()
+
+	// wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+
+	// no restriction
+	status, _ := receive("")
+	assert.Equal(t, 200, status)
+
+	// request from an address not in the allowed list
+	channel.SetConfig(courier.ConfigAllowedNetworks, "10.0.0.0/8, 192.0.2.1")
+	status, body := receive("")
+	assert.Equal(t, 403, status)
+	assert.Contains(t, body, "not allowed for channel")
+
+	// our test client is a trusted proxy so we use the forwarded client IP
+	status, _ = receive("10.1.2.3")
+	assert.Equal(t, 200, status)
+	status, _ = receive("192.0.2.1")
+	assert.Equal(t, 200, status)
+	status, _ = receive("192.0.2.2")
+	assert.Equal(t, 403, status)
+
+	// forwarded hops are walked from the right, skipping trusted proxies, so a client can't spoof its address by
+	// sending its own X-Forwarded-For header
+	status, _ = receive("192.0.2.1, 10.2.3.4")
+	assert.Equal(t, 200, status)
+	status, _ = receive("192.0.2.1, 198.51.100.7")
+	assert.Equal(t, 403, status)
+	status, _ = receive("192.0.2.1, 198.51.100.7, 10.2.3.4")
+	assert.Equal(t, 403, status)
+	status, _ = receive("192.0.2.1, garbage, 10.2.3.4")
+	assert.Equal(t, 403, status)
+
+	// X-Real-IP is used if there's no X-Forwarded-For, but True-Client-IP is ignored
+	status, _ = receiveWithHeader("X-Real-IP", "192.0.2.1")
+	assert.Equal(t, 200, status)
+	status, _ = receiveWithHeader("True-Client-IP", "192.0.2.1")
+	assert.Equal(t, 403, status)
+
+	// config can also be a list
+	channel.SetConfig(courier.ConfigAllowedNetworks, []any{"192.0.2.2"})
+	status, _ = receive("192.0.2.2")
+	assert.Equal(t, 200, status)
+
+	// invalid config rejects everything
+	channel.SetConfig(courier.ConfigAllowedNetworks, "192.0.2")
+	status, body = receive("192.0.2.2")
+	assert.Equal(t, 403, status)
+	assert.Contains(t, body, "couldn't parse '192.0.2' as an IP address")
+
+	s.Stop()
+
+	// if we don't trust any proxies, forwarded headers are ignored
+	config := testConfig()
+	config.TrustedProxies = ""
+
+	s = courier.NewServer(config, mb)
+	s.Start()
+	defer s.Stop()
+
+	// wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+
+	channel.SetConfig(courier.ConfigAllowedNetworks, "10.0.0.0/8")
+	status, _ = receive("10.1.2.3")
+	assert.Equal(t, 403, status)
+
+	channel.SetConfig(courier.ConfigAllowedNetworks, "127.0.0.1,::1")
+	status, _ = receive("10.1.2.3")
+	assert.Equal(t, 200, status)
+}
@@ -1,6 +1,7 @@
 package courier
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +11,11 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/nyaruka/courier/utils/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // FlusherFunc defines our interface for flushers, they are handed a filename and byte blob and are expected
@@ -107,11 +113,21 @@ func newSpoolFlusher(s Server, dir string, flusherFunc FlusherFunc) *flusher {
 			return nil
 		}
 
+		_, span := tracing.Tracer().Start(context.Background(), "spool_flush", trace.WithAttributes(
+			attribute.String("courier.spool.dir", dir),
+			attribute.String("courier.spool.file", filepath.Base(filename)),
+		))
+
 		err = flusherFunc(filename, contents)
 		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "error flushing spool file")
+			span.End()
+
 			log.Error("flushing spool file", "error", err)
 			return err
 		}
+		span.End()
 		log.Info("flushed")
 
 		// we flushed, remove our file if it is still present
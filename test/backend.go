@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"sync"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	_ "github.com/lib/pq"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/gocommon/uuids"
@@ -33,14 +35,23 @@ type SavedAttachment struct {
 	Extension   string
 }
 
+type DeferredMsg struct {
+	Msg   courier.MsgOut
+	Until time.Time
+}
+
 // MockBackend is a mocked version of a backend which doesn't require a real database or cache
 type MockBackend struct {
-	channels          map[courier.ChannelUUID]courier.Channel
-	channelsByAddress map[courier.ChannelAddress]courier.Channel
-	contacts          map[urns.URN]courier.Contact
-	outgoingMsgs      []courier.MsgOut
-	media             map[string]courier.Media // url -> Media
-	errorOnQueue      bool
+	channels            map[courier.ChannelUUID]courier.Channel
+	channelsByAddress   map[courier.ChannelAddress]courier.Channel
+	contacts            map[urns.URN]courier.Contact
+	outgoingMsgs        []courier.MsgOut
+	deferredMsgs        []*DeferredMsg
+	media               map[string]courier.Media // url -> Media
+	errorOnQueue        bool
+	errorOnDefer        bool
+	blockedURNs         map[urns.URN]bool
+	invalidatedChannels []courier.ChannelUUID
 
 	mutex     sync.RWMutex
 	redisPool *redis.Pool
@@ -90,6 +101,7 @@ func NewMockBackend() *MockBackend {
 		media:             make(map[string]courier.Media),
 		sentMsgs:          make(map[courier.MsgID]bool),
 		seenExternalIDs:   make(map[string]courier.MsgUUID),
+		blockedURNs:       make(map[urns.URN]bool),
 		redisPool:         redisPool,
 	}
 }
@@ -154,6 +166,28 @@ func (mb *MockBackend) PopNextOutgoingMsg(ctx context.Context) (courier.MsgOut,
 	return nil, nil
 }
 
+// RequeueOutgoingMsg puts the given message back at the front of our outgoing messages
+func (mb *MockBackend) RequeueOutgoingMsg(ctx context.Context, msg courier.MsgOut) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.outgoingMsgs = append([]courier.MsgOut{msg}, mb.outgoingMsgs...)
+	return nil
+}
+
+// DeferOutgoingMsg records that the given message was deferred until the given time
+func (mb *MockBackend) DeferOutgoingMsg(ctx context.Context, msg courier.MsgOut, until time.Time) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	if mb.errorOnDefer {
+		return errors.New("unable to defer message")
+	}
+
+	mb.deferredMsgs = append(mb.deferredMsgs, &DeferredMsg{Msg: msg, Until: until})
+	return nil
+}
+
 // WasMsgSent returns whether the passed in msg was already sent
 func (mb *MockBackend) WasMsgSent(ctx context.Context, id courier.MsgID) (bool, error) {
 	mb.mutex.Lock()
@@ -190,6 +224,42 @@ func (mb *MockBackend) WriteChannelLog(ctx context.Context, clog *courier.Channe
 	return nil
 }
 
+// GetChannelLogs returns the written channel logs for the passed in channel which match the given query
+func (mb *MockBackend) GetChannelLogs(ctx context.Context, ch courier.Channel, q *courier.ChannelLogQuery) ([]*clogs.Log, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	logs := make([]*clogs.Log, 0)
+
+	for i := len(mb.writtenChannelLogs) - 1; i >= 0 && len(logs) < q.Limit; i-- {
+		clog := mb.writtenChannelLogs[i]
+		if clog.Channel().UUID() != ch.UUID() {
+			continue
+		}
+
+		if len(q.UUIDs) > 0 {
+			if !slices.Contains(q.UUIDs, clog.UUID) {
+				continue
+			}
+		} else if q.After.IsZero() || clog.CreatedOn.Before(q.After) || !clog.CreatedOn.Before(q.Before) {
+			continue
+		}
+
+		l := *clog.Log
+		logs = append(logs, &l)
+	}
+
+	return logs, nil
+}
+
+// SetErrorOnDefer is a mock method which makes DeferOutgoingMsg calls return an error
+func (mb *MockBackend) SetErrorOnDefer(shouldError bool) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.errorOnDefer = shouldError
+}
+
 // SetErrorOnQueue is a mock method which makes the QueueMsg call throw the passed in error on next call
 func (mb *MockBackend) SetErrorOnQueue(shouldError bool) {
 	mb.errorOnQueue = shouldError
@@ -204,6 +274,10 @@ func (mb *MockBackend) WriteMsg(ctx context.Context, m courier.MsgIn, clog *cour
 		return nil
 	}
 
+	if mb.blockedURNs[mm.urn] {
+		return courier.ErrURNBlocked
+	}
+
 	mb.lastMsgID++
 	mm.id = mb.lastMsgID
 
@@ -225,6 +299,24 @@ func (mb *MockBackend) WriteMsg(ctx context.Context, m courier.MsgIn, clog *cour
 	return nil
 }
 
+// AcceptMsg checks the passed in message which won't be written as a message
+func (mb *MockBackend) AcceptMsg(ctx context.Context, m courier.MsgIn, clog *courier.ChannelLog) error {
+	mm := m.(*MockMsg)
+
+	if mm.alreadyWritten {
+		return courier.ErrMsgDuplicate
+	}
+
+	if mm.uuid == "" {
+		mm.uuid = courier.MsgUUID(uuids.NewV4())
+	}
+	if m.ExternalID() != "" {
+		mb.seenExternalIDs[fmt.Sprintf("%s|%s", m.Channel().UUID(), m.ExternalID())] = m.UUID()
+	}
+
+	return nil
+}
+
 // NewStatusUpdate creates a new Status object for the given message id
 func (mb *MockBackend) NewStatusUpdate(channel courier.Channel, id courier.MsgID, status courier.MsgStatus, clog *courier.ChannelLog) courier.StatusUpdate {
 	return &MockStatusUpdate{
@@ -270,6 +362,10 @@ func (mb *MockBackend) WriteChannelEvent(ctx context.Context, event courier.Chan
 	mb.mutex.Lock()
 	defer mb.mutex.Unlock()
 
+	if mb.blockedURNs[evt.urn] {
+		return courier.ErrURNBlocked
+	}
+
 	mb.writtenChannelEvents = append(mb.writtenChannelEvents, event)
 	mb.lastContactName = evt.contactName
 
@@ -298,6 +394,15 @@ func (mb *MockBackend) GetChannelByAddress(ctx context.Context, cType courier.Ch
 	return channel, nil
 }
 
+// InvalidateChannel records the invalidation of the given channel
+func (mb *MockBackend) InvalidateChannel(ctx context.Context, uuid courier.ChannelUUID, address courier.ChannelAddress) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.invalidatedChannels = append(mb.invalidatedChannels, uuid)
+	return nil
+}
+
 // GetContact creates a new contact with the passed in channel and URN
 func (mb *MockBackend) GetContact(ctx context.Context, channel courier.Channel, urn urns.URN, authTokens map[string]string, name string, clog *courier.ChannelLog) (courier.Contact, error) {
 	contact, found := mb.contacts[urn]
@@ -389,6 +494,8 @@ func (mb *MockBackend) WrittenMsgStatuses() []courier.StatusUpdate    { return m
 func (mb *MockBackend) WrittenChannelEvents() []courier.ChannelEvent  { return mb.writtenChannelEvents }
 func (mb *MockBackend) WrittenChannelLogs() []*courier.ChannelLog     { return mb.writtenChannelLogs }
 func (mb *MockBackend) SavedAttachments() []*SavedAttachment          { return mb.savedAttachments }
+func (mb *MockBackend) DeferredMsgs() []*DeferredMsg                  { return mb.deferredMsgs }
+func (mb *MockBackend) InvalidatedChannels() []courier.ChannelUUID    { return mb.invalidatedChannels }
 func (mb *MockBackend) URNAuthTokens() map[urns.URN]map[string]string { return mb.urnAuthTokens }
 
 // LastContactName returns the contact name set on the last msg or channel event written
@@ -407,6 +514,11 @@ func (mb *MockBackend) AddChannel(channel courier.Channel) {
 	mb.channelsByAddress[channel.ChannelAddress()] = channel
 }
 
+// BlockURN blocks the given URN so that writing messages or channel events from it returns ErrURNBlocked
+func (mb *MockBackend) BlockURN(urn urns.URN) {
+	mb.blockedURNs[urn] = true
+}
+
 // ClearChannels is a utility function on our mock server to clear all added channels
 func (mb *MockBackend) ClearChannels() {
 	mb.channels = nil
@@ -423,6 +535,7 @@ func (mb *MockBackend) Reset() {
 	mb.writtenChannelEvents = nil
 	mb.writtenChannelLogs = nil
 	mb.urnAuthTokens = nil
+	mb.deferredMsgs = nil
 }
 
 // SetStorageError sets the error to return for operation that try to use storage
@@ -1,11 +1,13 @@
 package test
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
 
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils/secrets"
 	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/gocommon/urns"
 )
@@ -20,6 +22,7 @@ type MockChannel struct {
 	role        string
 	config      map[string]any
 	orgConfig   map[string]any
+	orgIsAnon   bool
 }
 
 // UUID returns the uuid for this channel
@@ -71,6 +74,16 @@ func (c *MockChannel) ConfigForKey(key string, defaultValue any) any {
 	if !found {
 		return defaultValue
 	}
+
+	// like real channels, resolve references to secrets in keys declared as secret by the channel's handler
+	if str, isStr := value.(string); isStr && courier.IsSecretConfigKey(c.ChannelType(), key) {
+		secret, err := secrets.Resolve(context.Background(), str)
+		if err != nil {
+			return defaultValue
+		}
+		return secret
+	}
+
 	return value
 }
 
@@ -129,6 +142,12 @@ func (c *MockChannel) OrgConfigForKey(key string, defaultValue any) any {
 	return value
 }
 
+// OrgIsAnon returns whether this channel's org is anonymous
+func (c *MockChannel) OrgIsAnon() bool { return c.orgIsAnon }
+
+// SetOrgIsAnon sets whether this channel's org is anonymous
+func (c *MockChannel) SetOrgIsAnon(anon bool) { c.orgIsAnon = anon }
+
 // SetRoles sets the role on the channel
 func (c *MockChannel) SetRoles(roles []courier.ChannelRole) {
 	c.role = fmt.Sprint(roles)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/utils/clogs"
@@ -30,6 +31,11 @@ func (h *mockHandler) ChannelName() string                   { return "Mock Hand
 func (h *mockHandler) ChannelType() courier.ChannelType      { return courier.ChannelType("MCK") }
 func (h *mockHandler) UseChannelRouteUUID() bool             { return true }
 func (h *mockHandler) RedactValues(courier.Channel) []string { return []string{"sesame"} }
+func (h *mockHandler) SecretConfigKeys() []string            { return []string{courier.ConfigAuthToken} }
+
+func (h *mockHandler) ConfigSchema() courier.ConfigSchema {
+	return courier.ConfigSchema{{Key: courier.ConfigMaxLength, Type: courier.ConfigTypeInt}}
+}
 
 func (h *mockHandler) GetChannel(ctx context.Context, r *http.Request) (courier.Channel, error) {
 	dmChannel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
@@ -41,6 +47,7 @@ func (h *mockHandler) Initialize(s courier.Server) error {
 	h.server = s
 	h.backend = s.Backend()
 	s.AddHandlerRoute(h, http.MethodGet, "receive", courier.ChannelLogTypeMsgReceive, h.receiveMsg)
+	s.AddHandlerRoute(h, http.MethodGet, "event", courier.ChannelLogTypeEventReceive, h.receiveEvent)
 	return nil
 }
 
@@ -64,6 +71,11 @@ func (h *mockHandler) Send(ctx context.Context, msg courier.MsgOut, res *courier
 
 	if msg.Text() == "err:config" {
 		return courier.ErrChannelConfig
+	} else if msg.Text() == "err:retry" {
+		return courier.ErrRetryAfter(30 * time.Second)
+	} else if msg.Text() == "err:retry_partial" {
+		res.AddExternalID("ext1")
+		return courier.ErrRetryAfter(30 * time.Second)
 	}
 
 	return nil
@@ -100,3 +112,18 @@ func (h *mockHandler) receiveMsg(ctx context.Context, channel courier.Channel, w
 	h.backend.WriteMsg(ctx, msg, clog)
 	return []courier.Event{msg}, nil
 }
+
+// receiveEvent writes a new conversation event from the passed in URN, returning any error
+func (h *mockHandler) receiveEvent(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	r.ParseForm()
+	from := r.Form.Get("from")
+	if from == "" {
+		return nil, errors.New("missing from")
+	}
+
+	event := h.backend.NewChannelEvent(channel, courier.EventTypeNewConversation, urns.URN("tel:"+from), clog)
+	if err := h.backend.WriteChannelEvent(ctx, event, clog); err != nil {
+		return nil, err
+	}
+	return []courier.Event{event}, courier.WriteChannelEventSuccess(w, event)
+}
@@ -38,6 +38,8 @@ type MockMsg struct {
 
 	receivedOn *time.Time
 	sentOn     *time.Time
+	expiresOn  *time.Time
+	segment    *courier.MsgSegment
 }
 
 func NewMockMsg(id courier.MsgID, uuid courier.MsgUUID, channel courier.Channel, urn urns.URN, text string, attachments []string) *MockMsg {
@@ -77,6 +79,7 @@ func (m *MockMsg) OptIn() *courier.OptInReference  { return m.optIn }
 func (m *MockMsg) UserID() courier.UserID          { return m.userID }
 func (m *MockMsg) SessionStatus() string           { return "" }
 func (m *MockMsg) HighPriority() bool              { return m.highPriority }
+func (m *MockMsg) ExpiresOn() *time.Time           { return m.expiresOn }
 
 // incoming specific
 func (m *MockMsg) ReceivedOn() *time.Time { return m.receivedOn }
@@ -90,6 +93,10 @@ func (m *MockMsg) WithURNAuthTokens(tokens map[string]string) courier.MsgIn {
 	return m
 }
 func (m *MockMsg) WithReceivedOn(date time.Time) courier.MsgIn { m.receivedOn = &date; return m }
+func (m *MockMsg) WithSegment(segment *courier.MsgSegment) courier.MsgIn {
+	m.segment = segment
+	return m
+}
 
 // used to create outgoing messages for testing
 func (m *MockMsg) WithID(id courier.MsgID) courier.MsgOut              { m.id = id; return m }
@@ -100,3 +107,8 @@ func (m *MockMsg) WithOptIn(o *courier.OptInReference) courier.MsgOut  { m.optIn
 func (m *MockMsg) WithUserID(uid courier.UserID) courier.MsgOut        { m.userID = uid; return m }
 func (m *MockMsg) WithLocale(lc i18n.Locale) courier.MsgOut            { m.locale = lc; return m }
 func (m *MockMsg) WithURNAuth(token string) courier.MsgOut             { m.urnAuth = token; return m }
+func (m *MockMsg) WithExpiresOn(t time.Time) courier.MsgOut            { m.expiresOn = &t; return m }
+func (m *MockMsg) WithOrigin(o courier.MsgOrigin) courier.MsgOut       { m.origin = o; return m }
+
+// used to check incoming messages in tests
+func (m *MockMsg) Segment() *courier.MsgSegment { return m.segment }
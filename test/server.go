@@ -12,15 +12,17 @@ type MockServer struct {
 	backend courier.Backend
 	config  *courier.Config
 
-	stopChan chan bool
-	stopped  bool
+	stopChan  chan bool
+	stopped   bool
+	waitGroup *sync.WaitGroup
 }
 
 func NewMockServer(config *courier.Config, backend courier.Backend) courier.Server {
 	return &MockServer{
-		backend:  backend,
-		config:   config,
-		stopChan: make(chan bool),
+		backend:   backend,
+		config:    config,
+		stopChan:  make(chan bool),
+		waitGroup: &sync.WaitGroup{},
 	}
 }
 
@@ -40,7 +42,7 @@ func (ms *MockServer) Backend() courier.Backend {
 }
 
 func (ms *MockServer) WaitGroup() *sync.WaitGroup {
-	return nil
+	return ms.waitGroup
 }
 func (ms *MockServer) StopChan() chan bool {
 	return ms.stopChan
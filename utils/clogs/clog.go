@@ -2,6 +2,9 @@ package clogs
 
 import (
 	"fmt"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
@@ -13,7 +16,8 @@ import (
 )
 
 const (
-	dynamoTTL = 7 * 24 * time.Hour // 1 week
+	dynamoTTL  = 7 * 24 * time.Hour // 1 week
+	redactMask = "**********"
 )
 
 // LogUUID is the type of a channel log UUID (should be v7)
@@ -45,15 +49,20 @@ func (e *LogError) Redact(r stringsx.Redactor) *LogError {
 
 // Log is the basic channel log structure
 type Log struct {
-	UUID      LogUUID
-	Type      LogType
-	HttpLogs  []*httpx.Log
-	Errors    []*LogError
-	CreatedOn time.Time
-	Elapsed   time.Duration
-
-	recorder *httpx.Recorder
-	redactor stringsx.Redactor
+	UUID        LogUUID
+	Type        LogType
+	ChannelUUID uuids.UUID // channel this log belongs to
+	ChannelType string     // type of that channel
+	HttpLogs    []*httpx.Log
+	Errors      []*LogError
+	Warnings    []*LogError // things worth noting which don't make this an error log
+	CreatedOn   time.Time
+	Elapsed     time.Duration
+
+	recorder       *httpx.Recorder
+	redactVals     []string
+	redactPatterns []*regexp.Regexp
+	redactor       stringsx.Redactor
 }
 
 func NewLog(t LogType, r *httpx.Recorder, redactVals []string) *Log {
@@ -64,8 +73,9 @@ func NewLog(t LogType, r *httpx.Recorder, redactVals []string) *Log {
 		Errors:    []*LogError{},
 		CreatedOn: time.Now(),
 
-		recorder: r,
-		redactor: stringsx.NewRedactor("**********", redactVals...),
+		recorder:   r,
+		redactVals: redactVals,
+		redactor:   stringsx.NewRedactor(redactMask, redactVals...),
 	}
 }
 
@@ -79,6 +89,11 @@ func (l *Log) Error(e *LogError) {
 	l.Errors = append(l.Errors, e.Redact(l.redactor))
 }
 
+// Warning adds the given warning to this log
+func (l *Log) Warning(e *LogError) {
+	l.Warnings = append(l.Warnings, e.Redact(l.redactor))
+}
+
 // End finalizes this log
 func (l *Log) End() {
 	if l.recorder != nil {
@@ -89,38 +104,125 @@ func (l *Log) End() {
 	l.Elapsed = time.Since(l.CreatedOn)
 }
 
+// Redact applies redaction of the given values to the HTTP logs and errors of this log, e.g. when it has been read
+// back from storage and the values that need redacting may have changed since it was written
+func (l *Log) Redact(redactVals []string) {
+	l.redactWith(stringsx.NewRedactor(redactMask, redactVals...))
+}
+
+// AddRedactValues adds values to be redacted from this log, including from anything already recorded
+func (l *Log) AddRedactValues(vals ...string) {
+	for _, v := range vals {
+		if v != "" && !slices.Contains(l.redactVals, v) {
+			l.redactVals = append(l.redactVals, v)
+		}
+	}
+	l.updateRedactor()
+}
+
+// AddRedactPatterns adds patterns to be redacted from this log, including from anything already recorded. If a pattern
+// has capture groups then only those are redacted, otherwise the entire match is.
+func (l *Log) AddRedactPatterns(patterns ...*regexp.Regexp) {
+	l.redactPatterns = append(l.redactPatterns, patterns...)
+	l.updateRedactor()
+}
+
+func (l *Log) updateRedactor() {
+	valsRedactor := stringsx.NewRedactor(redactMask, l.redactVals...)
+	patterns := l.redactPatterns
+
+	l.redactor = func(s string) string {
+		s = valsRedactor(s)
+		for _, p := range patterns {
+			s = redactPattern(s, p)
+		}
+		return s
+	}
+	l.redactWith(l.redactor)
+}
+
+func (l *Log) redactWith(r stringsx.Redactor) {
+	for _, h := range l.HttpLogs {
+		h.URL = r(h.URL)
+		h.Request = r(h.Request)
+		h.Response = r(h.Response)
+	}
+	for i, e := range l.Errors {
+		l.Errors[i] = e.Redact(r)
+	}
+	for i, w := range l.Warnings {
+		l.Warnings[i] = w.Redact(r)
+	}
+}
+
+// replaces matches of the given pattern, or just its capture groups if it has any, with our mask
+func redactPattern(s string, p *regexp.Regexp) string {
+	var sb strings.Builder
+	last := 0
+
+	for _, m := range p.FindAllStringSubmatchIndex(s, -1) {
+		groups := [][]int{m[0:2]}
+		if len(m) > 2 {
+			groups = groups[:0]
+			for i := 2; i < len(m); i += 2 {
+				if m[i] >= 0 {
+					groups = append(groups, m[i:i+2])
+				}
+			}
+		}
+
+		for _, g := range groups {
+			if g[0] < last {
+				continue
+			}
+			sb.WriteString(s[last:g[0]])
+			sb.WriteString(redactMask)
+			last = g[1]
+		}
+	}
+
+	if last == 0 {
+		return s
+	}
+	sb.WriteString(s[last:])
+	return sb.String()
+}
+
 func (l *Log) traceToLog(t *httpx.Trace) *httpx.Log {
 	return httpx.NewLog(t, 2048, 50000, l.redactor)
 }
 
 // log struct to be written to DynamoDB
 type dynamoLog struct {
-	UUID      LogUUID   `dynamodbav:"UUID"`
-	Type      LogType   `dynamodbav:"Type"`
-	DataGZ    []byte    `dynamodbav:"DataGZ,omitempty"`
-	ElapsedMS int       `dynamodbav:"ElapsedMS"`
-	CreatedOn time.Time `dynamodbav:"CreatedOn,unixtime"`
-	ExpiresOn time.Time `dynamodbav:"ExpiresOn,unixtime"`
+	UUID        LogUUID    `dynamodbav:"UUID"`
+	Type        LogType    `dynamodbav:"Type"`
+	ChannelUUID uuids.UUID `dynamodbav:"ChannelUUID,omitempty"`
+	DataGZ      []byte     `dynamodbav:"DataGZ,omitempty"`
+	ElapsedMS   int        `dynamodbav:"ElapsedMS"`
+	CreatedOn   time.Time  `dynamodbav:"CreatedOn,unixtime"`
+	ExpiresOn   time.Time  `dynamodbav:"ExpiresOn,unixtime"`
 }
 
 type dynamoLogData struct {
 	HttpLogs []*httpx.Log `json:"http_logs"`
 	Errors   []*LogError  `json:"errors"`
+	Warnings []*LogError  `json:"warnings,omitempty"`
 }
 
 func (l *Log) MarshalDynamo() (map[string]types.AttributeValue, error) {
-	data, err := dynamo.MarshalJSONGZ(&dynamoLogData{HttpLogs: l.HttpLogs, Errors: l.Errors})
+	data, err := dynamo.MarshalJSONGZ(&dynamoLogData{HttpLogs: l.HttpLogs, Errors: l.Errors, Warnings: l.Warnings})
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling log data: %w", err)
 	}
 
 	return attributevalue.MarshalMap(&dynamoLog{
-		UUID:      l.UUID,
-		Type:      l.Type,
-		DataGZ:    data,
-		ElapsedMS: int(l.Elapsed / time.Millisecond),
-		CreatedOn: l.CreatedOn,
-		ExpiresOn: l.CreatedOn.Add(dynamoTTL),
+		UUID:        l.UUID,
+		Type:        l.Type,
+		ChannelUUID: l.ChannelUUID,
+		DataGZ:      data,
+		ElapsedMS:   int(l.Elapsed / time.Millisecond),
+		CreatedOn:   l.CreatedOn,
+		ExpiresOn:   l.CreatedOn.Add(dynamoTTL),
 	})
 }
 
@@ -138,8 +240,10 @@ func (l *Log) UnmarshalDynamo(m map[string]types.AttributeValue) error {
 
 	l.UUID = d.UUID
 	l.Type = d.Type
+	l.ChannelUUID = d.ChannelUUID
 	l.HttpLogs = data.HttpLogs
 	l.Errors = data.Errors
+	l.Warnings = data.Warnings
 	l.Elapsed = time.Duration(d.ElapsedMS) * time.Millisecond
 	l.CreatedOn = d.CreatedOn
 	return nil
@@ -3,6 +3,7 @@ package clogs_test
 import (
 	"context"
 	"net/http"
+	"regexp"
 	"testing"
 	"time"
 
@@ -69,3 +70,44 @@ func TestLogs(t *testing.T) {
 	assert.Equal(t, l1.Elapsed, l3.Elapsed)
 	assert.Equal(t, l1.CreatedOn.Truncate(time.Second), l3.CreatedOn)
 }
+
+func TestRedact(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://ivr.com/start?key=sesame": {httpx.NewMockResponse(200, nil, []byte("OK open sesame"))},
+	}))
+
+	clog := clogs.NewLog("type1", nil, nil)
+
+	req, _ := httpx.NewRequest("GET", "http://ivr.com/start?key=sesame", nil, nil)
+	trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, -1)
+	require.NoError(t, err)
+
+	clog.HTTP(trace)
+	clog.Error(clogs.NewLogError("code1", "", "invalid key sesame"))
+	clog.End()
+
+	assert.Contains(t, clog.HttpLogs[0].URL, "sesame")
+
+	clog.Redact([]string{"sesame"})
+
+	assert.Equal(t, "http://ivr.com/start?key=**********", clog.HttpLogs[0].URL)
+	assert.NotContains(t, clog.HttpLogs[0].Request, "sesame")
+	assert.NotContains(t, clog.HttpLogs[0].Response, "sesame")
+	assert.Equal(t, "invalid key **********", clog.Errors[0].Message)
+}
+
+func TestRedactValuesAndPatterns(t *testing.T) {
+	clog := clogs.NewLog("type1", nil, []string{"sesame"})
+	clog.Error(clogs.NewLogError("", "", "sesame 1234 bob"))
+
+	clog.AddRedactValues("bob", "")
+	assert.Equal(t, "********** 1234 **********", clog.Errors[0].Message)
+
+	// patterns without groups redact entire match, patterns with groups just the groups
+	clog.AddRedactPatterns(regexp.MustCompile(`\d{4}`), regexp.MustCompile(`id=(\w+)&name=(\w+)`))
+	assert.Equal(t, "********** ********** **********", clog.Errors[0].Message)
+
+	clog.Error(clogs.NewLogError("", "", "id=123&name=jim&age=3 open sesame"))
+	assert.Equal(t, "id=**********&name=**********&age=3 open **********", clog.Errors[1].Message)
+}
@@ -0,0 +1,203 @@
+package clogs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/uuids"
+)
+
+// Sink is somewhere that batches of logs can be written to
+type Sink interface {
+	Write(context.Context, []*Log) error
+	Close() error
+}
+
+// log struct to be written as a line of JSON
+type jsonLog struct {
+	UUID        LogUUID      `json:"uuid"`
+	Type        LogType      `json:"type"`
+	ChannelUUID uuids.UUID   `json:"channel_uuid,omitempty"`
+	ChannelType string       `json:"channel_type,omitempty"`
+	HttpLogs    []*httpx.Log `json:"http_logs"`
+	Errors      []*LogError  `json:"errors"`
+	Warnings    []*LogError  `json:"warnings,omitempty"`
+	CreatedOn   time.Time    `json:"created_on"`
+	ElapsedMS   int          `json:"elapsed_ms"`
+}
+
+// MarshalJSONL marshals this log as a single line of JSON, including the trailing newline
+func (l *Log) MarshalJSONL() ([]byte, error) {
+	b, err := json.Marshal(&jsonLog{
+		UUID:        l.UUID,
+		Type:        l.Type,
+		ChannelUUID: l.ChannelUUID,
+		ChannelType: l.ChannelType,
+		HttpLogs:    l.HttpLogs,
+		Errors:      l.Errors,
+		Warnings:    l.Warnings,
+		CreatedOn:   l.CreatedOn,
+		ElapsedMS:   int(l.Elapsed / time.Millisecond),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// WriterSink writes logs as JSONL to an io.Writer such as stdout
+type WriterSink struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// NewWriterSink creates a new sink which writes to the given writer
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) Write(ctx context.Context, logs []*Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, l := range logs {
+		line, err := l.MarshalJSONL()
+		if err != nil {
+			return fmt.Errorf("error marshaling log: %w", err)
+		}
+		if _, err := s.w.Write(line); err != nil {
+			return fmt.Errorf("error writing log: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *WriterSink) Close() error { return nil }
+
+// FileSink writes logs as JSONL to a file which is rotated when it reaches a maximum size. Rotated files are named
+// with the time of rotation, e.g. logs.jsonl becomes logs-20240911T143300.000000000.jsonl, and only the newest maxBackups
+// of them are kept.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+	mu   sync.Mutex
+}
+
+// NewFileSink creates a new file sink, opening or creating the file at the given path
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	s := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) Write(ctx context.Context, logs []*Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rotateErr error
+
+	for _, l := range logs {
+		line, err := l.MarshalJSONL()
+		if err != nil {
+			return fmt.Errorf("error marshaling log: %w", err)
+		}
+
+		// if we can't rotate, we keep writing to the current file rather than lose logs
+		if s.size > 0 && s.size+int64(len(line)) > s.maxSize && rotateErr == nil {
+			rotateErr = s.rotate()
+		}
+
+		n, err := s.file.Write(line)
+		s.size += int64(n)
+		if err != nil {
+			return fmt.Errorf("error writing log: %w", err)
+		}
+	}
+	return rotateErr
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+func (s *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("error creating log directory: %w", err)
+	}
+
+	f, size, err := openLogFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.file = f
+	s.size = size
+	return nil
+}
+
+// rotates the current file by renaming it and opening a new one. The current file handle is only replaced once the
+// new file is open so if anything fails we can keep writing to it.
+func (s *FileSink) rotate() error {
+	ext := filepath.Ext(s.path)
+	base := s.path[:len(s.path)-len(ext)]
+	rotated := fmt.Sprintf("%s-%s%s", base, time.Now().UTC().Format("20060102T150405.000000000"), ext)
+
+	if err := os.Rename(s.path, rotated); err != nil {
+		return fmt.Errorf("error rotating log file: %w", err)
+	}
+
+	f, size, err := openLogFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	old := s.file
+	s.file = f
+	s.size = size
+
+	if err := old.Close(); err != nil {
+		return fmt.Errorf("error closing rotated log file: %w", err)
+	}
+
+	// remove the oldest backups if we have too many
+	backups, _ := filepath.Glob(fmt.Sprintf("%s-*%s", base, ext))
+	slices.Sort(backups)
+	if len(backups) > s.maxBackups {
+		for _, b := range backups[:len(backups)-s.maxBackups] {
+			os.Remove(b)
+		}
+	}
+
+	return nil
+}
+
+// opens or creates the log file at the given path for appending, returning it and its current size
+func openLogFile(path string) (*os.File, int64, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error opening log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("error reading log file: %w", err)
+	}
+	return f, info.Size(), nil
+}
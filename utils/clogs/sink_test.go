@@ -0,0 +1,117 @@
+package clogs_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterSink(t *testing.T) {
+	ctx := context.Background()
+
+	l1 := clogs.NewLog("type1", nil, nil)
+	l1.Error(clogs.NewLogError("code1", "", "oops"))
+	l1.Warning(clogs.NewLogError("code2", "", "hmm"))
+	l1.ChannelUUID = "fef91e9b-a6ed-44fb-b6ce-feed8af585a8"
+	l1.ChannelType = "TG"
+	l2 := clogs.NewLog("type2", nil, nil)
+
+	buf := &bytes.Buffer{}
+	sink := clogs.NewWriterSink(buf)
+
+	assert.NoError(t, sink.Write(ctx, []*clogs.Log{l1, l2}))
+	assert.NoError(t, sink.Close())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		line1 := map[string]any{}
+		jsonx.MustUnmarshal([]byte(lines[0]), &line1)
+
+		assert.Equal(t, string(l1.UUID), line1["uuid"])
+		assert.Equal(t, "type1", line1["type"])
+		assert.Equal(t, "fef91e9b-a6ed-44fb-b6ce-feed8af585a8", line1["channel_uuid"])
+		assert.Equal(t, "TG", line1["channel_type"])
+		assert.Equal(t, []any{map[string]any{"code": "code1", "message": "oops"}}, line1["errors"])
+		assert.Equal(t, []any{map[string]any{"code": "code2", "message": "hmm"}}, line1["warnings"])
+		assert.Contains(t, lines[1], string(l2.UUID))
+		assert.NotContains(t, lines[1], "warnings")
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logs", "channel.jsonl")
+
+	newLog := func() *clogs.Log {
+		l := clogs.NewLog("type1", nil, nil)
+		l.CreatedOn = time.Date(2024, 9, 11, 14, 33, 0, 0, time.UTC)
+		return l
+	}
+
+	line, err := newLog().MarshalJSONL()
+	require.NoError(t, err)
+
+	// big enough for 3 logs per file and keep 2 backups
+	sink, err := clogs.NewFileSink(path, int64(len(line)*3), 2)
+	require.NoError(t, err)
+
+	assert.NoError(t, sink.Write(ctx, []*clogs.Log{newLog(), newLog()}))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+
+	assert.NoError(t, sink.Write(ctx, []*clogs.Log{newLog(), newLog()}))
+
+	backups, _ := filepath.Glob(filepath.Join(dir, "logs", "channel-*.jsonl"))
+	assert.Len(t, backups, 1)
+
+	data, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+
+	for range 3 {
+		assert.NoError(t, sink.Write(ctx, []*clogs.Log{newLog(), newLog(), newLog()}))
+	}
+
+	// only newest backups are kept
+	backups, _ = filepath.Glob(filepath.Join(dir, "logs", "channel-*.jsonl"))
+	assert.Len(t, backups, 2)
+
+	assert.NoError(t, sink.Close())
+
+	// reopening appends to existing file
+	sink, err = clogs.NewFileSink(path, int64(len(line)*3), 2)
+	require.NoError(t, err)
+	assert.NoError(t, sink.Write(ctx, []*clogs.Log{newLog()}))
+
+	// if rotating fails, we keep writing to the current file
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "logs")))
+
+	err = sink.Write(ctx, []*clogs.Log{newLog(), newLog(), newLog()})
+	assert.ErrorContains(t, err, "error rotating log file")
+	err = sink.Write(ctx, []*clogs.Log{newLog()})
+	assert.ErrorContains(t, err, "error rotating log file")
+
+	// and once the problem is fixed, rotating works again
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "logs"), 0755))
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+
+	assert.NoError(t, sink.Write(ctx, []*clogs.Log{newLog()}))
+
+	data, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+
+	assert.NoError(t, sink.Close())
+}
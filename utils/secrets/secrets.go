@@ -0,0 +1,152 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/gocommon/cache"
+)
+
+// how long resolved secrets are cached for
+const cacheTTL = 5 * time.Minute
+
+// Store is a source of secrets which can be referenced in channel config as <scheme>:<name>
+type Store interface {
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// StoreFunc lets a function be used as a Store
+type StoreFunc func(ctx context.Context, name string) (string, error)
+
+// Get calls the function
+func (f StoreFunc) Get(ctx context.Context, name string) (string, error) { return f(ctx, name) }
+
+// EnvStore reads secrets from environment variables, e.g. env:COURIER_SECRET_TELEGRAM_TOKEN, which must have the
+// configured prefix
+var EnvStore = StoreFunc(func(ctx context.Context, name string) (string, error) {
+	mutex.RLock()
+	prefix := envPrefix
+	mutex.RUnlock()
+
+	if prefix == "" || !strings.HasPrefix(name, prefix) {
+		return "", fmt.Errorf("environment variable %s is not allowed as a secret", name)
+	}
+
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s not set", name)
+	}
+	return v, nil
+})
+
+// FileStore reads secrets from files, e.g. file:/run/secrets/telegram_token, which must be inside the configured
+// secrets directory, ignoring surrounding whitespace
+var FileStore = StoreFunc(func(ctx context.Context, name string) (string, error) {
+	mutex.RLock()
+	secretsDir := dir
+	mutex.RUnlock()
+
+	if !inDir(secretsDir, name) {
+		return "", fmt.Errorf("file %s is not allowed as a secret", name)
+	}
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret file: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+})
+
+var (
+	stores    = map[string]Store{"env": EnvStore, "file": FileStore}
+	envPrefix string // prefix env vars must have to be read by EnvStore, empty to allow none
+	dir       string // directory files must be in to be read by FileStore, empty to allow none
+	mutex     sync.RWMutex
+
+	resolved = cache.NewLocal(fetch, cacheTTL)
+)
+
+// Configure sets the prefix environment variables must have and the directory files must be in to be referenced as
+// secrets. Until configured, or if empty, no environment variables or files can be referenced.
+func Configure(prefix, secretsDir string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	envPrefix = prefix
+	dir = secretsDir
+}
+
+// RegisterStore registers a store for references with the given scheme
+func RegisterStore(scheme string, s Store) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	stores[scheme] = s
+}
+
+// IsRef returns whether the given value is a reference to a secret in one of the registered stores
+func IsRef(v string) bool {
+	_, _, found := lookup(v)
+	return found
+}
+
+// Resolve returns the secret referenced by the given value, or the value unchanged if it isn't a reference. Resolved
+// secrets are cached so changes to them take effect once they expire.
+func Resolve(ctx context.Context, v string) (string, error) {
+	if !IsRef(v) {
+		return v, nil
+	}
+	return resolved.GetOrFetch(ctx, v)
+}
+
+// ClearCache clears any cached secrets
+func ClearCache() {
+	resolved.Clear()
+}
+
+func fetch(ctx context.Context, ref string) (string, error) {
+	store, name, _ := lookup(ref)
+
+	secret, err := store.Get(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("error resolving secret %s: %w", ref, err)
+	}
+	return secret, nil
+}
+
+func lookup(v string) (Store, string, bool) {
+	scheme, name, found := strings.Cut(v, ":")
+	if !found || name == "" {
+		return nil, "", false
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	store, found := stores[scheme]
+	return store, name, found
+}
+
+// checks whether the given path is inside the given directory once both have been cleaned and symlinks resolved
+func inDir(dir, path string) bool {
+	if dir == "" || !filepath.IsAbs(path) {
+		return false
+	}
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(realDir, realPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
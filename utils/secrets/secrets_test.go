@@ -0,0 +1,119 @@
+package secrets_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nyaruka/courier/utils/secrets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	defer secrets.ClearCache()
+
+	t.Setenv("COURIER_TEST_TOKEN", "sesame")
+	t.Setenv("OTHER_TOKEN", "sesame")
+
+	secretsDir := t.TempDir()
+	secretFile := filepath.Join(secretsDir, "token")
+	os.WriteFile(secretFile, []byte("opensesame\n"), 0600)
+
+	otherFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(otherFile, []byte("opensesame\n"), 0600)
+	os.Symlink(otherFile, filepath.Join(secretsDir, "link"))
+
+	// until configured, no env vars or files can be referenced
+	_, err := secrets.Resolve(ctx, "env:COURIER_TEST_TOKEN")
+	assert.EqualError(t, err, "error resolving secret env:COURIER_TEST_TOKEN: environment variable COURIER_TEST_TOKEN is not allowed as a secret")
+
+	secrets.Configure("COURIER_TEST_", secretsDir)
+	defer secrets.Configure("", "")
+
+	assert.True(t, secrets.IsRef("env:COURIER_TEST_TOKEN"))
+	assert.True(t, secrets.IsRef("file:/run/secrets/token"))
+	assert.False(t, secrets.IsRef("sesame"))
+	assert.False(t, secrets.IsRef("env:"))
+	assert.False(t, secrets.IsRef("https://example.com"))
+
+	// values which aren't references are returned as is
+	v, err := secrets.Resolve(ctx, "https://example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com", v)
+
+	v, err = secrets.Resolve(ctx, "env:COURIER_TEST_TOKEN")
+	assert.NoError(t, err)
+	assert.Equal(t, "sesame", v)
+
+	v, err = secrets.Resolve(ctx, "file:"+secretFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "opensesame", v)
+
+	_, err = secrets.Resolve(ctx, "env:COURIER_TEST_MISSING")
+	assert.EqualError(t, err, "error resolving secret env:COURIER_TEST_MISSING: environment variable COURIER_TEST_MISSING not set")
+
+	_, err = secrets.Resolve(ctx, "file:/nowhere/token")
+	assert.Error(t, err)
+
+	// env vars without our prefix and files outside of our directory can't be referenced
+	_, err = secrets.Resolve(ctx, "env:OTHER_TOKEN")
+	assert.EqualError(t, err, "error resolving secret env:OTHER_TOKEN: environment variable OTHER_TOKEN is not allowed as a secret")
+
+	_, err = secrets.Resolve(ctx, "file:"+otherFile)
+	assert.EqualError(t, err, "error resolving secret file:"+otherFile+": file "+otherFile+" is not allowed as a secret")
+
+	_, err = secrets.Resolve(ctx, "file:"+secretsDir+"/../"+filepath.Base(filepath.Dir(otherFile))+"/token")
+	assert.Error(t, err)
+
+	_, err = secrets.Resolve(ctx, "file:"+filepath.Join(secretsDir, "link"))
+	assert.Error(t, err)
+
+	_, err = secrets.Resolve(ctx, "file:token")
+	assert.Error(t, err)
+
+	// resolved secrets are cached
+	os.WriteFile(secretFile, []byte("newsesame"), 0600)
+
+	v, err = secrets.Resolve(ctx, "file:"+secretFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "opensesame", v)
+
+	secrets.ClearCache()
+
+	v, err = secrets.Resolve(ctx, "file:"+secretFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "newsesame", v)
+}
+
+func TestRegisterStore(t *testing.T) {
+	ctx := context.Background()
+	defer secrets.ClearCache()
+
+	fetches := 0
+	secrets.RegisterStore("vault", secrets.StoreFunc(func(ctx context.Context, name string) (string, error) {
+		fetches++
+		if name == "channels/telegram" {
+			return "sesame", nil
+		}
+		return "", errors.New("no such secret")
+	}))
+
+	assert.True(t, secrets.IsRef("vault:channels/telegram"))
+
+	for range 3 {
+		v, err := secrets.Resolve(ctx, "vault:channels/telegram")
+		assert.NoError(t, err)
+		assert.Equal(t, "sesame", v)
+	}
+	assert.Equal(t, 1, fetches)
+
+	// errors aren't cached
+	for range 2 {
+		_, err := secrets.Resolve(ctx, "vault:channels/other")
+		assert.EqualError(t, err, "error resolving secret vault:channels/other: no such secret")
+	}
+	assert.Equal(t, 3, fetches)
+}
@@ -0,0 +1,141 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/gocommon/httpx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/nyaruka/courier"
+
+// attribute keys used on courier spans
+const (
+	AttrChannelUUID = attribute.Key("courier.channel.uuid")
+	AttrChannelType = attribute.Key("courier.channel.type")
+	AttrMsgID       = attribute.Key("courier.msg.id")
+	AttrMsgStatus   = attribute.Key("courier.msg.status")
+	AttrEvents      = attribute.Key("courier.events")
+)
+
+// Tracer returns the tracer for courier spans, which will be a noop tracer unless Start has been called
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start configures tracing to export spans to the given OTLP HTTP endpoint, e.g. http://localhost:4318, sampling the
+// given ratio of traces. It returns a function which should be called on shutdown to flush any pending spans.
+func Start(ctx context.Context, endpoint string, sampleRatio float64, version, instance string) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("error creating trace exporter: %w", err)
+	}
+
+	resource, err := sdkresource.Merge(sdkresource.Default(), sdkresource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("courier"),
+		semconv.ServiceVersion(version),
+		semconv.ServiceInstanceID(instance),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("error creating trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// ChannelAttrs returns the attributes for a span relating to the given channel
+func ChannelAttrs(uuid, channelType string) []attribute.KeyValue {
+	return []attribute.KeyValue{AttrChannelUUID.String(uuid), AttrChannelType.String(channelType)}
+}
+
+// EndSpan ends the given span, recording the given error on it if there was one
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// StartHTTP starts a span for the given request as a child of the given span, and injects headers into the request so
+// that the trace can be continued by the receiver. The returned span should be ended with EndHTTP.
+func StartHTTP(parent trace.Span, r *http.Request) trace.Span {
+	if parent == nil || !parent.IsRecording() {
+		return trace.SpanFromContext(context.Background()) // a noop span
+	}
+
+	ctx, span := startHTTP(trace.ContextWithSpan(r.Context(), parent), r.Method, r.URL.Hostname())
+
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+	return span
+}
+
+// EndHTTP ends a span started with StartHTTP using the trace of the request, which may be nil if it failed
+func EndHTTP(span trace.Span, t *httpx.Trace) {
+	if !span.IsRecording() {
+		return
+	}
+
+	endHTTP(span, t, time.Now())
+}
+
+// RecordHTTP records the given HTTP trace as a child span of the given span. It's called after the request has been
+// made so the span is given the start and end times of the trace, and can't be continued by the receiver. Where
+// possible requests should be traced with StartHTTP and EndHTTP instead.
+func RecordHTTP(parent trace.Span, t *httpx.Trace) {
+	if parent == nil || !parent.IsRecording() {
+		return
+	}
+
+	ctx := trace.ContextWithSpan(context.Background(), parent)
+	_, span := startHTTP(ctx, t.Request.Method, t.Request.URL.Hostname(), trace.WithTimestamp(t.StartTime))
+
+	endHTTP(span, t, t.EndTime)
+}
+
+func startHTTP(ctx context.Context, method, host string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	opts = append(opts,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPRequestMethodKey.String(method), semconv.ServerAddress(host)),
+	)
+	return Tracer().Start(ctx, "HTTP "+method, opts...)
+}
+
+func endHTTP(span trace.Span, t *httpx.Trace, end time.Time) {
+	if t != nil {
+		span.SetAttributes(semconv.HTTPRequestResendCount(t.Retries))
+	}
+
+	if t != nil && t.Response != nil {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(t.Response.StatusCode))
+		if t.Response.StatusCode >= 400 {
+			span.SetStatus(codes.Error, t.Response.Status)
+		}
+	} else {
+		span.SetStatus(codes.Error, "no response")
+	}
+
+	span.End(trace.WithTimestamp(end))
+}
@@ -0,0 +1,149 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/nyaruka/courier/utils/tracing"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRecordHTTP(t *testing.T) {
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://api.messages.com/send.json": {
+			httpx.NewMockResponse(200, nil, []byte(`{"status":"success"}`)),
+			httpx.NewMockResponse(503, nil, []byte(`unavailable`)),
+		},
+	}))
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	recorder := tracetest.NewSpanRecorder()
+	defaultProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(defaultProvider)
+
+	_, parent := tracing.Tracer().Start(context.Background(), "send")
+
+	for range 2 {
+		req, _ := http.NewRequest("POST", "https://api.messages.com/send.json", nil)
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+
+		tracing.RecordHTTP(parent, trace)
+	}
+
+	parent.End()
+
+	// recording against nil or non-recording spans is a noop
+	tracing.RecordHTTP(nil, nil)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+
+	assert.Equal(t, "HTTP POST", spans[0].Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Contains(t, spans[0].Attributes(), attribute.String("server.address", "api.messages.com"))
+	assert.Contains(t, spans[0].Attributes(), attribute.Int("http.response.status_code", 200))
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	assert.Contains(t, spans[1].Attributes(), attribute.Int("http.response.status_code", 503))
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+
+	assert.Equal(t, "send", spans[2].Name())
+}
+
+func TestStartHTTP(t *testing.T) {
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://api.messages.com/send.json": {
+			httpx.NewMockResponse(200, nil, []byte(`{"status":"success"}`)),
+			httpx.MockConnectionError,
+		},
+	}))
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	recorder := tracetest.NewSpanRecorder()
+	defaultProvider, defaultPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(defaultProvider)
+		otel.SetTextMapPropagator(defaultPropagator)
+	}()
+
+	_, parent := tracing.Tracer().Start(context.Background(), "send")
+
+	req, _ := http.NewRequest("POST", "https://api.messages.com/send.json", nil)
+	span := tracing.StartHTTP(parent, req)
+	trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+	require.NoError(t, err)
+	tracing.EndHTTP(span, trace)
+
+	// span context is propagated to the receiver
+	assert.Contains(t, req.Header.Get("traceparent"), span.SpanContext().TraceID().String())
+	assert.Contains(t, req.Header.Get("traceparent"), span.SpanContext().SpanID().String())
+
+	req, _ = http.NewRequest("POST", "https://api.messages.com/send.json", nil)
+	span = tracing.StartHTTP(parent, req)
+	trace, err = httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+	assert.Error(t, err)
+	tracing.EndHTTP(span, trace)
+
+	parent.End()
+
+	// starting against nil spans gives us a non-recording span and no headers
+	req, _ = http.NewRequest("POST", "https://api.messages.com/send.json", nil)
+	span = tracing.StartHTTP(nil, req)
+	assert.False(t, span.IsRecording())
+	assert.Equal(t, "", req.Header.Get("traceparent"))
+	tracing.EndHTTP(span, nil)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+
+	assert.Equal(t, "HTTP POST", spans[0].Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Contains(t, spans[0].Attributes(), attribute.Int("http.response.status_code", 200))
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	assert.Equal(t, "HTTP POST", spans[1].Name())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+
+	assert.Equal(t, "send", spans[2].Name())
+}
+
+func TestEndSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	defaultProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(defaultProvider)
+
+	_, span1 := tracing.Tracer().Start(context.Background(), "msg_write")
+	tracing.EndSpan(span1, nil)
+
+	_, span2 := tracing.Tracer().Start(context.Background(), "msg_write")
+	tracing.EndSpan(span2, errors.New("boom"))
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Equal(t, "boom", spans[1].Status().Description)
+	assert.Len(t, spans[1].Events(), 1) // the recorded error
+}
+
+func TestChannelAttrs(t *testing.T) {
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String("courier.channel.uuid", "fef91e9b-a6ed-44fb-b6ce-feed8af585a8"),
+		attribute.String("courier.channel.type", "TG"),
+	}, tracing.ChannelAttrs("fef91e9b-a6ed-44fb-b6ce-feed8af585a8", "TG"))
+}
@@ -0,0 +1,12 @@
+-- KEYS: [PendingKey]
+-- ARGV: [Now, LeaseUntil]
+
+-- get the first job that is due, which includes jobs whose lease expired before their attempt was completed
+local result = redis.call("zrangebyscore", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, 1)
+if not result[1] then
+    return ""
+end
+
+-- lease it to the caller by pushing back when it's next due, and it's removed when the attempt is completed
+redis.call("zadd", KEYS[1], ARGV[2], result[1])
+return result[1]
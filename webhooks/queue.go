@@ -0,0 +1,210 @@
+package webhooks
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/dates"
+)
+
+const (
+	// the sorted set of jobs waiting to be delivered, scored by when they are next due. Jobs being attempted stay in
+	// the set but are leased to a worker by being pushed back by the lease duration, so if the instance attempting a
+	// job dies, any instance can reclaim it once its lease expires.
+	pendingKey = "webhooks:pending"
+
+	// the list of jobs which failed all their delivery attempts, newest first
+	deadKey = "webhooks:dead"
+
+	// the maximum number of jobs we keep in the dead list
+	maxDead = 1000
+
+	// the maximum number of delivery attempts for a job
+	maxAttempts = 6
+
+	// the delay before the first retry, which doubles after each subsequent attempt
+	retryBackoff = 15 * time.Second
+
+	// the maximum time we'll wait for a single delivery attempt
+	sendTimeout = 30 * time.Second
+
+	// how long a worker has to complete a delivery attempt before the job can be reclaimed
+	leaseDuration = 2 * sendTimeout
+)
+
+// ErrNotConfigured should be returned by a send function when the job's channel or webhook no longer exists, in which
+// case the job is dropped rather than retried
+var ErrNotConfigured = errors.New("webhook not configured")
+
+// SendFunc makes a delivery attempt for the given job, returning an error if it should be retried
+type SendFunc func(context.Context, *Job) error
+
+// Queue queues the given job to be delivered as soon as possible
+func Queue(rc redis.Conn, job *Job) error {
+	return queueAt(rc, job, dates.Now())
+}
+
+func queueAt(rc redis.Conn, job *Job, due time.Time) error {
+	jobJSON, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("error marshaling webhook job: %w", err)
+	}
+
+	_, err = rc.Do("ZADD", pendingKey, epoch(due), jobJSON)
+	return err
+}
+
+// completes an attempt of a leased job which doesn't need to be retried
+func complete(rc redis.Conn, job *Job) error {
+	_, err := rc.Do("ZREM", pendingKey, job.leased)
+	return err
+}
+
+// completes an attempt of a leased job by queuing it to be retried at the given time
+func retryAt(rc redis.Conn, job *Job, due time.Time) error {
+	jobJSON, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("error marshaling webhook job: %w", err)
+	}
+
+	rc.Send("MULTI")
+	rc.Send("ZREM", pendingKey, job.leased)
+	rc.Send("ZADD", pendingKey, epoch(due), jobJSON)
+	_, err = rc.Do("EXEC")
+	return err
+}
+
+// completes an attempt of a leased job which has failed all its attempts by adding it to the dead list, trimming the
+// list to our max size
+func deadLetter(rc redis.Conn, job *Job) error {
+	jobJSON, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("error marshaling webhook job: %w", err)
+	}
+
+	rc.Send("MULTI")
+	rc.Send("ZREM", pendingKey, job.leased)
+	rc.Send("LPUSH", deadKey, jobJSON)
+	rc.Send("LTRIM", deadKey, 0, maxDead-1)
+	_, err = rc.Do("EXEC")
+	return err
+}
+
+//go:embed lua/pop.lua
+var luaPop string
+var scriptPop = redis.NewScript(1, luaPop)
+
+// pops the next job that is due and leases it to the caller, returning nil if there are none
+func popDue(rc redis.Conn) (*Job, error) {
+	now := dates.Now()
+
+	jobJSON, err := redis.String(scriptPop.Do(rc, pendingKey, epoch(now), epoch(now.Add(leaseDuration))))
+	if err != nil || jobJSON == "" {
+		return nil, err
+	}
+
+	job := &Job{leased: jobJSON}
+	if err := json.Unmarshal([]byte(jobJSON), job); err != nil {
+		// no point retrying a job we can't read
+		rc.Do("ZREM", pendingKey, jobJSON)
+		return nil, fmt.Errorf("error unmarshaling webhook job: %w", err)
+	}
+	return job, nil
+}
+
+// retryDelay returns how long to wait before the next attempt of a job which has been attempted the given number of times
+func retryDelay(attempts int) time.Duration {
+	return retryBackoff * time.Duration(math.Pow(2, float64(attempts-1)))
+}
+
+// StartWorkers starts the given number of goroutines which deliver queued jobs using the given send function until
+// the passed in quitter chan is closed
+func StartWorkers(rp *redis.Pool, quitter chan bool, wg *sync.WaitGroup, count int, send SendFunc) {
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				// keep working until there's nothing due, then wait a bit before checking again
+				for deliverNext(rp, send) {
+					select {
+					case <-quitter:
+						return
+					default:
+					}
+				}
+
+				select {
+				case <-quitter:
+					return
+				case <-time.After(time.Second):
+				}
+			}
+		}()
+	}
+}
+
+// delivers the next due job, returning whether there was one
+func deliverNext(rp *redis.Pool, send SendFunc) bool {
+	rc := rp.Get()
+	defer rc.Close()
+
+	job, err := popDue(rc)
+	if err != nil {
+		slog.Error("error popping webhook job", "error", err)
+		return false
+	}
+	if job == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	job.Attempts++
+	log := slog.With("comp", "webhooks", "webhook_uuid", job.UUID, "channel_uuid", job.ChannelUUID, "attempts", job.Attempts)
+
+	err = send(ctx, job)
+
+	switch {
+	case err == nil:
+		if err := complete(rc, job); err != nil {
+			log.Error("error completing webhook job", "error", err)
+		}
+	case errors.Is(err, ErrNotConfigured):
+		log.Warn("webhook no longer configured, dropping", "error", err)
+
+		if err := complete(rc, job); err != nil {
+			log.Error("error completing webhook job", "error", err)
+		}
+	case job.Attempts >= maxAttempts:
+		log.Error("webhook delivery failed, giving up", "error", err)
+
+		if err := deadLetter(rc, job); err != nil {
+			log.Error("error dead lettering webhook job", "error", err)
+		}
+	default:
+		log.Warn("webhook delivery failed, will retry", "error", err)
+
+		if err := retryAt(rc, job, dates.Now().Add(retryDelay(job.Attempts))); err != nil {
+			log.Error("error requeuing webhook job", "error", err)
+		}
+	}
+
+	return true
+}
+
+func epoch(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/float64(time.Second), 'f', 6, 64)
+}
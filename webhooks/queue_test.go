@@ -0,0 +1,174 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getPool(t *testing.T) *redis.Pool {
+	rp := &redis.Pool{
+		Wait:      true,
+		MaxActive: 5,
+		MaxIdle:   2,
+		Dial: func() (redis.Conn, error) {
+			conn, err := redis.Dial("tcp", "localhost:6379")
+			if err != nil {
+				return nil, err
+			}
+			_, err = conn.Do("SELECT", 0)
+			return conn, err
+		},
+	}
+	rc := rp.Get()
+	defer rc.Close()
+
+	_, err := rc.Do("FLUSHDB")
+	require.NoError(t, err)
+
+	return rp
+}
+
+func TestQueue(t *testing.T) {
+	rp := getPool(t)
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer dates.SetNowFunc(time.Now)
+	dates.SetNowFunc(dates.NewFixedNow(time.Date(2023, 10, 16, 10, 50, 0, 0, time.UTC)))
+
+	job1 := NewJob("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", KindDelivery, "application/json", []byte(`{"id":1}`))
+	job2 := NewJob("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", KindDelivery, "application/json", []byte(`{"id":2}`))
+
+	require.NoError(t, Queue(rc, job1))
+	require.NoError(t, queueAt(rc, job2, dates.Now().Add(30*time.Second)))
+
+	job, err := popDue(rc)
+	assert.NoError(t, err)
+	assert.Equal(t, job1.UUID, job.UUID)
+	assert.Equal(t, KindDelivery, job.Kind)
+	assert.Equal(t, `{"id":1}`, job.Body)
+
+	// first job is leased and second job isn't due yet
+	job, err = popDue(rc)
+	assert.NoError(t, err)
+	assert.Nil(t, job)
+
+	count, err := redis.Int(rc.Do("ZCARD", pendingKey))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	dates.SetNowFunc(dates.NewFixedNow(time.Date(2023, 10, 16, 10, 50, 30, 0, time.UTC)))
+
+	job, err = popDue(rc)
+	assert.NoError(t, err)
+	assert.Equal(t, job2.UUID, job.UUID)
+	assert.NoError(t, complete(rc, job))
+
+	// if the first job's attempt is never completed, it can be reclaimed once its lease expires
+	dates.SetNowFunc(dates.NewFixedNow(time.Date(2023, 10, 16, 10, 51, 0, 0, time.UTC)))
+
+	job, err = popDue(rc)
+	assert.NoError(t, err)
+	assert.Equal(t, job1.UUID, job.UUID)
+	assert.NoError(t, complete(rc, job))
+
+	count, err = redis.Int(rc.Do("ZCARD", pendingKey))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, 15*time.Second, retryDelay(1))
+	assert.Equal(t, 30*time.Second, retryDelay(2))
+	assert.Equal(t, 60*time.Second, retryDelay(3))
+	assert.Equal(t, 4*time.Minute, retryDelay(5))
+}
+
+func TestDeliverNext(t *testing.T) {
+	rp := getPool(t)
+	rc := rp.Get()
+	defer rc.Close()
+
+	require.NoError(t, Queue(rc, NewJob("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", KindDelivery, "application/json", []byte(`{"id":1}`))))
+
+	var attempts []int
+	failing := func(ctx context.Context, j *Job) error {
+		attempts = append(attempts, j.Attempts)
+		return errors.New("boom")
+	}
+
+	assert.True(t, deliverNext(rp, failing))
+	assert.Equal(t, []int{1}, attempts)
+
+	// failed job is requeued for later
+	count, err := redis.Int(rc.Do("ZCARD", pendingKey))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.False(t, deliverNext(rp, failing))
+
+	// until we run out of attempts
+	defer dates.SetNowFunc(time.Now)
+	for i := 2; i <= maxAttempts; i++ {
+		dates.SetNowFunc(dates.NewFixedNow(time.Now().Add(time.Hour * time.Duration(i))))
+		assert.True(t, deliverNext(rp, failing))
+	}
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, attempts)
+
+	count, err = redis.Int(rc.Do("ZCARD", pendingKey))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// and it ends up in the dead list
+	dead, err := redis.Strings(rc.Do("LRANGE", deadKey, 0, -1))
+	assert.NoError(t, err)
+	assert.Len(t, dead, 1)
+	assert.Contains(t, dead[0], `"attempts":6`)
+
+	// jobs whose webhook is no longer configured are dropped without retrying
+	require.NoError(t, Queue(rc, NewJob("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", KindDelivery, "application/json", []byte(`{"id":3}`))))
+
+	attempts = nil
+	notConfigured := func(ctx context.Context, j *Job) error {
+		attempts = append(attempts, j.Attempts)
+		return fmt.Errorf("%w: channel no longer exists", ErrNotConfigured)
+	}
+
+	assert.True(t, deliverNext(rp, notConfigured))
+	assert.Equal(t, []int{1}, attempts)
+
+	count, err = redis.Int(rc.Do("ZCARD", pendingKey))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	dead, err = redis.Strings(rc.Do("LRANGE", deadKey, 0, -1))
+	assert.NoError(t, err)
+	assert.Len(t, dead, 1)
+
+	// check workers deliver and then stop when asked
+	require.NoError(t, Queue(rc, NewJob("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", KindDelivery, "application/json", []byte(`{"id":2}`))))
+
+	delivered := make(chan *Job, 1)
+	quit := make(chan bool)
+	wg := &sync.WaitGroup{}
+
+	StartWorkers(rp, quit, wg, 2, func(ctx context.Context, j *Job) error { delivered <- j; return nil })
+
+	select {
+	case j := <-delivered:
+		assert.Equal(t, `{"id":2}`, j.Body)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "webhook not delivered")
+	}
+
+	close(quit)
+	wg.Wait()
+}
@@ -0,0 +1,129 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/uuids"
+)
+
+const (
+	// SignatureHeader is the header which contains the signature of a webhook request when its config has a secret
+	SignatureHeader = "X-Courier-Signature"
+
+	// UUIDHeader is the header which contains the UUID of the webhook which stays the same across retries
+	UUIDHeader = "X-Courier-Webhook-UUID"
+)
+
+// Config is the configuration of a webhook as stored in channel or org config
+type Config struct {
+	URL     string            `json:"url"     validate:"required,url"`
+	Method  string            `json:"method"  validate:"oneof=GET POST PUT PATCH"`
+	Headers map[string]string `json:"headers"`
+	Secret  string            `json:"secret"`
+}
+
+// ReadConfig reads and validates a webhook config from a config value, e.g. from a channel's config
+func ReadConfig(v any) (*Config, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal webhook config: %w", err)
+	}
+
+	cfg := &Config{}
+	if err := json.Unmarshal(raw, cfg); err != nil {
+		return nil, fmt.Errorf("unable to read webhook config: %w", err)
+	}
+
+	cfg.Method = strings.ToUpper(cfg.Method)
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPost
+	}
+
+	if err := utils.Validate(cfg); err != nil {
+		return nil, fmt.Errorf("invalid webhook config: %w", err)
+	}
+
+	u, _ := url.Parse(cfg.URL)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid webhook config: URL scheme must be http or https")
+	}
+
+	return cfg, nil
+}
+
+// Kind is the kind of webhook a job is delivered to, which determines where its config is read from
+type Kind string
+
+const (
+	// KindDelivery is a delivery webhook configured on a channel or its org
+	KindDelivery = Kind("delivery")
+
+	// KindForward is a webhook configured on a channel to which incoming requests are forwarded
+	KindForward = Kind("forward")
+)
+
+// Job is a webhook request which is queued to be delivered by a worker. It doesn't include the webhook config which
+// is looked up for each attempt, so that changes to it apply to retries and secrets aren't stored in Redis.
+type Job struct {
+	UUID        uuids.UUID          `json:"uuid"`
+	ChannelUUID courier.ChannelUUID `json:"channel_uuid"`
+	Kind        Kind                `json:"kind"`
+	ContentType string              `json:"content_type"`
+	Body        string              `json:"body"`
+	Attempts    int                 `json:"attempts"`
+	CreatedOn   time.Time           `json:"created_on"`
+
+	leased string // the JSON of this job as leased from the queue
+}
+
+// NewJob creates a new job to deliver the given body to the given kind of webhook of the given channel
+func NewJob(ch courier.ChannelUUID, kind Kind, contentType string, body []byte) *Job {
+	return &Job{
+		UUID:        uuids.NewV4(),
+		ChannelUUID: ch,
+		Kind:        kind,
+		ContentType: contentType,
+		Body:        string(body),
+		CreatedOn:   dates.Now(),
+	}
+}
+
+// NewRequest creates the HTTP request for a delivery attempt of this job using the given config, signing it if the
+// config has a secret
+func (j *Job) NewRequest(ctx context.Context, cfg *Config) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, cfg.Method, cfg.URL, bytes.NewReader([]byte(j.Body)))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	if j.ContentType != "" {
+		req.Header.Set("Content-Type", j.ContentType)
+	}
+	req.Header.Set(UUIDHeader, string(j.UUID))
+
+	if cfg.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(cfg.Secret, dates.Now(), []byte(j.Body)))
+	}
+
+	return req, nil
+}
+
+// Sign generates a signature for the given body which includes the time of signing so that receivers can reject
+// replayed requests, e.g. t=1697453400,v1=5257a869e7ecebeda32affa62cdca3fa51cad7e77a0e56ff536d0ce8e108d8bd
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := t.Unix()
+	return fmt.Sprintf("t=%d,v1=%s", ts, utils.SignHMAC256(secret, fmt.Sprintf("%d.%s", ts, body)))
+}
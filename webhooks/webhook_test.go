@@ -0,0 +1,67 @@
+package webhooks_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier/webhooks"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfig(t *testing.T) {
+	cfg, err := webhooks.ReadConfig(map[string]any{"url": "https://example.com/receive"})
+	assert.NoError(t, err)
+	assert.Equal(t, &webhooks.Config{URL: "https://example.com/receive", Method: "POST"}, cfg)
+
+	cfg, err = webhooks.ReadConfig(map[string]any{"url": "http://example.com/receive", "method": "put", "headers": map[string]any{"Authorization": "Token 123"}, "secret": "sesame"})
+	assert.NoError(t, err)
+	assert.Equal(t, &webhooks.Config{URL: "http://example.com/receive", Method: "PUT", Headers: map[string]string{"Authorization": "Token 123"}, Secret: "sesame"}, cfg)
+
+	_, err = webhooks.ReadConfig(map[string]any{})
+	assert.Error(t, err)
+
+	_, err = webhooks.ReadConfig(map[string]any{"url": "ftp://example.com/receive"})
+	assert.EqualError(t, err, "invalid webhook config: URL scheme must be http or https")
+
+	_, err = webhooks.ReadConfig(map[string]any{"url": "https://example.com/receive", "method": "DELETE"})
+	assert.Error(t, err)
+
+	_, err = webhooks.ReadConfig(map[string]any{"url": "https://example.com/receive", "headers": "foo"})
+	assert.Error(t, err)
+}
+
+func TestSign(t *testing.T) {
+	sig := webhooks.Sign("sesame", time.Date(2023, 10, 16, 10, 50, 0, 0, time.UTC), []byte(`{"foo":"bar"}`))
+	assert.Equal(t, "t=1697453400,v1=20efb578eb1241a937a6524d420d51dc8df3324db557bbb299e5d3c55f4dadb3", sig)
+}
+
+func TestJobRequest(t *testing.T) {
+	dates.SetNowFunc(dates.NewFixedNow(time.Date(2023, 10, 16, 10, 50, 0, 0, time.UTC)))
+	defer dates.SetNowFunc(time.Now)
+
+	cfg := &webhooks.Config{URL: "https://example.com/receive", Method: "POST", Headers: map[string]string{"Authorization": "Token 123"}}
+	job := webhooks.NewJob("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", webhooks.KindDelivery, "application/json", []byte(`{"foo":"bar"}`))
+
+	req, err := job.NewRequest(context.Background(), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "https://example.com/receive", req.URL.String())
+	assert.Equal(t, "Token 123", req.Header.Get("Authorization"))
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, string(job.UUID), req.Header.Get(webhooks.UUIDHeader))
+	assert.Equal(t, "", req.Header.Get(webhooks.SignatureHeader))
+
+	body, _ := io.ReadAll(req.Body)
+	assert.Equal(t, `{"foo":"bar"}`, string(body))
+
+	// with a secret the request is signed
+	cfg.Secret = "sesame"
+
+	req, err = job.NewRequest(context.Background(), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, webhooks.Sign("sesame", dates.Now(), []byte(`{"foo":"bar"}`)), req.Header.Get(webhooks.SignatureHeader))
+}